@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Web API with the achievements of a user for one game. Needs an API key
+// from https://steamcommunity.com/dev/apikey.
+const achievementsUrlFormat = `https://api.steampowered.com/ISteamUserStats/GetPlayerAchievements/v1/?key=%v&steamid=%v&appid=%v`
+
+// Achievements change while playing, so they are cached for less time than
+// store data.
+const achievementsCacheDuration = time.Hour * 24
+
+// Achievement progress of a user in a game.
+type AchievementProgress struct {
+	Achieved int
+	Total    int
+}
+
+// Downloads the achievement progress. Games without achievements are
+// reported by the API as errors, so any 4xx answer is treated as "no
+// achievements" and returns a zero total.
+func downloadAchievements(apiKey string, user User, appId string) (*AchievementProgress, error) {
+	response, err := httpGet(fmt.Sprintf(achievementsUrlFormat, url.QueryEscape(apiKey), user.SteamId64, appId))
+	if err != nil {
+		logf(LogDebug, "Loading the achievements of %v failed: %v", appId, err.Error())
+		return nil, err
+	}
+	defer closeResponse(response)
+	// The URL has the API key, which shouldn't end up in logs people share.
+	logf(LogDebug, "Achievements of %v: %v", appId, response.Status)
+	if response.StatusCode == 401 || response.StatusCode == 403 {
+		return nil, errors.New("Steam rejected the Web API key: " + response.Status)
+	}
+	if response.StatusCode == http.StatusTooManyRequests {
+		return nil, errors.New("Steam is limiting the requests for achievements: " + response.Status)
+	}
+	if response.StatusCode >= 400 {
+		return &AchievementProgress{}, nil
+	}
+
+	var stats struct {
+		PlayerStats struct {
+			Achievements []struct {
+				Achieved int
+			}
+		}
+	}
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+
+	progress := &AchievementProgress{Total: len(stats.PlayerStats.Achievements)}
+	for _, achievement := range stats.PlayerStats.Achievements {
+		if achievement.Achieved != 0 {
+			progress.Achieved++
+		}
+	}
+	return progress, nil
+}
+
+// Returns the user's achievement progress in the game, using a cache.
+func GetAchievements(apiKey string, user User, game *Game) (*AchievementProgress, error) {
+	if isNonSteamGame(game) {
+		return &AchievementProgress{}, nil
+	}
+
+	cacheKey := user.SteamId64 + "-" + game.Id
+	progress := &AchievementProgress{}
+	if readCache("achievements", cacheKey, achievementsCacheDuration, progress) {
+		return progress, nil
+	}
+
+	progress, err := downloadAchievements(apiKey, user, game.Id)
+	if err != nil {
+		return nil, err
+	}
+	return progress, writeCache("achievements", cacheKey, progress)
+}
+
+// Returns a badge with the completion percentage, gold when complete, or
+// false if the game has no achievements.
+func achievementBadge(progress *AchievementProgress) (Badge, bool) {
+	if progress.Total == 0 {
+		return Badge{}, false
+	}
+	percentage := progress.Achieved * 100 / progress.Total
+	badge := Badge{Text: strconv.Itoa(percentage) + "%", Color: badgeColor, Anchor: "bottom-left"}
+	if progress.Achieved == progress.Total {
+		badge.Color = color.RGBA{170, 130, 0, 220}
+	}
+	return badge, true
+}
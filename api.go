@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Number of log lines returned by /api/status.
+const apiLogLines = 20
+
+// Game in /api/games.
+type apiGame struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	Installed bool     `json:"installed"`
+	Favorite  bool     `json:"favorite"`
+	Tags      []string `json:"tags"`
+	// State of each asset type, like the list command: "steamgrid",
+	// "custom" or "missing".
+	Images map[string]string `json:"images"`
+}
+
+// User in /api/games.
+type apiUser struct {
+	Name      string    `json:"name"`
+	SteamId32 string    `json:"steam_id32"`
+	SteamId64 string    `json:"steam_id64"`
+	Games     []apiGame `json:"games"`
+}
+
+// Response of /api/status, and of the other calls that change the run.
+type apiStatus struct {
+	Running   bool   `json:"running"`
+	Cancelled bool   `json:"cancelled"`
+	Paused    bool   `json:"paused"`
+	User      string `json:"user"`
+	Done      int    `json:"done"`
+	Total     int    `json:"total"`
+	// Progress across every user and asset type. The time left is -1 until
+	// it can be estimated.
+	TotalGames     int     `json:"total_games"`
+	DoneGames      int     `json:"done_games"`
+	TotalImages    int     `json:"total_images"`
+	DoneImages     int     `json:"done_images"`
+	Bytes          int64   `json:"bytes_downloaded"`
+	GamesPerMinute float64 `json:"games_per_minute"`
+	SecondsLeft    int     `json:"seconds_left"`
+	// State of each image in the run, by game id and asset suffix like
+	// "620p".
+	States map[string]string `json:"states"`
+	Log    []string          `json:"log"`
+}
+
+// Error response of the API.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// Writes a value as the JSON response.
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Returns false and answers with an error if the request doesn't use the
+// given method.
+func checkApiMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJson(w, http.StatusMethodNotAllowed, apiError{"Expected " + method})
+		return false
+	}
+	return true
+}
+
+// Returns the state of the current or last run.
+func (server *WebServer) getStatus() apiStatus {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	status := apiStatus{
+		Running:   server.running,
+		Cancelled: server.cancelled,
+		Paused:    server.paused,
+		User:      server.runUser,
+		Done:      server.done,
+		Total:     server.total,
+		States:    make(map[string]string),
+		Log:       server.log,
+	}
+	stats := server.stats
+	status.TotalGames, status.DoneGames = stats.TotalGames, stats.DoneGames
+	status.TotalImages, status.DoneImages = stats.TotalImages, stats.DoneImages
+	status.Bytes, status.GamesPerMinute, status.SecondsLeft = stats.Bytes, stats.GamesPerMinute(), -1
+	if remaining, ok := stats.Remaining(); ok {
+		status.SecondsLeft = int(remaining.Seconds())
+	}
+	for key, state := range server.states {
+		status.States[key] = state
+	}
+	if len(status.Log) > apiLogLines {
+		status.Log = status.Log[len(status.Log)-apiLogLines:]
+	}
+	status.Log = append([]string{}, status.Log...)
+	return status
+}
+
+// Lists the games of every user and the state of their images:
+// GET /api/games, optionally with ?user=NAME_OR_ID and ?games=FILTER
+// like the command line options.
+func (server *WebServer) handleApiGames(w http.ResponseWriter, r *http.Request) {
+	if !checkApiMethod(w, r, "GET") {
+		return
+	}
+
+	server.mutex.Lock()
+	users, allGames := server.users, server.games
+	server.mutex.Unlock()
+
+	users, err := FilterUsers(users, r.FormValue("user"))
+	if err != nil {
+		writeJson(w, http.StatusNotFound, apiError{err.Error()})
+		return
+	}
+	response := make([]apiUser, 0, len(users))
+	for _, user := range users {
+		games := make(map[string]*Game)
+		for id, game := range allGames[user.SteamId32] {
+			games[id] = game
+		}
+		FilterGames(games, r.FormValue("games"))
+
+		apiUser := apiUser{Name: user.Name, SteamId32: user.SteamId32, SteamId64: user.SteamId64, Games: make([]apiGame, 0, len(games))}
+		for _, game := range SortGames(games, false) {
+			images := make(map[string]string)
+			for _, asset := range assetTypes {
+				images[asset.Name] = describeGridImage(GetGridImageSource(user, game, asset))
+			}
+			apiUser.Games = append(apiUser.Games, apiGame{game.Id, game.Name, game.Installed, game.Favorite, game.AllTags(), images})
+		}
+		response = append(response, apiUser)
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+// Returns the progress of the current or last run: GET /api/status.
+func (server *WebServer) handleApiStatus(w http.ResponseWriter, r *http.Request) {
+	if !checkApiMethod(w, r, "GET") {
+		return
+	}
+	writeJson(w, http.StatusOK, server.getStatus())
+}
+
+// Starts a download run: POST /api/refresh, optionally with games=FILTER
+// to refresh only some games, like after installing one. Answers 409 if a
+// run is going on already.
+func (server *WebServer) handleApiRefresh(w http.ResponseWriter, r *http.Request) {
+	if !checkApiMethod(w, r, "POST") {
+		return
+	}
+	if !server.startRun(strings.TrimSpace(r.FormValue("games"))) {
+		writeJson(w, http.StatusConflict, apiError{"A run is going on already."})
+		return
+	}
+	writeJson(w, http.StatusAccepted, server.getStatus())
+}
+
+// Cancels the current run after the game being processed:
+// POST /api/cancel.
+func (server *WebServer) handleApiCancel(w http.ResponseWriter, r *http.Request) {
+	if !checkApiMethod(w, r, "POST") {
+		return
+	}
+	server.cancel()
+	writeJson(w, http.StatusOK, server.getStatus())
+}
+
+// Pauses the current run after the game being processed: POST /api/pause.
+func (server *WebServer) handleApiPause(w http.ResponseWriter, r *http.Request) {
+	if !checkApiMethod(w, r, "POST") {
+		return
+	}
+	server.setPaused(true)
+	writeJson(w, http.StatusOK, server.getStatus())
+}
+
+// Resumes a paused run: POST /api/resume.
+func (server *WebServer) handleApiResume(w http.ResponseWriter, r *http.Request) {
+	if !checkApiMethod(w, r, "POST") {
+		return
+	}
+	server.setPaused(false)
+	writeJson(w, http.StatusOK, server.getStatus())
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kind of image Steam shows for a game in the library, each saved as its own
+// file in the grid folder.
+type AssetType struct {
+	// Name used in the --assets option and for the overlays subfolder.
+	Name string
+	// Appended to the game id in the grid file name, like "440p.jpg".
+	Suffix string
+	// Size of the official images, which overlay offsets are designed for.
+	Width, Height int
+	// URLs of the official images, formatted with the game id.
+	UrlFormats []string
+	// True if the game badges are drawn on this asset. Heroes are mostly
+	// hidden behind the game page, and logos are transparent.
+	Badges bool
+}
+
+// Horizontal grid image, the only one in older Steam versions.
+var bannerAsset = &AssetType{
+	Name:       "banner",
+	Width:      bannerWidth,
+	Height:     bannerHeight,
+	UrlFormats: []string{akamaiUrlFormat, steamCdnUrlFormat},
+	Badges:     true,
+}
+
+// All asset types, in the order they are processed.
+var assetTypes = []*AssetType{
+	bannerAsset,
+	{
+		Name:       "portrait",
+		Suffix:     "p",
+		Width:      600,
+		Height:     900,
+		UrlFormats: []string{`https://steamcdn-a.akamaihd.net/steam/apps/%v/library_600x900_2x.jpg`},
+		Badges:     true,
+	},
+	{
+		Name:       "hero",
+		Suffix:     "_hero",
+		Width:      1920,
+		Height:     620,
+		UrlFormats: []string{`https://steamcdn-a.akamaihd.net/steam/apps/%v/library_hero.jpg`},
+	},
+	{
+		Name:       "logo",
+		Suffix:     "_logo",
+		Width:      640,
+		Height:     360,
+		UrlFormats: []string{`https://steamcdn-a.akamaihd.net/steam/apps/%v/logo.png`},
+	},
+}
+
+// Returns the asset types in a comma separated list of names, like
+// "banner, portrait".
+func GetAssetTypes(names string) ([]*AssetType, error) {
+	names = strings.ToLower(names)
+	if names == "all" {
+		return assetTypes, nil
+	}
+
+	assets := make([]*AssetType, 0)
+	for _, name := range splitList(names) {
+		found := false
+		for _, asset := range assetTypes {
+			if asset.Name == name {
+				assets = append(assets, asset)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("Unknown asset type '" + name + "', expected 'all' or some of: banner, portrait, hero, logo")
+		}
+	}
+	return assets, nil
+}
+
+// Returns the folder with the overlays for an asset type. Banner overlays are
+// at the top of the overlays folder, as always, and the others in a subfolder
+// named after the asset, like "overlays by category/portrait".
+func getAssetOverlaysDir(overlaysDir string, asset *AssetType) string {
+	if asset == bannerAsset {
+		return overlaysDir
+	}
+	return filepath.Join(overlaysDir, asset.Name)
+}
+
+// Extensions of the grid image files.
+var gridImageExts = []string{".jpg", ".jpeg", ".png"}
+
+// Loads the existing grid image of the given asset type into the game,
+// preferring the backup of the original if there is one. Without an image,
+// the path is set to where a new one should be saved.
+func LoadGridImage(user User, game *Game, asset *AssetType) {
+	gridDir := getGridDir(user)
+	base := game.Id + asset.Suffix
+	game.ImagePath = filepath.Join(gridDir, base+".jpg")
+	game.ClearImage()
+	game.ImageSource = ""
+	game.ImageUrl = ""
+	// Only the path, the image is read when it's needed.
+	if backupPath := findBackup(user, game.Id, asset); backupPath != "" {
+		if fileExists(backupPath) {
+			game.ImagePath = filepath.Join(gridDir, base+filepath.Ext(backupPath))
+			game.ImageFile = backupPath
+			game.ImageSource = "backup"
+			game.ImageUrl = backupPath
+			return
+		}
+	}
+	for _, ext := range gridImageExts {
+		if path := filepath.Join(gridDir, base+ext); fileExists(path) {
+			game.ImagePath = path
+			game.ImageFile = path
+			game.ImageSource = "manual customization"
+			game.ImageUrl = game.ImagePath
+			return
+		}
+	}
+}
+
+// Returns where the existing grid image of a game came from, like
+// LoadGridImage, but without reading it: "backup", "manual customization",
+// or "" if there's none.
+func GetGridImageSource(user User, game *Game, asset *AssetType) string {
+	if findBackup(user, game.Id, asset) != "" {
+		return "backup"
+	}
+	gridDir := getGridDir(user)
+	for _, ext := range gridImageExts {
+		if fileExists(filepath.Join(gridDir, game.Id+asset.Suffix+ext)) {
+			return "manual customization"
+		}
+	}
+	return ""
+}
+
+// Returns true if the game has an image, in memory or in a file.
+func (game *Game) HasImage() bool {
+	return game.ImageBytes != nil || game.ImageFile != ""
+}
+
+// Forgets the image of the game, so games done don't keep it in memory.
+func (game *Game) ClearImage() {
+	game.ImageBytes, game.ImageFile = nil, ""
+}
+
+// Reads the image from its file into ImageBytes, for the steps that decode
+// it.
+func (game *Game) LoadImageBytes() error {
+	if game.ImageBytes != nil || game.ImageFile == "" {
+		return nil
+	}
+	imageBytes, err := ioutil.ReadFile(game.ImageFile)
+	if err != nil {
+		return err
+	}
+	game.ImageBytes = imageBytes
+	return nil
+}
+
+// Opens the image of the game to read it, from memory or from its file.
+func (game *Game) OpenImage() (io.ReadCloser, error) {
+	if game.ImageBytes != nil || game.ImageFile == "" {
+		return ioutil.NopCloser(bytes.NewReader(game.ImageBytes)), nil
+	}
+	return os.Open(game.ImageFile)
+}
+
+// Returns the size of the image of the game in bytes, or 0 if it has none.
+func (game *Game) ImageSize() int64 {
+	if game.ImageBytes != nil || game.ImageFile == "" {
+		return int64(len(game.ImageBytes))
+	}
+	info, err := os.Stat(game.ImageFile)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Returns the hex SHA-256 of the image of the game, like hashImage.
+func (game *Game) ImageHash() (string, error) {
+	if game.ImageBytes != nil || game.ImageFile == "" {
+		return hashImage(game.ImageBytes), nil
+	}
+	return hashFile(game.ImageFile)
+}
+
+// Writes the image of the game to a file in the Steam folder, like
+// writeFile, copying its file when it's not in memory.
+func writeGameImage(path string, game *Game) error {
+	// A file can't be copied over itself.
+	if game.ImageFile == path {
+		if err := game.LoadImageBytes(); err != nil {
+			return err
+		}
+	}
+	reader, err := game.OpenImage()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return writeFileFrom(game.Output, path, reader, game.ImageSize())
+}
+
+// Writes the image of the game to its grid path, and deletes the grid image
+// of the same asset type with another extension, left when the format
+// changed, like a JPEG banner replaced by a PNG. Steam would pick either.
+func writeGridImage(game *Game) error {
+	if err := writeGameImage(game.ImagePath, game); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(game.ImagePath, filepath.Ext(game.ImagePath))
+	for _, ext := range gridImageExts {
+		if path := base + ext; path != game.ImagePath {
+			if err := removeFile(game.Output, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -1,20 +1,518 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// If a game has a custom image, backs it up by appending "(original)" to the
-// file name.
-func BackupGame(game *Game) error {
-	if game.ImagePath != "" && game.ImageBytes != nil {
-		ext := filepath.Ext(game.ImagePath)
-		base := filepath.Base(game.ImagePath)
-		backupPath := filepath.Join(filepath.Dir(game.ImagePath), strings.TrimSuffix(base, ext)+" (original)"+ext)
-		return ioutil.WriteFile(backupPath, game.ImageBytes, 0666)
-	} else {
+// Returns the folder where steamgrid keeps data that must last, like the
+// backups: ~/.local/share/steamgrid on Linux, %AppData%\steamgrid on
+// Windows and ~/Library/Application Support/steamgrid on macOS. Without a
+// home folder, it's next to the program.
+func getDataDir() string {
+	if runtime.GOOS != "windows" && runtime.GOOS != "darwin" {
+		if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+			return filepath.Join(dir, "steamgrid")
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".local", "share", "steamgrid")
+		}
+	} else if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "steamgrid")
+	}
+	return filepath.Join(filepath.Dir(os.Args[0]), "steamgrid data")
+}
+
+// Returns the folder with the backups of a user, outside the grid folder so
+// they don't clutter it or confuse Steam.
+func getBackupsDir(user User) string {
+	return filepath.Join(getDataDir(), "backups", user.SteamId32)
+}
+
+// Returns the folder with the backup and the versions of an image, like
+// "backups/12345678/440/portrait".
+func getImageBackupDir(user User, gameId string, asset *AssetType) string {
+	return filepath.Join(getBackupsDir(user), gameId, asset.Name)
+}
+
+// Returns the path of the backup of the original image of a game, or "" if
+// there's none.
+func findBackup(user User, gameId string, asset *AssetType) string {
+	dir := getImageBackupDir(user, gameId, asset)
+	for _, ext := range []string{".jpg", ".jpeg", ".png"} {
+		if path := filepath.Join(dir, "original"+ext); fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// Skips every backup and version, given with --no-backup.
+var noBackup = new(bool)
+
+// If a game has a custom image, backs it up as the original, in the backups
+// folder. Does nothing with --no-backup.
+func BackupGame(user User, game *Game, asset *AssetType) error {
+	if game.ImagePath == "" || !game.HasImage() || *noBackup {
 		return nil
 	}
+	ext := filepath.Ext(game.ImagePath)
+	backupPath := filepath.Join(getImageBackupDir(user, game.Id, asset), "original"+ext)
+	// The new original may have another extension than the old one.
+	if old := findBackup(user, game.Id, asset); old != "" && old != backupPath {
+		if err := removeFile(game.Output, old); err != nil {
+			return err
+		}
+	}
+	if !*dryRun {
+		if err := os.MkdirAll(filepath.Dir(backupPath), 0777); err != nil {
+			return err
+		}
+	}
+	return writeGameImage(backupPath, game)
+}
+
+// Name of the file that marks a backup as something we downloaded or
+// generated, rather than an image the user had, with the source it came
+// from. Restoring removes those, leaving the game as it was before.
+const downloadedMarkName = "downloaded"
+
+// Remembers where the backup just made for a game came from, so restoring
+// can tell the user's own images from ours, and --force-refresh can pick
+// them by source.
+func RecordBackupSource(user User, game *Game, asset *AssetType) error {
+	if *dryRun || *noBackup || game.ImageSource == "backup" {
+		// Nothing new was backed up.
+		return nil
+	}
+	markPath := filepath.Join(getImageBackupDir(user, game.Id, asset), downloadedMarkName)
+	if game.ImageSource == "manual customization" {
+		return removeFile(game.Output, markPath)
+	}
+	source := game.ImageSource
+	if source == "cache" {
+		// Pre-fetched from the same official URLs.
+		source = "download"
+	}
+	if previous, err := ioutil.ReadFile(markPath); err == nil && string(previous) == source {
+		return nil
+	}
+	return writeFile(game.Output, markPath, []byte(source))
+}
+
+// Returns where the backup of the original of a game came from, as
+// recorded by RecordBackupSource: "manual customization" if it's the
+// user's own image.
+func getBackupSource(user User, gameId string, asset *AssetType) string {
+	mark, err := ioutil.ReadFile(filepath.Join(getImageBackupDir(user, gameId, asset), downloadedMarkName))
+	if err != nil {
+		return "manual customization"
+	} else if len(mark) == 0 {
+		// Marked before the source was recorded.
+		return "download"
+	}
+	return string(mark)
+}
+
+// Name of the file with the SHA-256 of the image we installed last, to tell
+// when it was replaced by hand or with Steam's "Set Custom Artwork".
+const installedHashName = "installed.sha256"
+
+// What to do with images changed by hand since the last run, given with
+// --custom-images: "skip", "adopt" or "overwrite".
+var customImages = new(string)
+
+// Remembers the image just installed for a game, to notice when someone
+// replaces it.
+func RecordInstalledImage(user User, game *Game, asset *AssetType) error {
+	if *dryRun {
+		return nil
+	}
+	dir := getImageBackupDir(user, game.Id, asset)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	hash, err := game.ImageHash()
+	if err != nil {
+		return err
+	}
+	return writeFile(game.Output, filepath.Join(dir, installedHashName), []byte(hash))
+}
+
+// Returns the current grid image of a game, whatever its extension, or nil
+// if there's none.
+func loadCurrentGridImage(user User, game *Game, asset *AssetType) (path string, imageBytes []byte) {
+	for _, ext := range gridImageExts {
+		path = filepath.Join(getGridDir(user), game.Id+asset.Suffix+ext)
+		if imageBytes, err := ioutil.ReadFile(path); err == nil {
+			return path, imageBytes
+		}
+	}
+	return "", nil
+}
+
+// Returns true if the grid image of a game is not the one we installed
+// last. Images installed before we kept track, and deleted ones, are
+// not considered changed.
+func IsChangedByHand(user User, game *Game, asset *AssetType) bool {
+	installed, err := ioutil.ReadFile(filepath.Join(getImageBackupDir(user, game.Id, asset), installedHashName))
+	if err != nil {
+		return false
+	}
+	_, current := loadCurrentGridImage(user, game, asset)
+	if current == nil {
+		return false
+	}
+	sum := sha256.Sum256(current)
+	return !bytes.Equal(bytes.TrimSpace(installed), []byte(hex.EncodeToString(sum[:])))
+}
+
+// Grid image and backup names: the game id, the asset suffix, the optional
+// backup mark of older versions (including an old bug that doubled the
+// dot) and the extension.
+var gridFilePattern = regexp.MustCompile(`^(\d+)(p|_hero|_logo)?( \(original\)\.?)?\.(jpg|jpeg|png)$`)
+
+// Returns the asset type with the given grid file suffix, like "p".
+func getAssetTypeBySuffix(suffix string) *AssetType {
+	for _, asset := range assetTypes {
+		if asset.Suffix == suffix {
+			return asset
+		}
+	}
+	return nil
+}
+
+// Returns the grid folder of a user.
+func getGridDir(user User) string {
+	return filepath.Join(user.Dir, "config", "grid")
+}
+
+// How long older versions remembered which backups were downloaded.
+const generatedImagesMaxAge = 10 * 365 * 24 * time.Hour
+
+// Moves the "(original)" backups and the versions that older versions kept
+// in the grid folder to the backups folder, along with the record of the
+// downloaded ones. Returns the number of backups moved.
+func MigrateBackups(user User) (int, error) {
+	gridDir := getGridDir(user)
+	files, err := ioutil.ReadDir(gridDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	// The right names go first, so they win over the doubled dots.
+	sort.SliceStable(files, func(i, j int) bool {
+		return !strings.Contains(files[i].Name(), "..") && strings.Contains(files[j].Name(), "..")
+	})
+	nMoved := 0
+	for _, file := range files {
+		groups := gridFilePattern.FindStringSubmatch(file.Name())
+		if groups == nil || groups[3] == "" {
+			continue
+		}
+		path := filepath.Join(gridDir, file.Name())
+		asset := getAssetTypeBySuffix(groups[2])
+		if findBackup(user, groups[1], asset) != "" {
+			// A duplicate from the old bug.
+			if err := os.Remove(path); err != nil {
+				return nMoved, err
+			}
+			continue
+		}
+		if err := moveFile(path, filepath.Join(getImageBackupDir(user, groups[1], asset), "original."+groups[4])); err != nil {
+			return nMoved, err
+		}
+		nMoved++
+	}
+
+	versionsDir := filepath.Join(gridDir, "versions")
+	if dirs, err := ioutil.ReadDir(versionsDir); err == nil {
+		for _, dir := range dirs {
+			groups := gridFilePattern.FindStringSubmatch(dir.Name() + ".jpg")
+			if groups == nil || !dir.IsDir() {
+				continue
+			}
+			versions, err := ioutil.ReadDir(filepath.Join(versionsDir, dir.Name()))
+			if err != nil {
+				return nMoved, err
+			}
+			for _, version := range versions {
+				to := filepath.Join(getImageBackupDir(user, groups[1], getAssetTypeBySuffix(groups[2])), "versions", version.Name())
+				if err := moveFile(filepath.Join(versionsDir, dir.Name(), version.Name()), to); err != nil {
+					return nMoved, err
+				}
+			}
+		}
+		if err := os.RemoveAll(versionsDir); err != nil {
+			return nMoved, err
+		}
+	}
+
+	generated := make(map[string]bool)
+	if readCache("generated", user.SteamId32, generatedImagesMaxAge, &generated) {
+		for base := range generated {
+			groups := gridFilePattern.FindStringSubmatch(base + ".jpg")
+			if groups == nil {
+				continue
+			}
+			dir := getImageBackupDir(user, groups[1], getAssetTypeBySuffix(groups[2]))
+			if !fileExists(dir) {
+				continue
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, downloadedMarkName), []byte{}, 0644); err != nil {
+				return nMoved, err
+			}
+		}
+		if err := removeCache("generated", user.SteamId32); err != nil {
+			return nMoved, err
+		}
+	}
+	return nMoved, nil
+}
+
+// Backup of an image, found in the backups folder.
+type imageBackup struct {
+	GameId string
+	Asset  *AssetType
+	// Path of the original, "" if only versions are left.
+	Path string
+}
+
+// Lists the backups of every image of a user.
+func listBackups(user User) ([]imageBackup, error) {
+	backupsDir := getBackupsDir(user)
+	games, err := ioutil.ReadDir(backupsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	backups := make([]imageBackup, 0)
+	for _, game := range games {
+		for _, asset := range assetTypes {
+			if fileExists(getImageBackupDir(user, game.Name(), asset)) {
+				backups = append(backups, imageBackup{game.Name(), asset, findBackup(user, game.Name(), asset)})
+			}
+		}
+	}
+	return backups, nil
+}
+
+// Puts the backed up originals back in place of the images we saved, and
+// removes the backups. Images that were not there before us are removed
+// instead. Only the games given are restored, or all if games is nil, and
+// never the protected ones. Returns the number of images restored and
+// removed.
+func RestoreBackups(user User, games map[string]*Game, protected map[string]bool) (nRestored int, nRemoved int, err error) {
+	backups, err := listBackups(user)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	gridDir := getGridDir(user)
+	for _, backup := range backups {
+		if backup.Path == "" || (games != nil && games[backup.GameId] == nil) || protected[backup.GameId] {
+			continue
+		}
+		base := backup.GameId + backup.Asset.Suffix
+		imageBytes, err := ioutil.ReadFile(backup.Path)
+		if err != nil {
+			return nRestored, nRemoved, err
+		}
+
+		// Our image may have a different extension than the original.
+		for _, ext := range []string{".jpg", ".jpeg", ".png"} {
+			err := os.Remove(filepath.Join(gridDir, base+ext))
+			if err != nil && !os.IsNotExist(err) {
+				return nRestored, nRemoved, err
+			}
+		}
+		markPath := filepath.Join(filepath.Dir(backup.Path), downloadedMarkName)
+		if fileExists(markPath) {
+			if err := os.Remove(markPath); err != nil {
+				return nRestored, nRemoved, err
+			}
+			nRemoved++
+		} else {
+			if err := writeFileAtomic(filepath.Join(gridDir, base+filepath.Ext(backup.Path)), imageBytes, 0644); err != nil {
+				return nRestored, nRemoved, err
+			}
+			nRestored++
+		}
+		if err := os.Remove(backup.Path); err != nil {
+			return nRestored, nRemoved, err
+		}
+	}
+	return nRestored, nRemoved, nil
+}
+
+// Grid file deleted by CleanGrid, and why.
+type CleanedFile struct {
+	Name   string
+	Reason string
+}
+
+// Deletes the grid files nobody needs anymore: images and backups of games
+// that are not among the given ones, or not installed if installed isn't
+// nil, and backups whose image was deleted. Non-Steam games are also known
+// by the upper 32 bits of their id, which newer Steam versions use for
+// their images, and are always installed. Files that don't look like grid
+// images are never touched. With archiveDir the files are moved there
+// instead, else deleted through the journal of the run, and in dry-run mode
+// nothing changes. The files of protected games
+// are kept. Returns the files cleaned, with the backups by their path in the
+// backups folder.
+func CleanGrid(user User, games map[string]*Game, installed map[string]bool, archiveDir string) ([]CleanedFile, error) {
+	protected := getProtectedIds(games)
+	known := make(map[string]bool)
+	uninstalled := make(map[string]bool)
+	for id, game := range games {
+		ids := []string{id}
+		if isNonSteamGame(game) {
+			if longId, err := strconv.ParseUint(id, 10, 64); err == nil {
+				ids = append(ids, strconv.FormatUint(longId>>32, 10))
+			}
+		}
+		for _, knownId := range ids {
+			known[knownId] = true
+			protected[knownId] = protected[knownId] || protected[id]
+			if installed != nil && !installed[id] && !isNonSteamGame(game) {
+				uninstalled[knownId] = true
+			}
+		}
+	}
+	reasonFor := func(gameId string) string {
+		if protected[gameId] {
+			return ""
+		} else if !known[gameId] {
+			return "not in the library"
+		} else if uninstalled[gameId] {
+			return "not installed"
+		}
+		return ""
+	}
+
+	cleaned := make([]CleanedFile, 0)
+	clean := func(path string, name string, reason string) error {
+		if !*dryRun {
+			var err error
+			if archiveDir != "" {
+				err = moveFile(path, filepath.Join(archiveDir, user.SteamId32, name))
+			} else {
+				err = removeTree(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		cleaned = append(cleaned, CleanedFile{name, reason})
+		return nil
+	}
+
+	gridDir := getGridDir(user)
+	files, err := ioutil.ReadDir(gridDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, file := range files {
+		groups := gridFilePattern.FindStringSubmatch(file.Name())
+		if groups == nil {
+			continue
+		}
+		if reason := reasonFor(groups[1]); reason != "" {
+			if err := clean(filepath.Join(gridDir, file.Name()), file.Name(), reason); err != nil {
+				return cleaned, err
+			}
+			continue
+		}
+		names[groups[1]+groups[2]] = true
+	}
+
+	backups, err := listBackups(user)
+	if err != nil {
+		return cleaned, err
+	}
+	for _, backup := range backups {
+		dir := getImageBackupDir(user, backup.GameId, backup.Asset)
+		name := filepath.Join("backups", backup.GameId, backup.Asset.Name)
+		reason := reasonFor(backup.GameId)
+		if reason == "" && !protected[backup.GameId] && backup.Path != "" && !names[backup.GameId+backup.Asset.Suffix] {
+			reason = "backup without an image"
+		}
+		if reason != "" {
+			if err := clean(dir, name, reason); err != nil {
+				return cleaned, err
+			}
+		}
+	}
+	return cleaned, nil
+}
+
+// Deletes a file or folder through removeFile, so the journal keeps a copy
+// of each file and undo can bring them back.
+func removeTree(path string) error {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return removeFile(nil, path)
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := removeTree(filepath.Join(path, file.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// Moves a file or folder, even to another drive, creating the destination
+// folder.
+func moveFile(from string, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		files, err := ioutil.ReadDir(from)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := moveFile(filepath.Join(from, file.Name()), filepath.Join(to, file.Name())); err != nil {
+				return err
+			}
+		}
+		return os.Remove(from)
+	}
+	data, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(to, data, 0644); err != nil {
+		return err
+	}
+	return os.Remove(from)
 }
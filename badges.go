@@ -0,0 +1,224 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// Small text label drawn in a corner of the game image, like "120h". Badges
+// are drawn on top of the overlays, and since the game image always starts
+// from the backup of the original, they are updated on every run.
+type Badge struct {
+	Text string
+	// Optional picture drawn before the text, from badgeIcons.
+	Icon string
+	// Background color, the text is always white.
+	Color color.RGBA
+	// Corner where the badge goes: "top-left", "top-right", "bottom-left" or
+	// "bottom-right". Badges in the same corner are placed side by side.
+	Anchor string
+}
+
+// Default background for badges, a translucent black.
+var badgeColor = color.RGBA{0, 0, 0, 190}
+
+// Small pictures for badges, drawn like font glyphs. Rows must have the same
+// length and there must be glyphHeight of them.
+var badgeIcons = map[string][]string{
+	"controller": {
+		"..#######..",
+		".#########.",
+		"##.#####.##",
+		"#...###.#.#",
+		"##.#####.##",
+		".###...###.",
+		".##.....##.",
+	},
+}
+
+// Returns the size in pixels of the badge contents, icon and text.
+func badgeContentSize(badge Badge, scale int) (width, height int) {
+	width, height = textSize(badge.Text, scale)
+	if icon, ok := badgeIcons[badge.Icon]; ok {
+		iconWidth := len(icon[0]) * scale
+		if badge.Text != "" {
+			iconWidth += glyphWidth * scale
+		}
+		width += iconWidth
+	}
+	return width, height
+}
+
+// Draws an icon from badgeIcons with its top left corner at (x, y) and
+// returns its width.
+func drawIcon(dst draw.Image, x, y int, name string, scale int, c color.Color) int {
+	icon, ok := badgeIcons[name]
+	if !ok {
+		return 0
+	}
+	src := image.NewUniform(c)
+	for row, line := range icon {
+		for column, pixel := range line {
+			if pixel != '#' {
+				continue
+			}
+			left, top := x+column*scale, y+row*scale
+			draw.Draw(dst, image.Rect(left, top, left+scale, top+scale), src, image.ZP, draw.Over)
+		}
+	}
+	return len(icon[0]) * scale
+}
+
+// Draws the badges on the image, scaled to its width so they look the same
+// on banners and portraits. Badges in the same corner are laid out from the
+// corner towards the center.
+func drawBadges(img *image.RGBA, badges []Badge) {
+	bounds := img.Bounds()
+	scale := 2 * bounds.Dx() / bannerWidth
+	if scale < 1 {
+		scale = 1
+	}
+	padding := 2 * scale
+	margin := 3 * scale
+
+	// Horizontal position for the next badge in each corner.
+	nextX := make(map[string]int)
+	for _, badge := range badges {
+		contentWidth, contentHeight := badgeContentSize(badge, scale)
+		width, height := contentWidth+2*padding, contentHeight+2*padding
+
+		right := badge.Anchor == "top-right" || badge.Anchor == "bottom-right"
+		bottom := badge.Anchor == "bottom-left" || badge.Anchor == "bottom-right"
+
+		offset, ok := nextX[badge.Anchor]
+		if !ok {
+			offset = margin
+		}
+		nextX[badge.Anchor] = offset + width + margin
+
+		x := bounds.Min.X + offset
+		if right {
+			x = bounds.Max.X - offset - width
+		}
+		y := bounds.Min.Y + margin
+		if bottom {
+			y = bounds.Max.Y - margin - height
+		}
+
+		rect := image.Rect(x, y, x+width, y+height)
+		draw.Draw(img, rect, image.NewUniform(badge.Color), image.ZP, draw.Over)
+		textX := x + padding
+		if iconWidth := drawIcon(img, textX, y+padding, badge.Icon, scale, color.White); iconWidth > 0 {
+			textX += iconWidth + glyphWidth*scale
+		}
+		drawText(img, textX, y+padding, badge.Text, scale, color.White)
+	}
+}
+
+// Formats a playtime in minutes as a short badge text like "45m" or "120h".
+func formatPlaytime(minutes int) string {
+	if minutes < 60 {
+		return strconv.Itoa(minutes) + "m"
+	}
+	return strconv.Itoa(minutes/60) + "h"
+}
+
+// Returns the playtime badge for the game, or false if it was never played.
+func playtimeBadge(game *Game) (Badge, bool) {
+	if game.Playtime <= 0 {
+		return Badge{}, false
+	}
+	return Badge{Text: formatPlaytime(game.Playtime), Color: badgeColor, Anchor: "bottom-right"}, true
+}
+
+// Returns a gamepad badge for games with full (green) or partial (yellow)
+// controller support, according to the store categories, or false if the
+// game has neither.
+func controllerBadge(details *StoreDetails) (Badge, bool) {
+	if details == nil {
+		return Badge{}, false
+	}
+	for _, category := range details.Categories {
+		switch strings.ToLower(category) {
+		case "full controller support":
+			return Badge{Icon: "controller", Color: color.RGBA{40, 130, 60, 220}, Anchor: "top-right"}, true
+		case "partial controller support":
+			return Badge{Icon: "controller", Color: color.RGBA{150, 120, 40, 220}, Anchor: "top-right"}, true
+		}
+	}
+	return Badge{}, false
+}
+
+// Returns a "VR" badge for games that support VR, or "VR ONLY" for games
+// that require it, according to the store categories. Returns false for
+// regular games.
+func vrBadge(details *StoreDetails) (Badge, bool) {
+	if details == nil {
+		return Badge{}, false
+	}
+	supported := false
+	for _, category := range details.Categories {
+		switch strings.ToLower(category) {
+		case "vr only":
+			return Badge{Text: "VR ONLY", Color: color.RGBA{120, 40, 150, 220}, Anchor: "top-right"}, true
+		case "vr support", "vr supported":
+			supported = true
+		}
+	}
+	if supported {
+		return Badge{Text: "VR", Color: color.RGBA{120, 40, 150, 220}, Anchor: "top-right"}, true
+	}
+	return Badge{}, false
+}
+
+// Store categories for each kind of multiplayer badge, and the badge text.
+var multiplayerBadgeKinds = []struct {
+	Kind       string
+	Text       string
+	Categories []string
+}{
+	{"coop", "CO-OP", []string{"online co-op", "co-op", "lan co-op"}},
+	{"local", "LOCAL", []string{"local co-op", "shared/split screen co-op", "local pvp", "shared/split screen pvp", "shared/split screen", "local multi-player"}},
+	{"pvp", "PVP", []string{"online pvp", "pvp", "lan pvp"}},
+}
+
+// Returns the names of the multiplayer badge kinds, for help messages.
+func multiplayerBadgeNames() []string {
+	names := make([]string, 0, len(multiplayerBadgeKinds))
+	for _, kind := range multiplayerBadgeKinds {
+		names = append(names, kind.Kind)
+	}
+	return names
+}
+
+// Returns the multiplayer badges of the enabled kinds ("coop", "local",
+// "pvp") that match the game's store categories.
+func multiplayerBadges(details *StoreDetails, enabled []string) []Badge {
+	badges := make([]Badge, 0)
+	if details == nil {
+		return badges
+	}
+	for _, kind := range multiplayerBadgeKinds {
+		if !containsString(enabled, kind.Kind) {
+			continue
+		}
+		for _, category := range details.Categories {
+			if containsString(kind.Categories, strings.ToLower(category)) {
+				badges = append(badges, Badge{Text: kind.Text, Color: color.RGBA{30, 90, 150, 220}, Anchor: "bottom-left"})
+				break
+			}
+		}
+	}
+	return badges
+}
+
+// Returns a badge with the release year, or false if unknown.
+func yearBadge(details *StoreDetails) (Badge, bool) {
+	if details == nil || details.ReleaseYear == 0 {
+		return Badge{}, false
+	}
+	return Badge{Text: strconv.Itoa(details.ReleaseYear), Color: badgeColor, Anchor: "top-left"}, true
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Folder next to the program where images named anyhow are dropped, for the
+// import command to find their games.
+const importFolderName = "import"
+
+// Subfolder of the import folder where the images are moved once imported,
+// so the next import doesn't install them again.
+const importedFolderName = "imported"
+
+// How similar to a game name a file name must be to match it, from 0 to 1,
+// and by how much it must beat the next game.
+const (
+	importMinSimilarity = 0.75
+	importMinMargin     = 0.05
+)
+
+// Words in image file names that tell the asset type, and aren't part of
+// the game name. Sizes are the ones Steam and SteamGridDB use.
+var importAssetWords = map[string]string{
+	"banner":    "banner",
+	"header":    "banner",
+	"460x215":   "banner",
+	"920x430":   "banner",
+	"portrait":  "portrait",
+	"capsule":   "portrait",
+	"cover":     "portrait",
+	"vertical":  "portrait",
+	"600x900":   "portrait",
+	"342x482":   "portrait",
+	"hero":      "hero",
+	"1920x620":  "hero",
+	"3840x1240": "hero",
+	"logo":      "logo",
+	"grid":      "",
+	"library":   "",
+	"steam":     "",
+}
+
+// Returns the lowercase words of letters and digits in a name, so
+// "Half-Life_2 (hero).png" and "Half-Life 2" compare well.
+func getNameWords(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Returns the edit distance between two strings.
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// Returns the smaller of two ints.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Returns how similar the words of a file name are to the words of a game
+// name, from 0 to 1: by edit distance, or high if the file has every word
+// of the game and a few more, like "portal 2 fanart".
+func nameSimilarity(fileWords, gameWords []string) float64 {
+	if len(fileWords) == 0 || len(gameWords) == 0 {
+		return 0
+	}
+	a, b := []rune(strings.Join(fileWords, " ")), []rune(strings.Join(gameWords, " "))
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	similarity := 1 - float64(levenshtein(a, b))/float64(longest)
+
+	for _, word := range gameWords {
+		if !containsString(fileWords, word) {
+			return similarity
+		}
+	}
+	if contained := 0.8 + 0.2*float64(len(gameWords))/float64(len(fileWords)); contained > similarity {
+		return contained
+	}
+	return similarity
+}
+
+// Returns the asset type of an image from the words of its name, or else
+// from its shape: tall ones are portraits, very wide ones heroes.
+func guessImportedAsset(words []string, imageBytes []byte) *AssetType {
+	for _, word := range words {
+		if name := importAssetWords[word]; name != "" {
+			assets, _ := GetAssetTypes(name)
+			return assets[0]
+		}
+		// Like Steam's own names, "620p".
+		if len(word) > 1 && strings.HasSuffix(word, "p") && appIdPattern.MatchString(strings.TrimSuffix(word, "p")) {
+			return getAssetTypeBySuffix("p")
+		}
+	}
+	config, _, err := image.DecodeConfig(bytes.NewBuffer(imageBytes))
+	if err != nil || config.Height == 0 {
+		return bannerAsset
+	}
+	switch ratio := float64(config.Width) / float64(config.Height); {
+	case ratio < 1:
+		return getAssetTypeBySuffix("p")
+	case ratio > 2.6:
+		return getAssetTypeBySuffix("_hero")
+	}
+	return bannerAsset
+}
+
+// Returns the game an image of the import folder is for: the one whose app
+// id is in the file name, or else the one with the most similar name, if
+// it's close enough and clearly ahead of the others. Returns nil if there's
+// none.
+func matchImportedImage(games map[string]*Game, path string) *Game {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	words := make([]string, 0)
+	for _, word := range getNameWords(name) {
+		id := strings.TrimSuffix(word, "p")
+		if game, ok := games[id]; ok && appIdPattern.MatchString(id) {
+			return game
+		}
+		if _, ok := importAssetWords[word]; !ok {
+			words = append(words, word)
+		}
+	}
+
+	var best *Game
+	bestSimilarity, secondSimilarity := 0.0, 0.0
+	for _, game := range SortGames(games, false) {
+		similarity := nameSimilarity(words, getNameWords(game.Name))
+		if similarity > bestSimilarity {
+			best, bestSimilarity, secondSimilarity = game, similarity, bestSimilarity
+		} else if similarity > secondSimilarity {
+			secondSimilarity = similarity
+		}
+	}
+	if bestSimilarity < importMinSimilarity || bestSimilarity-secondSimilarity < importMinMargin {
+		return nil
+	}
+	logf(LogVerbose, "Matched %v to %v (similarity %.2f)", filepath.Base(path), best.Name, bestSimilarity)
+	return best
+}
+
+// Returns the image files in the import folder.
+func listImportFolder(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	for _, file := range files {
+		switch strings.ToLower(filepath.Ext(file.Name())) {
+		case ".png", ".jpg", ".jpeg":
+			if !file.IsDir() {
+				paths = append(paths, filepath.Join(dir, file.Name()))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// Installs the images of the import folder that match a game of the user,
+// as custom images with the overlays. Returns the paths of the images
+// installed.
+func ImportFolder(user User, games map[string]*Game, paths []string, overlaySets map[*AssetType]*OverlaySet) []string {
+	imported := make([]string, 0)
+	for _, path := range paths {
+		game := matchImportedImage(games, path)
+		if game == nil {
+			continue
+		}
+		imageBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Print(tr("Failed to import %v: %v\n", filepath.Base(path), err.Error()))
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		asset := guessImportedAsset(getNameWords(name), imageBytes)
+		if isProtected(game) {
+			fmt.Print(tr("Skipped %v, it's protected.\n", game.Name))
+			continue
+		}
+		if _, err := setGridImageBytes(user, game, asset, imageBytes, overlaySets[asset]); err != nil {
+			fmt.Print(tr("Failed to import %v: %v\n", filepath.Base(path), err.Error()))
+			continue
+		}
+		fmt.Print(tr("Imported %v as the %v of %v.\n", filepath.Base(path), asset.Name, game.Name))
+		imported = append(imported, path)
+	}
+	return imported
+}
+
+// Installs the images of a folder for every user, then moves them to the
+// imported subfolder and lists the ones that matched no game.
+func runImportFolder(dir string, steamArgs []string) {
+	paths, err := listImportFolder(dir)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(dir, 0777)
+	}
+	if err != nil {
+		errorAndExit(err)
+	}
+	if len(paths) == 0 {
+		fmt.Print(tr("No images to import in %v. Put the image files there, named after the game or with its app id.\n", dir))
+		return
+	}
+
+	installationDir, users := loadUsers(steamArgs)
+	installed := GetInstalledGames(installationDir)
+	overlaySets := make(map[*AssetType]*OverlaySet)
+	if !*importWithoutOverlays {
+		overlaySets = loadOverlaySets(assetTypes)
+	}
+	startJournal(strings.Join(os.Args[1:], " "))
+
+	matched := make(map[string]bool)
+	for _, user := range users {
+		// Without the profile we still have the games found locally.
+		games, _ := GetGames(user)
+		FilterGames(games, *gameFilter)
+		for _, game := range games {
+			game.Installed = game.Installed || installed[game.Id]
+			if !game.Installed && !containsString(game.VirtualTags, "not installed") {
+				game.VirtualTags = append(game.VirtualTags, "not installed")
+			}
+		}
+		for _, path := range ImportFolder(user, games, paths, overlaySets) {
+			matched[path] = true
+		}
+	}
+
+	unmatched := make([]string, 0)
+	for _, path := range paths {
+		if !matched[path] {
+			unmatched = append(unmatched, filepath.Base(path))
+			continue
+		}
+		importedDir := filepath.Join(dir, importedFolderName)
+		if err := os.MkdirAll(importedDir, 0777); err != nil {
+			errorAndExit(err)
+		}
+		if err := os.Rename(path, filepath.Join(importedDir, filepath.Base(path))); err != nil {
+			fmt.Print(tr("Failed to move %v to %v: %v\n", filepath.Base(path), importedDir, err.Error()))
+		}
+	}
+	fmt.Print(tr("%v images imported and moved to %v.\n", len(matched), filepath.Join(dir, importedFolderName)))
+	if len(unmatched) > 0 {
+		fmt.Print(tr("%v images matched no game, rename them after the game or its app id:\n", len(unmatched)))
+		for _, name := range unmatched {
+			fmt.Printf("- %v\n", name)
+		}
+	}
+	fmt.Println(tr("Run 'steamgrid undo' to put back the images replaced by the import."))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Returns the directory where steamgrid keeps downloaded metadata between
+// runs, creating it if necessary.
+func getCacheDir(name string) (string, error) {
+	baseDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(baseDir, "steamgrid", name)
+	return dir, os.MkdirAll(dir, 0777)
+}
+
+// Loads a cached JSON value into v. Returns false if the value is missing,
+// older than maxAge or unreadable.
+func readCache(name, key string, maxAge time.Duration, v interface{}) bool {
+	cacheDir, err := getCacheDir(name)
+	if err != nil {
+		return false
+	}
+	cachePath := filepath.Join(cacheDir, key+".json")
+
+	info, err := os.Stat(cachePath)
+	if err != nil || time.Since(info.ModTime()) > maxAge {
+		logf(LogDebug, "Cache miss for %v/%v", name, key)
+		return false
+	}
+	cachedBytes, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(cachedBytes, v); err != nil {
+		logf(LogDebug, "Cache entry %v/%v is unreadable: %v", name, key, err.Error())
+		return false
+	}
+	logf(LogDebug, "Cache hit for %v/%v", name, key)
+	return true
+}
+
+// Saves a value in the cache as JSON.
+func writeCache(name, key string, v interface{}) error {
+	cacheDir, err := getCacheDir(name)
+	if err != nil {
+		return err
+	}
+	valueBytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(cacheDir, key+".json"), valueBytes, 0644)
+}
+
+// Removes a value from the cache, if it's there.
+func removeCache(name, key string) error {
+	cacheDir, err := getCacheDir(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(cacheDir, key+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// How long downloaded images are reused before asking the server again.
+const downloadCacheMaxAge = 7 * 24 * time.Hour
+
+// Downloads older than this are ignored, so --force-refresh downloads again
+// the images of earlier runs, but users in the same run still share them.
+var downloadCacheSince time.Time
+
+// Image downloaded from a URL. The bytes are kept by their SHA-256, so every
+// user, asset type and URL with the same image shares one file.
+type CachedDownload struct {
+	Url  string
+	Hash string
+}
+
+// Returns the path of a downloaded image in the cache.
+func getCachedDownloadPath(hash string) (string, error) {
+	dir, err := getCacheDir("downloads")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, hash+".bin"), nil
+}
+
+// Returns the path of the image downloaded from a URL in an earlier run, or
+// by another user, or "" if there's none.
+func findCachedDownload(url string) string {
+	entry := &CachedDownload{}
+	if !readCache("downloads", urlCacheKey(url), downloadCacheMaxAge, entry) || entry.Url != url {
+		return ""
+	}
+	path, err := getCachedDownloadPath(entry.Hash)
+	if err != nil {
+		return ""
+	}
+	if info, err := os.Stat(path); err != nil || info.ModTime().Before(downloadCacheSince) {
+		return ""
+	}
+	if hash, err := hashFile(path); err != nil || hash != entry.Hash {
+		return ""
+	}
+	return path
+}
+
+// Moves an image downloaded from a URL into the cache, for the other users
+// and runs, and returns its path there.
+func storeCachedDownload(url string, download *DownloadedFile) (string, error) {
+	path, err := getCachedDownloadPath(download.Hash)
+	if err != nil {
+		return "", err
+	}
+	if findCachedDownload(url) == path {
+		// Already there, and replacing it would keep it from expiring.
+		os.Remove(download.Path)
+		return path, nil
+	}
+	if err := os.Rename(download.Path, path); err != nil {
+		// The same image from another URL may be open, which Windows
+		// doesn't let us replace, but then it's already there.
+		if hash, hashErr := hashFile(path); hashErr != nil || hash != download.Hash {
+			return "", err
+		}
+		os.Remove(download.Path)
+	}
+	return path, writeCache("downloads", urlCacheKey(url), &CachedDownload{url, download.Hash})
+}
+
+// Deletes the downloaded images that expired, and the partial downloads
+// that were never resumed, so the cache doesn't grow forever.
+func pruneDownloadCache() {
+	for name, maxAge := range map[string]time.Duration{"downloads": downloadCacheMaxAge, "partial": partialDownloadMaxAge} {
+		dir, err := getCacheDir(name)
+		if err != nil {
+			continue
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if time.Since(file.ModTime()) > maxAge {
+				os.Remove(filepath.Join(dir, file.Name()))
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many backups of sharedconfig.vdf are kept for each user.
+const maxSharedConfigBackups = 10
+
+// Saves a copy of the sharedconfig.vdf of a user before it changes, with the
+// backups of the images rather than next to it, where Steam Cloud would sync
+// every copy. Only the last few are kept, and the ones older versions left
+// next to it are moved there too.
+func backupSharedConfig(user User, sharedConfBytes []byte) error {
+	if *dryRun || *noBackup {
+		return nil
+	}
+	dir := filepath.Join(getBackupsDir(user), "sharedconfig")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	remoteDir := filepath.Join(user.Dir, "7", "remote")
+	oldBackups, _ := filepath.Glob(filepath.Join(remoteDir, "sharedconfig (backup *).vdf"))
+	for _, oldPath := range oldBackups {
+		date := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(oldPath), "sharedconfig (backup "), ").vdf")
+		if err := moveFile(oldPath, filepath.Join(dir, "sharedconfig "+date+".vdf")); err != nil {
+			return err
+		}
+	}
+	name := "sharedconfig " + time.Now().Format("2006-01-02 150405") + ".vdf"
+	logf(LogVerbose, "Backing up sharedconfig.vdf to %v", filepath.Join(dir, name))
+	if err := writeFileAtomic(filepath.Join(dir, name), sharedConfBytes, 0644); err != nil {
+		return err
+	}
+
+	// The names sort by date.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	backups := make([]string, 0)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), "sharedconfig ") && strings.HasSuffix(file.Name(), ".vdf") {
+			backups = append(backups, file.Name())
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > maxSharedConfigBackups {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Rule assigning a Steam category to every game that matches any of its
+// patterns.
+type CategoryRule struct {
+	Category string
+	// "installed", "not installed", "shared", "genre:NAME", an app id, or a
+	// game name glob like "Half-Life*" (case insensitive).
+	Patterns []string
+}
+
+// Loads the auto-categorization rules from a file where each line maps a
+// category to a comma separated list of patterns:
+//
+//	Installed = installed
+//	Valve = 220, 440, Half-Life*
+//	RPG = genre:RPG
+func LoadCategoryRules(path string) ([]CategoryRule, error) {
+	entries, err := LoadIni(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]CategoryRule, 0)
+	for _, entry := range entries {
+		rules = append(rules, CategoryRule{entry.Key, splitList(entry.Value)})
+	}
+	return rules, nil
+}
+
+var appIdPattern = regexp.MustCompile(`^\d+$`)
+
+// Returns true if the game matches any of the rule's patterns.
+func (rule CategoryRule) Matches(game *Game) bool {
+	for _, pattern := range rule.Patterns {
+		lowerPattern := strings.ToLower(pattern)
+		switch {
+		case lowerPattern == "installed":
+			if game.Installed {
+				return true
+			}
+		case lowerPattern == "not installed":
+			if !game.Installed {
+				return true
+			}
+		case lowerPattern == "shared":
+			if game.Shared {
+				return true
+			}
+		case strings.HasPrefix(lowerPattern, "genre:"):
+			if game.Store == nil {
+				continue
+			}
+			for _, genre := range game.Store.Genres {
+				if strings.EqualFold(genre, pattern[len("genre:"):]) {
+					return true
+				}
+			}
+		default:
+			if matchesIdOrName(game, pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if the pattern is the game's app id, or a glob matching its
+// name, ignoring case.
+func matchesIdOrName(game *Game, pattern string) bool {
+	if appIdPattern.MatchString(pattern) {
+		return game.Id == pattern
+	}
+	matched, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(game.Name))
+	return err == nil && matched
+}
+
+// Returns true if the game matches any of the comma separated app ids or
+// name globs.
+func matchesAnyIdOrName(game *Game, filter string) bool {
+	for _, pattern := range splitList(filter) {
+		if matchesIdOrName(game, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the game already has the given tag (case insensitive).
+func hasTag(game *Game, tag string) bool {
+	for _, existing := range game.Tags {
+		if strings.EqualFold(existing, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Non-Steam games have 64 bit ids and their categories live in
+// shortcuts.vdf, which we don't write to.
+func isNonSteamGame(game *Game) bool {
+	id, err := strconv.ParseUint(game.Id, 10, 64)
+	return err != nil || id > 0xFFFFFFFF
+}
+
+// Returns the key for a new entry in a "tags" block, which is a list of
+// "0", "1", ... keys that might have gaps.
+func nextTagIndex(tags *VdfNode) string {
+	next := 0
+	for _, tag := range tags.Children {
+		if index, err := strconv.Atoi(tag.Key); err == nil && index >= next {
+			next = index + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// Applies the category rules to the user's games, adding the new categories
+// both to the games and to Steam's sharedconfig.vdf, so the overlays and
+// Steam's own grouping agree. Existing categories are never removed. The
+// previous sharedconfig.vdf is backed up next to it before being rewritten.
+// Returns the number of games that received new categories.
+func AutoCategorize(user User, games map[string]*Game, rules []CategoryRule) (int, error) {
+	if len(rules) == 0 {
+		return 0, nil
+	}
+
+	sharedConfFile := filepath.Join(user.Dir, "7", "remote", "sharedconfig.vdf")
+	root := &VdfNode{IsBlock: true}
+	sharedConfBytes, err := ioutil.ReadFile(sharedConfFile)
+	if err == nil {
+		root, err = ParseVdf(sharedConfBytes)
+		if err != nil {
+			return 0, err
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	apps := root.Block("UserRoamingConfigStore").Block("Software").Block("Valve").Block("Steam").Block("apps")
+
+	ids := make([]string, 0, len(games))
+	for id := range games {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	nChanged := 0
+	for _, id := range ids {
+		game := games[id]
+		if isNonSteamGame(game) {
+			continue
+		}
+
+		changed := false
+		for _, rule := range rules {
+			if hasTag(game, rule.Category) || !rule.Matches(game) {
+				continue
+			}
+			tags := apps.Block(game.Id).Block("tags")
+			tags.Set(nextTagIndex(tags), rule.Category)
+			game.Tags = append(game.Tags, rule.Category)
+			changed = true
+		}
+		if changed {
+			nChanged++
+		}
+	}
+
+	if nChanged == 0 {
+		return 0, nil
+	}
+
+	if sharedConfBytes != nil {
+		if err := backupSharedConfig(user, sharedConfBytes); err != nil {
+			return 0, err
+		}
+	} else if !*dryRun {
+		err = os.MkdirAll(filepath.Dir(sharedConfFile), 0777)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return nChanged, writeFile(nil, sharedConfFile, root.Bytes())
+}
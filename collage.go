@@ -0,0 +1,64 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Maximum number of screenshots side by side in a collage.
+const collageScreenshots = 3
+
+// Downloads and decodes an image, returning nil if it's missing.
+func downloadDecodedImage(url string) (image.Image, error) {
+	response, err := tryDownload(url)
+	if err != nil || response == nil {
+		return nil, err
+	}
+	defer closeResponse(response)
+	img, _, err := image.Decode(response.Body)
+	return img, err
+}
+
+// Builds a banner for games without images from a few store screenshots,
+// placed side by side as vertical slices of the banner.
+func GenerateCollage(game *Game) error {
+	details, err := loadStoreDetails(game)
+	if err != nil {
+		return err
+	}
+	if details == nil || len(details.Screenshots) == 0 {
+		return nil
+	}
+
+	screenshots := make([]image.Image, 0, collageScreenshots)
+	for _, url := range details.Screenshots {
+		if len(screenshots) == collageScreenshots {
+			break
+		}
+		screenshot, err := downloadDecodedImage(url)
+		if err != nil {
+			return err
+		}
+		if screenshot != nil {
+			screenshots = append(screenshots, screenshot)
+		}
+	}
+	if len(screenshots) == 0 {
+		return nil
+	}
+
+	banner := image.NewRGBA(image.Rect(0, 0, bannerWidth, bannerHeight))
+	for i, screenshot := range screenshots {
+		left := i * bannerWidth / len(screenshots)
+		right := (i + 1) * bannerWidth / len(screenshots)
+		slice := fillImage(screenshot, right-left, bannerHeight)
+		draw.Draw(banner, image.Rect(left, 0, right, bannerHeight), slice, image.ZP, draw.Src)
+	}
+
+	if err := setEncodedImage(game, banner, "jpeg"); err != nil {
+		return err
+	}
+	game.ImageSource = "collage"
+	game.ImageUrl = ""
+	return nil
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Subcommand given as the first argument, like "steamgrid restore".
+type Command struct {
+	Name        string
+	Description string
+	// Returns a new flag set with the command's options, for Run to parse
+	// and for the shell completion to list.
+	Flags func() *flag.FlagSet
+	// Parses the command's own flags from the remaining arguments and runs it.
+	Run func(args []string)
+}
+
+// Returns the available commands. The first one runs when no command is
+// given, so "steamgrid" and "steamgrid STEAMPATH" keep working.
+func getCommands() []Command {
+	return []Command{
+		{"download", "Download, back up and overlay the images of every game (the default).", newDownloadFlags, startApplication},
+		{"retry", "Download again only the images that failed or were not found in the last runs.", newRetryFlags, runRetry},
+		{"restore", "Put the original images back, from the backups made by previous runs.", newRestoreFlags, runRestore},
+		{"undo", "Put every file changed by the last run back as it was.", newUndoFlags, runUndo},
+		{"rollback", "Put back an older version of the image of a game: rollback GAME.", newRollbackFlags, runRollback},
+		{"verify", "Check every grid image and backup, and restore or download again the broken ones.", newVerifyFlags, runVerify},
+		{"clean", "Delete (or archive) grid images of games no longer in the library, and stale backups.", newCleanFlags, runClean},
+		{"sync", "Copy the grid images of one user to the others in this computer, for the games they share, or keep them the same as in a folder shared with other computers: sync --from USER, or sync --folder DIR.", newSyncFlags, runSync},
+		{"export", "Save the grid images of a user to a zip, to back up or share them: export PACK.zip.", newExportFlags, runExport},
+		{"import", "Install the images of a zip made by export, with the overlays: import PACK.zip, or the images named anyhow in a folder: import [DIR].", newImportFlags, runImport},
+		{"list", "List every game and the state of its images.", newListFlags, runList},
+		{"preview", "Draw each overlay on a sample image, without touching Steam.", newPreviewFlags, runPreview},
+		{"set", "Install an image file as the grid image of a game: set GAME IMAGE.", newSetFlags, runSet},
+		{"watch", "Download as usual, then keep updating the images of games that are recategorized or installed.", newWatchFlags, runWatch},
+		{"serve", "Start a web UI to browse the library, pick artwork and start runs from a browser.", newServeFlags, runServe},
+		{"gui", "Open a window with the library, each game's artwork before and after the overlays, the images to pick from, the run controls and the settings.", newGuiFlags, runGui},
+		{"tray", "Sit in the system tray and download the images on the --refresh schedule or when asked from its menu, with a notification after each run.", newTrayFlags, runTray},
+		{"service", "Install watch, serve or tray to start with the session: service install [watch|serve|tray] [options], or service uninstall.", newServiceFlags, runService},
+		{"settings", "Change the main options step by step and save them to the config file.", newSettingsFlags, runSettings},
+		{"update", "Replace this program with the latest release from GitHub, after verifying its checksum.", newUpdateFlags, runUpdate},
+		{"completion", "Print the script that completes the commands and options in a shell: completion bash|zsh|fish|powershell.", newCompletionFlags, runCompletion},
+	}
+}
+
+// Runs the command named by the first argument, or the default one.
+func runCommand(args []string) {
+	commands := getCommands()
+	if len(args) > 0 {
+		if args[0] == "help" {
+			printUsage(nil)
+			return
+		}
+		if args[0] == completeCommand {
+			runComplete(args[1:])
+			return
+		}
+		for _, command := range commands {
+			if args[0] == command.Name {
+				command.Run(args[1:])
+				return
+			}
+		}
+		if areDroppedImages(args) {
+			runDroppedImages(args)
+			return
+		}
+	}
+	commands[0].Run(args)
+}
+
+// Prints the list of commands and, if given, the options of one of them.
+func printUsage(flags *flag.FlagSet) {
+	output := os.Stderr
+	fmt.Fprintf(output, "Usage: steamgrid [command] [options] [STEAMPATH]\n\nCommands:\n")
+	for _, command := range getCommands() {
+		fmt.Fprintf(output, "  %-10v %v\n", command.Name, command.Description)
+	}
+	if flags != nil {
+		fmt.Fprintf(output, "\nOptions of %v:\n", flags.Name())
+		flags.PrintDefaults()
+	}
+}
+
+// Returns a flag set for a command, with the options shared by all commands
+// and usage that lists the other commands too.
+func newCommandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() { printUsage(flags) }
+	flags.BoolVar(headless, "headless", false, "Never wait for enter before closing. Automatic when the input is not a terminal or there's no display.")
+	flags.String("config", getConfigPath(nil), "Config file with the default of any option, as 'option = value' lines.")
+	flags.StringVar(language, "language", "auto", "Language of the messages, like 'pt' or 'pt-BR', from the 'locales' folder next to the program. 'auto' uses the one of the system.")
+	flags.BoolVar(verbose, "verbose", false, "Explain what is done with each game and file.")
+	flags.BoolVar(debug, "debug", false, "Also show every request and cache lookup, to diagnose problems.")
+	flags.StringVar(logFormat, "log-format", "text", "Format of the log: 'text', or 'json' for one event per line on stdout, with everything else moved to stderr.")
+	flags.StringVar(logPath, "log-file", "", "Append the log, with times and every message up to the chosen level, to this file. It's rotated at 1 MB.")
+	return flags
+}
+
+// Returns the flag set of the preview command.
+func newPreviewFlags() *flag.FlagSet {
+	flags := newCommandFlags("preview")
+	addAssetFlags(flags)
+	return flags
+}
+
+// Draws each overlay on a sample image of each asset type and saves the
+// results in "overlay previews" next to the program.
+func runPreview(args []string) {
+	flags := newPreviewFlags()
+	parseCommandFlags(flags, args)
+
+	assets, err := GetAssetTypes(*assetNames)
+	if err != nil {
+		errorAndExit(err)
+	}
+	overlaySets := loadOverlaySets(assets)
+
+	exeDir := filepath.Dir(os.Args[0])
+	nTotal := 0
+	for _, asset := range assets {
+		sample := loadPreviewSample(exeDir, asset)
+		nWritten, err := PreviewOverlays(overlaySets[asset], sample, filepath.Join(exeDir, "overlay previews"))
+		if err != nil {
+			errorAndExit(err)
+		}
+		if nWritten > 0 {
+			fmt.Print(tr("%v %v previews written to 'overlay previews'.\n", nWritten, asset.Name))
+		}
+		nTotal += nWritten
+	}
+	if nTotal == 0 {
+		fmt.Println(tr("No category overlays found. You can put overlay images in the folder 'overlays by category', where the filename is the game category."))
+	}
+}
+
+// Returns the flag set of the restore command.
+func newRestoreFlags() *flag.FlagSet {
+	flags := newCommandFlags("restore")
+	addUserFlags(flags)
+	addGameFlags(flags)
+	addProtectedFlags(flags)
+	return flags
+}
+
+// Puts back the original images of every user, or of the games given with
+// --games, and removes the ones we downloaded or generated.
+func runRestore(args []string) {
+	flags := newRestoreFlags()
+	parseCommandFlags(flags, args)
+
+	_, users := loadUsers(flags.Args())
+	for _, user := range users {
+		var games map[string]*Game
+		protected := getProtectedIds(nil)
+		if *gameFilter != "" || *protectedGames != "" {
+			// Without the profile, names only match the games found locally.
+			library, _ := GetGames(user)
+			protected = getProtectedIds(library)
+			if *gameFilter != "" {
+				games = library
+				FilterGames(games, *gameFilter)
+			}
+		}
+		nRestored, nRemoved, err := RestoreBackups(user, games, protected)
+		if err != nil {
+			errorAndExit(err)
+		}
+		fmt.Print(tr("%v original images restored and %v downloaded images removed for %v.\n", nRestored, nRemoved, user.Name))
+	}
+}
+
+// Returns a short description of where the current grid image of a game
+// came from, given the source found by GetGridImageSource.
+func describeGridImage(source string) string {
+	switch source {
+	case "backup":
+		return "steamgrid"
+	case "manual customization":
+		return "custom"
+	}
+	return "missing"
+}
+
+// What the list command can say about an image: official art from Steam,
+// community art found by a search or an override, generated (collages and
+// placeholders), custom (the user's own) or missing.
+var coverageStates = []string{"official", "community", "generated", "custom", "missing"}
+
+// Comma separated states of the only images to list, given with --status.
+var listStatus = new(string)
+
+// Returns the state of the current grid image of a game, one of
+// coverageStates, from the source recorded with its backup.
+func getImageCoverage(user User, game *Game, asset *AssetType) string {
+	switch GetGridImageSource(user, game, asset) {
+	case "":
+		return "missing"
+	case "manual customization":
+		return "custom"
+	}
+	if IsChangedByHand(user, game, asset) {
+		return "custom"
+	}
+	switch getBackupSource(user, game.Id, asset) {
+	case "download":
+		return "official"
+	case "search", "override":
+		return "community"
+	case "collage", "generated":
+		return "generated"
+	}
+	return "custom"
+}
+
+// Returns the flag set of the list command.
+func newListFlags() *flag.FlagSet {
+	flags := newCommandFlags("list")
+	addAssetFlags(flags)
+	addUserFlags(flags)
+	addGameFlags(flags)
+	flags.BoolVar(includeHidden, "include-hidden", false, "Also list games hidden in Steam.")
+	flags.StringVar(listStatus, "status", "", "Comma separated states, like 'missing,generated', to list only the games with an image in one of them: official, community, generated, custom or missing.")
+	return flags
+}
+
+// Prints every game of every user and the state of each of its images, and
+// how many images are in each state, to check the coverage of the library.
+func runList(args []string) {
+	flags := newListFlags()
+	parseCommandFlags(flags, args)
+
+	assets, err := GetAssetTypes(*assetNames)
+	if err != nil {
+		errorAndExit(err)
+	}
+	wanted := splitList(*listStatus)
+	for _, state := range wanted {
+		if !containsString(coverageStates, state) {
+			errorAndExit(errors.New(tr("Unknown --status '%v', expected some of: %v", state, strings.Join(coverageStates, ", "))))
+		}
+	}
+
+	_, users := loadUsers(flags.Args())
+	for _, user := range users {
+		games, err := GetGames(user)
+		if err != nil {
+			fmt.Print(tr("Failed to load the public profile of %v, only games found locally are listed: %v\n", user.Name, err.Error()))
+		}
+		FilterGames(games, *gameFilter)
+		for id, game := range games {
+			if game.Hidden && !*includeHidden {
+				delete(games, id)
+			}
+		}
+		fmt.Print(tr("\n%v games of %v:\n", len(games), user.Name))
+		counts := make(map[*AssetType]map[string]int)
+		for _, asset := range assets {
+			counts[asset] = make(map[string]int)
+		}
+		for _, game := range SortGames(games, false) {
+			states := make([]string, 0, len(assets))
+			matches := len(wanted) == 0
+			for _, asset := range assets {
+				state := getImageCoverage(user, game, asset)
+				counts[asset][state]++
+				matches = matches || containsString(wanted, state)
+				states = append(states, asset.Name+": "+tr(state))
+			}
+			if matches {
+				fmt.Printf("- %v (id %v) %v\n", game.Name, game.Id, strings.Join(states, ", "))
+			}
+		}
+		for _, asset := range assets {
+			totals := make([]string, 0, len(coverageStates))
+			for _, state := range coverageStates {
+				totals = append(totals, fmt.Sprintf("%v %v", counts[asset][state], tr(state)))
+			}
+			fmt.Printf("%v: %v\n", asset.Name, strings.Join(totals, ", "))
+		}
+	}
+}
+
+// Folder to move the cleaned files to, given with --archive.
+var cleanArchiveDir = new(string)
+
+// True to also clean the images of games that are not installed.
+var cleanUninstalled = new(bool)
+
+// Returns the flag set of the clean command.
+func newCleanFlags() *flag.FlagSet {
+	flags := newCommandFlags("clean")
+	addUserFlags(flags)
+	addProtectedFlags(flags)
+	flags.BoolVar(dryRun, "dry-run", false, "Only list the files that would be cleaned, and why.")
+	flags.StringVar(cleanArchiveDir, "archive", "", "Move the files to this folder, in a subfolder per user, instead of deleting them.")
+	flags.BoolVar(cleanUninstalled, "uninstalled", false, "Also clean the images of games that are not installed.")
+	return flags
+}
+
+// Deletes the grid images of games that are not in the library of each
+// user anymore, and the backups left without an image.
+func runClean(args []string) {
+	flags := newCleanFlags()
+	parseCommandFlags(flags, args)
+
+	installationDir, users := loadUsers(flags.Args())
+	var installed map[string]bool
+	if *cleanUninstalled {
+		installed = GetInstalledGames(installationDir)
+	}
+	if !*dryRun && *cleanArchiveDir == "" {
+		startJournal(strings.Join(os.Args[1:], " "))
+	}
+	for _, user := range users {
+		games, err := GetGames(user)
+		if err != nil {
+			// Without the profile most games would look deleted.
+			errorAndExit(errors.New(tr("Failed to load the public profile of %v, so nothing was cleaned: %v", user.Name, err.Error())))
+		}
+		// Profiles with private game details are found, but list nothing,
+		// and every game would look deleted too.
+		profileGames := make(map[string]*Game)
+		if err := addGamesFromProfile(user, profileGames); err == nil && len(profileGames) == 0 {
+			errorAndExit(errors.New(tr("The profile of %v lists no games, so nothing was cleaned. Make sure the game details of your Steam profile are public.", user.Name)))
+		}
+		cleaned, err := CleanGrid(user, games, installed, *cleanArchiveDir)
+		for _, file := range cleaned {
+			switch {
+			case *dryRun:
+				fmt.Println(tr("Would clean %v (%v)", file.Name, tr(file.Reason)))
+			case *cleanArchiveDir != "":
+				fmt.Println(tr("Archived %v (%v)", file.Name, tr(file.Reason)))
+			default:
+				fmt.Println(tr("Deleted %v (%v)", file.Name, tr(file.Reason)))
+			}
+		}
+		if err != nil {
+			errorAndExit(err)
+		}
+		if *dryRun {
+			fmt.Print(tr("%v files would be cleaned for %v, run again without --dry-run to do it.\n", len(cleaned), user.Name))
+		} else {
+			fmt.Print(tr("%v orphaned files cleaned for %v.\n", len(cleaned), user.Name))
+		}
+	}
+	if !*dryRun && *cleanArchiveDir == "" {
+		fmt.Println(tr("Run 'steamgrid undo' to put back the files deleted by clean."))
+	}
+}
+
+// Returns the flag set of the set command, also used for dropped images.
+func newSetFlags() *flag.FlagSet {
+	flags := newCommandFlags("set")
+	addUserFlags(flags)
+	addProtectedFlags(flags)
+	addOverlayFlags(flags)
+	addVersionFlags(flags)
+	flags.StringVar(overlaysPath, "overlays", filepath.Join(filepath.Dir(os.Args[0]), "overlays by category"), "Folder with the overlays and their overlays.ini.")
+	flags.StringVar(assetNames, "asset", "banner", "Asset type of the image: banner, portrait, hero or logo.")
+	return flags
+}
+
+// Installs an image as the grid image of the game given by id or name.
+func runSet(args []string) {
+	flags := newSetFlags()
+	parseCommandFlags(flags, args)
+	if flags.NArg() < 2 {
+		errorAndExit(errors.New(tr("Expected the game and the image, like: steamgrid set 620 portal.png")))
+	}
+	assets, err := GetAssetTypes(*assetNames)
+	if err != nil {
+		errorAndExit(err)
+	}
+	if len(assets) != 1 {
+		errorAndExit(errors.New(tr("The image is for a single asset type, got: %v", *assetNames)))
+	}
+
+	pattern := flags.Arg(0)
+	setCustomImages(flags.Args()[2:], flags.Args()[1:2], func(games map[string]*Game, imagePath string) (*Game, *AssetType, error) {
+		game, err := FindGame(games, pattern)
+		return game, assets[0], err
+	})
+}
+
+// Installs the images dropped on the program, each named after its game.
+func runDroppedImages(imagePaths []string) {
+	flags := newSetFlags()
+	parseCommandFlags(flags, nil)
+	setCustomImages(nil, imagePaths, parseDroppedImage)
+}
+
+// Installs each image for every user that has its game, and the overlays
+// on it. The game and asset type of each image are found by find.
+func setCustomImages(steamArgs []string, imagePaths []string, find func(games map[string]*Game, imagePath string) (*Game, *AssetType, error)) {
+	installationDir, users := loadUsers(steamArgs)
+	installed := GetInstalledGames(installationDir)
+	overlaySets := loadOverlaySets(assetTypes)
+	startJournal(strings.Join(os.Args[1:], " "))
+
+	found := make(map[string]bool)
+	for _, user := range users {
+		// Without the profile we still have the games found locally.
+		games, _ := GetGames(user)
+		for _, imagePath := range imagePaths {
+			game, asset, err := find(games, imagePath)
+			if err != nil {
+				errorAndExit(err)
+			}
+			if game == nil {
+				continue
+			}
+			found[imagePath] = true
+
+			game.Installed = game.Installed || installed[game.Id]
+			if !game.Installed && !containsString(game.VirtualTags, "not installed") {
+				game.VirtualTags = append(game.VirtualTags, "not installed")
+			}
+			applied, err := SetGridImage(user, game, asset, imagePath, overlaySets[asset])
+			if err != nil {
+				errorAndExit(errors.New(tr("Failed to set %v: %v", imagePath, err.Error())))
+			}
+			name := game.Name
+			if name == "" {
+				name = tr("the game with id %v", game.Id)
+			}
+			if applied {
+				fmt.Println(tr("Set %v as the %v of %v for %v, with overlays.", filepath.Base(imagePath), asset.Name, name, user.Name))
+			} else {
+				fmt.Println(tr("Set %v as the %v of %v for %v.", filepath.Base(imagePath), asset.Name, name, user.Name))
+			}
+		}
+	}
+
+	for _, imagePath := range imagePaths {
+		if !found[imagePath] {
+			fmt.Print(tr("No game found for %v. Name the image after the app id or the game, like '620.png' or 'Portal 2 hero.jpg'.\n", filepath.Base(imagePath)))
+		}
+	}
+	waitForEnter()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// Steam Deck compatibility report used by the store page.
+const deckCompatibilityUrlFormat = `https://store.steampowered.com/saleaction/ajaxgetdeckappcompatibilityreport?nAppID=%v`
+
+// Community Proton compatibility summary.
+const protonDbUrlFormat = `https://www.protondb.com/api/v1/reports/summaries/%v.json`
+
+// Names for the Deck "resolved_category" values.
+var deckCategoryNames = map[int]string{
+	1: "unsupported",
+	2: "playable",
+	3: "verified",
+}
+
+// Compatibility ratings of a game. Empty strings mean unknown.
+type Compatibility struct {
+	// "verified", "playable" or "unsupported".
+	Deck string
+	// ProtonDB tier, like "platinum", "gold" or "borked".
+	ProtonDb string
+}
+
+// Downloads the Steam Deck status of a game.
+func downloadDeckCompatibility(appId string) (string, error) {
+	var report struct {
+		Results struct {
+			ResolvedCategory int `json:"resolved_category"`
+		}
+	}
+	found, err := getJson(fmt.Sprintf(deckCompatibilityUrlFormat, appId), &report)
+	if err != nil || !found {
+		return "", err
+	}
+	return deckCategoryNames[report.Results.ResolvedCategory], nil
+}
+
+// Downloads the ProtonDB tier of a game. Games without reports give 404.
+func downloadProtonDbTier(appId string) (string, error) {
+	var summary struct {
+		Tier string
+	}
+	found, err := getJson(fmt.Sprintf(protonDbUrlFormat, appId), &summary)
+	if err != nil || !found {
+		return "", err
+	}
+	return strings.ToLower(summary.Tier), nil
+}
+
+// Returns the ProtonDB tier of a game, cached like the store metadata.
+func GetProtonDbTier(game *Game) (string, error) {
+	var tier string
+	if isNonSteamGame(game) || readCache("protondb", game.Id, storeCacheDuration, &tier) {
+		return tier, nil
+	}
+	tier, err := downloadProtonDbTier(game.Id)
+	if err != nil {
+		return "", err
+	}
+	return tier, writeCache("protondb", game.Id, tier)
+}
+
+// Returns the Steam Deck status of a game, cached like the store metadata.
+func GetDeckCompatibility(game *Game) (string, error) {
+	var status string
+	if isNonSteamGame(game) || readCache("deck", game.Id, storeCacheDuration, &status) {
+		return status, nil
+	}
+	status, err := downloadDeckCompatibility(game.Id)
+	if err != nil {
+		return "", err
+	}
+	return status, writeCache("deck", game.Id, status)
+}
+
+// Returns the Deck and ProtonDB ratings of a game.
+func GetCompatibility(game *Game) (*Compatibility, error) {
+	var err error
+	compat := &Compatibility{}
+	compat.Deck, err = GetDeckCompatibility(game)
+	if err != nil {
+		return nil, err
+	}
+	compat.ProtonDb, err = GetProtonDbTier(game)
+	if err != nil {
+		return nil, err
+	}
+	return compat, nil
+}
+
+// Returns the virtual tags for the compatibility ratings, like
+// "deck verified" and "protondb gold", so they can have overlays.
+func (compat *Compatibility) Tags() []string {
+	tags := make([]string, 0)
+	if compat.Deck != "" {
+		tags = append(tags, "deck "+compat.Deck)
+	}
+	if compat.ProtonDb != "" {
+		tags = append(tags, "protondb "+compat.ProtonDb)
+	}
+	return tags
+}
+
+// Colors of the ProtonDB tiers, as used on the site.
+var protonDbColors = map[string]color.RGBA{
+	"platinum": {180, 199, 220, 230},
+	"gold":     {207, 181, 59, 230},
+	"silver":   {166, 166, 166, 230},
+	"bronze":   {205, 127, 50, 230},
+	"borked":   {200, 30, 30, 230},
+}
+
+// Returns a badge with the ProtonDB tier, or false if the game has none.
+func protonDbBadge(tier string) (Badge, bool) {
+	tierColor, ok := protonDbColors[tier]
+	if !ok {
+		return Badge{}, false
+	}
+	return Badge{Text: strings.ToUpper(tier), Color: tierColor, Anchor: "top-left"}, true
+}
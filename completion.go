@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Hidden command called by the completion scripts with the words typed so
+// far, the last being the one to complete. It prints a candidate per line.
+const completeCommand = "__complete"
+
+// Completion scripts by shell. Each one asks the program for the candidates,
+// so they follow the options of the version installed, and falls back to
+// file names when there are none, like for STEAMPATH or --report.
+var completionScripts = map[string]string{
+	"bash": `# steamgrid completion for bash, add to ~/.bashrc:
+#   source <(steamgrid completion bash)
+_steamgrid() {
+	local IFS=$'\n'
+	COMPREPLY=($("${COMP_WORDS[0]}" ` + completeCommand + ` "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _steamgrid steamgrid
+`,
+	"zsh": `#compdef steamgrid
+# steamgrid completion for zsh, add to ~/.zshrc after compinit:
+#   source <(steamgrid completion zsh)
+_steamgrid() {
+	local -a candidates
+	candidates=(${(f)"$("${words[1]}" ` + completeCommand + ` "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	if (( ${#candidates} )); then
+		compadd -Q -- $candidates
+	else
+		_files
+	fi
+}
+compdef _steamgrid steamgrid
+`,
+	"fish": `# steamgrid completion for fish, save it with:
+#   steamgrid completion fish > ~/.config/fish/completions/steamgrid.fish
+function __steamgrid_complete
+	set -l words (commandline -opc)
+	set -l current (commandline -ct)
+	set -l candidates ($words[1] ` + completeCommand + ` $words[2..-1] "$current" 2>/dev/null)
+	if test (count $candidates) -eq 0
+		__fish_complete_path "$current"
+	else
+		printf '%s\n' $candidates
+	end
+end
+complete -c steamgrid -f -a '(__steamgrid_complete)'
+`,
+	"powershell": `# steamgrid completion for PowerShell, add to $PROFILE:
+#   steamgrid completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName steamgrid, steamgrid.exe -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$program = $commandAst.CommandElements[0].Extent.Text
+	$words = @($commandAst.CommandElements | Select-Object -Skip 1 | Where-Object { $_.Extent.EndOffset -le $cursorPosition } | ForEach-Object { $_.Extent.Text })
+	if ($wordToComplete -eq '') {
+		$words += '""'
+	}
+	& $program ` + completeCommand + ` @words 2>$null | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`,
+}
+
+// Returns the flag set of the completion command.
+func newCompletionFlags() *flag.FlagSet {
+	flags := newCommandFlags("completion")
+	flags.Usage = func() {
+		printUsage(flags)
+		fmt.Fprintln(os.Stderr, "\nUsage: steamgrid completion bash|zsh|fish|powershell\n\nThe script explains at the top how to load it.")
+	}
+	return flags
+}
+
+// Prints the completion script of the shell given.
+func runCompletion(args []string) {
+	flags := newCompletionFlags()
+	parseCommandFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	script, ok := completionScripts[strings.ToLower(flags.Arg(0))]
+	if !ok {
+		errorAndExit(errors.New("Unknown shell '" + flags.Arg(0) + "', expected bash, zsh, fish or powershell."))
+	}
+	fmt.Print(script)
+}
+
+// Prints the candidates for the last of the words given, for the scripts.
+func runComplete(words []string) {
+	for _, candidate := range getCompletions(words) {
+		fmt.Println(candidate)
+	}
+}
+
+// Returns the candidates for the last word typed: the commands, the options
+// of the command, or the values of the option before it. Nothing means the
+// shell should complete file names.
+func getCompletions(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current, before := words[len(words)-1], words[:len(words)-1]
+	// Windows PowerShell drops empty arguments, so its script sends "".
+	if current == `""` {
+		current = ""
+	}
+
+	commands := getCommands()
+	if len(before) == 0 && !strings.HasPrefix(current, "-") {
+		names := []string{"help"}
+		for _, command := range commands {
+			names = append(names, command.Name)
+		}
+		return filterCompletions(names, current)
+	}
+	command := commands[0]
+	if len(before) > 0 {
+		for _, candidate := range commands {
+			if before[0] == candidate.Name {
+				command, before = candidate, before[1:]
+				break
+			}
+		}
+	}
+
+	// service install [watch|serve|tray] takes the options of the command.
+	if command.Name == "service" {
+		switch {
+		case len(before) == 0:
+			return filterCompletions([]string{"install", "uninstall"}, current)
+		case before[0] != "install":
+			return nil
+		case len(before) == 1 && !strings.HasPrefix(current, "-"):
+			return filterCompletions([]string{"watch", "serve", "tray"}, current)
+		}
+		command = findCommand(commands, "watch")
+		if len(before) > 1 && (before[1] == "serve" || before[1] == "tray") {
+			command = findCommand(commands, before[1])
+		}
+	}
+	flags := command.Flags()
+
+	// Bash splits "--assets=ba" into "--assets", "=" and "ba", and replaces
+	// only the last part.
+	if current == "=" {
+		before, current = append(before, current), ""
+	}
+	if n := len(before); n >= 2 && before[n-1] == "=" {
+		return getFlagValueCompletions(flags, before[n-2], current)
+	}
+	if n := len(before); n >= 1 && strings.HasPrefix(before[n-1], "-") && !strings.Contains(before[n-1], "=") {
+		if f := flags.Lookup(strings.TrimLeft(before[n-1], "-")); f != nil && !isBoolFlag(f) {
+			return getFlagValueCompletions(flags, before[n-1], current)
+		}
+	}
+
+	if !strings.HasPrefix(current, "-") {
+		return nil
+	}
+	if i := strings.Index(current, "="); i >= 0 {
+		candidates := make([]string, 0)
+		for _, value := range getFlagValueCompletions(flags, current[:i], current[i+1:]) {
+			candidates = append(candidates, current[:i+1]+value)
+		}
+		return candidates
+	}
+	names := make([]string, 0)
+	flags.VisitAll(func(f *flag.Flag) {
+		names = append(names, "--"+f.Name)
+	})
+	return filterCompletions(names, current)
+}
+
+// Returns the command with the given name.
+func findCommand(commands []Command, name string) Command {
+	for _, command := range commands {
+		if command.Name == name {
+			return command
+		}
+	}
+	return commands[0]
+}
+
+// Returns the candidates for the value of an option, like "--assets", that
+// start with what was typed. For comma separated lists only the last item
+// is completed.
+func getFlagValueCompletions(flags *flag.FlagSet, option string, typed string) []string {
+	f := flags.Lookup(strings.TrimLeft(option, "-"))
+	if f == nil {
+		return nil
+	}
+
+	var values []string
+	list := false
+	switch f.Name {
+	case "assets":
+		list = true
+		values = append(getAssetTypeNames(), "all")
+	case "asset":
+		values = getAssetTypeNames()
+	case "multiplayer-badges":
+		list = true
+		values = multiplayerBadgeNames()
+	case "image-protocol":
+		values = []string{"kitty", "iterm", "sixel", "none"}
+	case "log-format":
+		values = []string{"text", "json"}
+	case "custom-images":
+		values = []string{"skip", "adopt", "overwrite"}
+	case "source-order":
+		list = true
+		values = fallbackSources
+	case "force-refresh":
+		list = true
+		values = append(append([]string{"all"}, getAssetTypeNames()...), refreshSources...)
+	case "status":
+		list = true
+		values = coverageStates
+	case "prefer":
+		values = []string{"local", "remote"}
+	case "grid-permissions":
+		values = []string{"auto", "off", "755", "700"}
+	case "steam-running":
+		values = []string{"ask", "warn", "close", "restart", "ignore"}
+	case "jpeg-encoder":
+		values = getJpegEncoderNames()
+	case "steam-cloud":
+		values = []string{"ask", "warn", "wait", "ignore"}
+	case "jpeg-subsampling":
+		values = jpegSubsamplings
+	case "language":
+		values = append([]string{"auto", "en"}, getLocaleNames()...)
+	case "refresh":
+		for alias := range cronAliases {
+			values = append(values, alias)
+		}
+		sort.Strings(values)
+	default:
+		if isBoolFlag(f) {
+			values = []string{"true", "false"}
+		}
+	}
+
+	prefix := ""
+	if list {
+		if i := strings.LastIndex(typed, ","); i >= 0 {
+			prefix, typed = typed[:i+1], typed[i+1:]
+		}
+	}
+	candidates := make([]string, 0)
+	for _, value := range filterCompletions(values, typed) {
+		if list && containsString(strings.Split(prefix, ","), value) {
+			continue
+		}
+		candidates = append(candidates, prefix+value)
+	}
+	return candidates
+}
+
+// Returns the names of the asset types, like "banner" and "hero".
+func getAssetTypeNames() []string {
+	names := make([]string, 0, len(assetTypes))
+	for _, asset := range assetTypes {
+		names = append(names, asset.Name)
+	}
+	return names
+}
+
+// Returns the languages in the locales folder next to the program.
+func getLocaleNames() []string {
+	files, err := ioutil.ReadDir(filepath.Join(filepath.Dir(os.Args[0]), "locales"))
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".json" {
+			names = append(names, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return names
+}
+
+// Returns the candidates that start with what was typed.
+func filterCompletions(candidates []string, typed string) []string {
+	filtered := make([]string, 0)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, typed) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	return filtered
+}
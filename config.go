@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns the path of the config file: the one given with --config, or
+// steamgrid.ini in the user's config folder (like ~/.config/steamgrid or
+// %AppData%\steamgrid).
+func getConfigPath(args []string) string {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if arg == name {
+			continue
+		}
+		if strings.HasPrefix(name, "config=") {
+			return name[len("config="):]
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "steamgrid", "steamgrid.ini")
+}
+
+// Loads the config file, where each line sets the default of a command line
+// option, with or without dashes:
+//
+//	api key = 0123456789ABCDEF
+//	jpeg-quality = 95
+//	user = gabe
+//
+//	[download]
+//	games = 620, 440
+//
+// Options at the top are used by every command that has them, and options
+// in a [command] section only by that command. Options given on the command
+// line always win.
+func applyConfig(flags *flag.FlagSet, path string) error {
+	if path == "" {
+		return nil
+	}
+	entries, err := LoadIni(path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Section != "" && !strings.EqualFold(entry.Section, flags.Name()) {
+			continue
+		}
+		name := strings.Replace(strings.ToLower(entry.Key), " ", "-", -1)
+		if flags.Lookup(name) == nil {
+			if entry.Section == "" {
+				// Probably an option of another command.
+				continue
+			}
+			return errors.New("Unknown option '" + entry.Key + "' for " + flags.Name() + " in " + path)
+		}
+		if err := flags.Set(name, entry.Value); err != nil {
+			return errors.New("Invalid value for '" + entry.Key + "' in " + path + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Applies the config file and then the command line arguments to the
+// command's flags.
+func parseCommandFlags(flags *flag.FlagSet, args []string) {
+	if err := applyConfig(flags, getConfigPath(args)); err != nil {
+		errorAndExit(err)
+	}
+	flags.Parse(args)
+	if err := startLogging(); err != nil {
+		errorAndExit(err)
+	}
+	loadTranslations()
+}
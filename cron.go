@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron expression of the scheduled runs of watch and serve.
+var refreshSchedule = new(string)
+
+// Registers the option with the schedule of the long running commands.
+func addScheduleFlags(flags *flag.FlagSet) {
+	flags.StringVar(refreshSchedule, "refresh", "", "Cron expression of when to do a full run, like '0 4 * * *' for every day at 4:00, or @daily, @hourly, @weekly.")
+}
+
+// Times matched by a cron expression, with a bit for each allowed minute,
+// hour, day of the month, month and day of the week.
+type CronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// True if the day of the month or of the week starts with "*", like "*"
+	// or "*/2". When both are restricted, matching either is enough, like in
+	// cron.
+	anyDay, anyWeekday bool
+}
+
+// Shortcuts for common schedules.
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parses one field of a cron expression: "*", numbers, ranges like "1-5",
+// steps like "*/15" or "0-30/10", and comma separated lists of those.
+func parseCronField(field string, min int, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash != -1 {
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step < 1 {
+				return 0, errors.New(tr("Invalid step in '%v'", field))
+			}
+			part = part[:slash]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, errors.New(tr("Invalid number in '%v'", field))
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, errors.New(tr("Invalid number in '%v'", field))
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the end, every 15.
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, errors.New(tr("'%v' is out of the range %v-%v", field, min, max))
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// Parses a cron expression with five fields: minute, hour, day of the month,
+// month and day of the week, where Sunday is 0 or 7.
+func ParseCron(expression string) (*CronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if alias, ok := cronAliases[strings.ToLower(expression)]; ok {
+		expression = alias
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.New(tr("Invalid schedule '%v', expected five fields like '0 4 * * *' (minute, hour, day, month, day of the week).", expression))
+	}
+
+	schedule := &CronSchedule{anyDay: strings.HasPrefix(fields[2], "*"), anyWeekday: strings.HasPrefix(fields[4], "*")}
+	ranges := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&schedule.minutes, 0, 59},
+		{&schedule.hours, 0, 23},
+		{&schedule.days, 1, 31},
+		{&schedule.months, 1, 12},
+		{&schedule.weekdays, 0, 7},
+	}
+	for i, r := range ranges {
+		bits, err := parseCronField(fields[i], r.min, r.max)
+		if err != nil {
+			return nil, errors.New(tr("Invalid schedule '%v': %v", expression, err.Error()))
+		}
+		*r.bits = bits
+	}
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+	return schedule, nil
+}
+
+// Returns true if the schedule matches the day of the given time.
+func (schedule *CronSchedule) matchesDay(t time.Time) bool {
+	day := schedule.days&(1<<uint(t.Day())) != 0
+	weekday := schedule.weekdays&(1<<uint(t.Weekday())) != 0
+	if schedule.anyDay || schedule.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Returns the first time after the given one that matches the schedule, or
+// the zero time if none does in the next years, like on February 30th.
+func (schedule *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if schedule.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !schedule.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if schedule.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if schedule.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Returns a channel that receives the time of each scheduled run, or nil
+// if there's no schedule. Runs missed while the previous one was going on
+// are skipped.
+func startSchedule() (<-chan time.Time, error) {
+	if *refreshSchedule == "" {
+		return nil, nil
+	}
+	schedule, err := ParseCron(*refreshSchedule)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return nil, errors.New(tr("The schedule '%v' never matches.", *refreshSchedule))
+	}
+
+	ticks := make(chan time.Time)
+	go func() {
+		for {
+			next := schedule.Next(time.Now())
+			time.Sleep(time.Until(next))
+			// Nobody is waiting while a run is going on, and that tick is
+			// dropped rather than starting another run right after it.
+			select {
+			case ticks <- next:
+			default:
+			}
+		}
+	}()
+	return ticks, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Returns the only game matching an app id or name. Exact names are
+// preferred, so "Portal" isn't ambiguous with "Portal 2", and name globs
+// must match a single game. Returns nil if none match.
+func FindGame(games map[string]*Game, pattern string) (*Game, error) {
+	if game, ok := games[pattern]; ok {
+		return game, nil
+	}
+	for _, game := range games {
+		if strings.EqualFold(game.Name, pattern) {
+			return game, nil
+		}
+	}
+
+	var found *Game
+	for _, game := range SortGames(games, false) {
+		if matchesIdOrName(game, pattern) {
+			if found != nil {
+				return nil, errors.New("Both '" + found.Name + "' and '" + game.Name + "' match '" + pattern + "', use the app id instead.")
+			}
+			found = game
+		}
+	}
+	return found, nil
+}
+
+// Installs an image file as the grid image of a game, like setting a custom
+// image in Steam: it replaces the backup of the original, so later runs draw
+// their overlays on it, and the overlays are drawn on it right away. Returns
+// true if an overlay was applied.
+func SetGridImage(user User, game *Game, asset *AssetType, imagePath string, overlays *OverlaySet) (bool, error) {
+	imageBytes, err := ioutil.ReadFile(imagePath)
+	if err != nil {
+		return false, err
+	}
+	return setGridImageBytes(user, game, asset, imageBytes, overlays)
+}
+
+// Installs an encoded image as the grid image of a game, like SetGridImage.
+// Without overlays the image is installed as it is.
+func setGridImageBytes(user User, game *Game, asset *AssetType, imageBytes []byte, overlays *OverlaySet) (bool, error) {
+	if err := checkNotProtected(game); err != nil {
+		return false, err
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewBuffer(imageBytes)); err != nil {
+		return false, errors.New("Not an image we can read, expected PNG or JPG.")
+	}
+
+	// The new image may have a different extension than the old one, and
+	// Steam would pick either.
+	if err := SaveImageVersion(user, game, asset); err != nil {
+		return false, err
+	}
+	gridDir := getGridDir(user)
+	base := game.Id + asset.Suffix
+	for _, ext := range gridImageExts {
+		if err := removeFile(game.Output, filepath.Join(gridDir, base+ext)); err != nil {
+			return false, err
+		}
+	}
+	if err := os.MkdirAll(gridDir, 0777); err != nil {
+		return false, err
+	}
+
+	game.ImagePath = filepath.Join(gridDir, base+".jpg")
+	game.ImageBytes, game.ImageFile = imageBytes, ""
+	game.ImageSource = "manual customization"
+	if err := FixImageFormat(game); err != nil {
+		return false, err
+	}
+	if err := BackupGame(user, game, asset); err != nil {
+		return false, err
+	}
+	if err := RecordBackupSource(user, game, asset); err != nil {
+		return false, err
+	}
+
+	applied := false
+	if overlays != nil {
+		var err error
+		if applied, err = ApplyOverlay(game, overlays); err != nil {
+			return false, err
+		}
+	}
+	if err := FixImageFormat(game); err != nil {
+		return applied, err
+	}
+	if err := writeGameImage(game.ImagePath, game); err != nil {
+		return applied, err
+	}
+	return applied, RecordInstalledImage(user, game, asset)
+}
+
+// Name of an image dropped on the program: the game id, optionally with the
+// asset suffix like "620p.png", or the game name, optionally followed by
+// the asset name like "Portal 2 hero.jpg".
+var droppedImagePattern = regexp.MustCompile(`(?i)^(.+?)(p|_hero|_logo| portrait| hero| logo| banner)?\.(jpg|jpeg|png)$`)
+
+// Returns true if every argument is an image file, which is what Windows
+// passes when images are dropped on the program.
+func areDroppedImages(args []string) bool {
+	for _, arg := range args {
+		if !droppedImagePattern.MatchString(filepath.Base(arg)) {
+			return false
+		}
+		if info, err := os.Stat(arg); err != nil || info.IsDir() {
+			return false
+		}
+	}
+	return len(args) > 0
+}
+
+// Returns the game and asset type a dropped image is for, from its name.
+func parseDroppedImage(games map[string]*Game, imagePath string) (*Game, *AssetType, error) {
+	name := filepath.Base(imagePath)
+	groups := droppedImagePattern.FindStringSubmatch(name)
+	pattern, suffix := groups[1], strings.ToLower(groups[2])
+
+	// Suffixes only go with ids, and asset names only with names.
+	isId := appIdPattern.MatchString(pattern)
+	if suffix != "" && isId == strings.HasPrefix(suffix, " ") {
+		pattern, suffix = strings.TrimSuffix(name, filepath.Ext(name)), ""
+		isId = appIdPattern.MatchString(pattern)
+	}
+
+	asset := bannerAsset
+	for _, candidate := range assetTypes {
+		if suffix != "" && (suffix == candidate.Suffix || suffix == " "+candidate.Name) {
+			asset = candidate
+		}
+	}
+
+	game, err := FindGame(games, pattern)
+	if err != nil || game == nil {
+		return nil, nil, err
+	}
+	return game, asset, nil
+}
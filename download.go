@@ -1,12 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"time"
 )
 
 // When all else fails, Google it. Uses the regular web interface. There are
@@ -14,19 +24,18 @@ import (
 // matching, and the other requires an API key limited to 100 searches a day.
 const googleSearchFormat = `https://www.google.com.br/search?tbs=isz%3Aex%2Ciszw%3A460%2Ciszh%3A215&tbm=isch&num=5&q=`
 
-// Returns the first steam grid image URL found by Google search of a given
-// game name.
-func getGoogleImage(gameName string) (string, error) {
+// Returns the steam grid image URLs found by Google search of a given game
+// name, best matches first.
+func getGoogleImages(gameName string) ([]string, error) {
 	if gameName == "" {
-		return "", nil
+		return nil, nil
 	}
 
-	url := googleSearchFormat + url.QueryEscape(gameName)
+	url := googleSearchFormat + url.QueryEscape(getSearchName(gameName))
 
-	client := &http.Client{}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// If we don't set an user agent, Google will block us because we are a
@@ -34,107 +43,542 @@ func getGoogleImage(gameName string) (string, error) {
 	// Google will serve a simple HTML page without direct image links.
 	// So we have to lie.
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 6.3; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/39.0.2171.71 Safari/537.36")
-	response, err := client.Do(req)
+	response, err := doRequest(req)
 	if err != nil {
-		return "", err
+		logf(LogDebug, "Search for %v failed: %v", gameName, err.Error())
+		return nil, err
 	}
+	logEvent(LogDebug, "request", LogFields{"url": url, "status": response.StatusCode}, "GET %v: %v", url, response.Status)
 
 	responseBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	response.Body.Close()
 
 	pattern := regexp.MustCompile(`imgurl=(.+?\.(jpg|png))&amp;imgrefurl=`)
-	matches := pattern.FindStringSubmatch(string(responseBytes))
-	if len(matches) >= 1 {
-		return matches[1], nil
-	} else {
-		return "", nil
+	urls := make([]string, 0)
+	for _, matches := range pattern.FindAllStringSubmatch(string(responseBytes), -1) {
+		urls = append(urls, matches[1])
 	}
+	return urls, nil
 }
 
 // Tries to fetch a URL, returning the response only if it was positive.
+// Images already in the shared download cache are returned without asking
+// the server. If an earlier download of it was cut short, only the rest is
+// requested, and the response body has the whole file.
 func tryDownload(url string) (*http.Response, error) {
-	response, err := http.Get(url)
+	if cachedPath := findCachedDownload(url); cachedPath != "" {
+		if file, err := os.Open(cachedPath); err == nil {
+			logf(LogVerbose, "Using the cached download of %v", url)
+			response := &http.Response{
+				Status:        "200 OK",
+				StatusCode:    http.StatusOK,
+				Header:        make(http.Header),
+				Body:          file,
+				ContentLength: -1,
+			}
+			if info, err := file.Stat(); err == nil {
+				response.ContentLength = info.Size()
+			}
+			return response, nil
+		}
+	}
+
+	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	partial, hasPartial := loadPartialDownload(url)
+	if hasPartial {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%v-", partial.size))
+		// The server sends the whole file if it changed since.
+		request.Header.Set("If-Range", partial.Validator)
+	}
+	response, err := doRequest(request)
+	if err != nil {
+		logf(LogDebug, "Request failed: %v", err.Error())
+		return nil, err
+	}
+	logEvent(LogDebug, "request", LogFields{"url": url, "status": response.StatusCode}, "GET %v: %v", url, response.Status)
+	if hasPartial && response.StatusCode == http.StatusPartialContent {
+		start, err := os.Open(partial.path)
+		if err != nil {
+			closeResponse(response)
+			removePartialDownload(url)
+			return nil, err
+		}
+		logf(LogVerbose, "Resuming the download of %v after %v", url, formatBytes(partial.size))
+		response.Body = &resumedBody{io.MultiReader(start, response.Body), start, response.Body}
+		response.StatusCode = http.StatusOK
+		if response.ContentLength >= 0 {
+			response.ContentLength += partial.size
+		}
+		// It was the hash of the rest only.
+		response.Header.Del("Content-MD5")
+	} else if hasPartial {
+		removePartialDownload(url)
+	}
 
 	if response.StatusCode == 404 {
 		// Some apps don't have an image and there's nothing we can do.
+		closeResponse(response)
 		return nil, nil
 	} else if response.StatusCode > 400 {
 		// Other errors should be reported, though.
+		closeResponse(response)
 		return nil, errors.New("Failed to download image " + url + ": " + response.Status)
 	}
 
 	return response, nil
 }
 
-// Primary URL for downloading grid images.
-const akamaiUrlFormat = `https://steamcdn-a.akamaihd.net/steam/apps/%v/header.jpg`
+// Body of a resumed download: the start from the partial file, then the
+// rest from the server.
+type resumedBody struct {
+	io.Reader
+	start *os.File
+	rest  io.ReadCloser
+}
 
-// The subreddit mentions this as primary, but I've found Akamai to contain
-// more images and answer faster.
-const steamCdnUrlFormat = `http://cdn.steampowered.com/v/gfx/apps/%v/header.jpg`
+func (body *resumedBody) Close() error {
+	body.start.Close()
+	return body.rest.Close()
+}
 
-// Tries to load the grid image for a game from a number of alternative
-// sources. Returns the final response received and a flag indicating if it was
-// from a Google search (useful because we want to log the lower quality
-// images).
-func getImageAlternatives(game *Game) (response *http.Response, fromSearch bool, err error) {
-	response, err = tryDownload(fmt.Sprintf(akamaiUrlFormat, game.Id))
-	if err == nil && response != nil {
-		return
+// How many times a download cut short is resumed before it's left for the
+// next run, the wait before the first try, doubled at each one, and how
+// long its partial file is kept for the next run.
+const (
+	downloadResumeAttempts = 3
+	downloadResumeWait     = time.Second
+	partialDownloadMaxAge  = 7 * 24 * time.Hour
+)
+
+// Start of a download cut short, kept in the cache to resume it.
+type PartialDownload struct {
+	Url string
+	// ETag or Last-Modified of the file, so it's only resumed if unchanged.
+	Validator string
+	path      string
+	size      int64
+}
+
+// Returns the cache key of the downloads of a URL.
+func urlCacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the path of the partial download of a URL.
+func getPartialDownloadPath(url string) (string, error) {
+	dir, err := getCacheDir("partial")
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(dir, urlCacheKey(url)+".part"), nil
+}
 
-	response, err = tryDownload(fmt.Sprintf(steamCdnUrlFormat, game.Id))
-	if err == nil && response != nil {
-		return
+// Loads the partial download of a URL, if a previous run left one.
+func loadPartialDownload(url string) (*PartialDownload, bool) {
+	partial := &PartialDownload{}
+	if !readCache("partial", urlCacheKey(url), partialDownloadMaxAge, partial) || partial.Url != url || partial.Validator == "" {
+		return nil, false
+	}
+	var err error
+	if partial.path, err = getPartialDownloadPath(url); err != nil {
+		return nil, false
+	}
+	info, err := os.Stat(partial.path)
+	if err != nil {
+		return nil, false
 	}
+	partial.size = info.Size()
+	return partial, partial.size > 0
+}
 
-	response, err = tryDownload(fmt.Sprintf(akamaiUrlFormat, game.Id2))
-	if err == nil && response != nil {
-		return
+// Keeps the start of a download cut short, already written to a temporary
+// file, for the next run.
+func savePartialDownload(partial *PartialDownload, tempPath string) error {
+	path, err := getPartialDownloadPath(partial.Url)
+	if err != nil {
+		return err
 	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return err
+	}
+	return writeCache("partial", urlCacheKey(partial.Url), partial)
+}
 
-	response, err = tryDownload(fmt.Sprintf(steamCdnUrlFormat, game.Id2))
-	if err == nil && response != nil {
-		return
+// Forgets the partial download of a URL.
+func removePartialDownload(url string) {
+	if path, err := getPartialDownloadPath(url); err == nil {
+		os.Remove(path)
 	}
+	removeCache("partial", urlCacheKey(url))
+}
+
+// Returned when a download doesn't have the size or hash the server gave.
+var errDownloadMismatch = errors.New("the download doesn't match its checksum")
 
-	fromSearch = true
-	url, err := getGoogleImage(game.Name)
+// Download written to a temporary file as it arrives, with its size and
+// hashes worked out on the way, so images are never held whole in memory
+// to get them.
+type DownloadedFile struct {
+	Path string
+	Size int64
+	Md5  []byte
+	// Hex SHA-256, like hashImage.
+	Hash string
+}
+
+// Reads and closes the body of a download, checks it against the size and
+// hash the server gave, and keeps it in the shared download cache. Returns
+// the path of the file in the cache. If they don't match it's downloaded
+// again, once, and a second mismatch is an error, so the caller moves on to
+// the next candidate.
+func readDownload(url string, response *http.Response) (string, error) {
+	download, err := readResumable(url, response)
+	if err == nil {
+		err = verifyDownload(response, download)
+	}
+	if err == errDownloadMismatch {
+		logf(LogVerbose, "The download of %v doesn't match its checksum, downloading it again", url)
+		os.Remove(download.Path)
+		removePartialDownload(url)
+		response, err = tryDownload(url)
+		if err == nil && response == nil {
+			err = errors.New("Failed to download image " + url + ": not found")
+		}
+		if err == nil {
+			download, err = readResumable(url, response)
+		}
+		if err == nil {
+			if err = verifyDownload(response, download); err != nil {
+				os.Remove(download.Path)
+			}
+		}
+	}
 	if err != nil {
-		return
+		return "", err
 	}
-	response, err = tryDownload(url)
-	if err == nil && response != nil {
-		return
+	path, err := storeCachedDownload(url, download)
+	if err != nil {
+		// The temporary file still has it, until the cache is pruned.
+		logf(LogVerbose, "Failed to cache the download of %v: %v", url, err.Error())
+		return download.Path, nil
 	}
+	return path, nil
+}
 
-	return nil, false, nil
+// Returns the MD5 the server gave for a download, from Content-MD5 or the
+// md5 in X-Goog-Hash, or nil if it gave none.
+func getExpectedMd5(header http.Header) []byte {
+	values := []string{header.Get("Content-MD5")}
+	for _, value := range header.Values("X-Goog-Hash") {
+		for _, hash := range strings.Split(value, ",") {
+			if hash = strings.TrimSpace(hash); strings.HasPrefix(hash, "md5=") {
+				values = append(values, strings.TrimPrefix(hash, "md5="))
+			}
+		}
+	}
+	for _, value := range values {
+		if sum, err := base64.StdEncoding.DecodeString(value); err == nil && len(sum) == md5.Size {
+			return sum
+		}
+	}
+	return nil
 }
 
-// Tries to download the game images, saving it in game.ImageBytes. Returns
-// flags indicating if the operation succeeded and if the image downloaded was
-// from a search.
-func DownloadImage(game *Game) error {
-	response, fromSearch, err := getImageAlternatives(game)
-	if response == nil || err != nil {
-		return err
+// Checks a download against the size and MD5 the server gave, if any.
+// Returns errDownloadMismatch if they don't match.
+func verifyDownload(response *http.Response, download *DownloadedFile) error {
+	// Compressed responses have no length, and Go removes the header.
+	if response.ContentLength >= 0 && download.Size != response.ContentLength {
+		logf(LogDebug, "Expected %v bytes, got %v", response.ContentLength, download.Size)
+		return errDownloadMismatch
+	}
+	if expected := getExpectedMd5(response.Header); expected != nil && !bytes.Equal(download.Md5, expected) {
+		logf(LogDebug, "Expected MD5 %x, got %x", expected, download.Md5)
+		return errDownloadMismatch
 	}
+	return nil
+}
 
-	imageBytes, err := ioutil.ReadAll(response.Body)
+// Writes the body of a download to a temporary file and closes it. When
+// the connection drops halfway, the rest is requested with a Range request,
+// a few times, and if it still fails what was written is kept so the next
+// run can resume it.
+func readResumable(url string, response *http.Response) (*DownloadedFile, error) {
+	dir, err := getCacheDir("partial")
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	file, err := ioutil.TempFile(dir, "download")
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	writer := io.MultiWriter(file, md5Hash, sha256Hash)
+	size, err := io.Copy(writer, response.Body)
 	response.Body.Close()
 
+	validator := response.Header.Get("ETag")
+	if validator == "" {
+		validator = response.Header.Get("Last-Modified")
+	}
+	canResume := response.Header.Get("Accept-Ranges") == "bytes" && validator != ""
+	for attempt := 0; err != nil && canResume && attempt < downloadResumeAttempts; attempt++ {
+		logf(LogVerbose, "The download of %v was cut short after %v, resuming: %v", url, formatBytes(size), err.Error())
+		time.Sleep(downloadResumeWait << uint(attempt))
+		request, requestErr := http.NewRequest("GET", url, nil)
+		if requestErr != nil {
+			break
+		}
+		request.Header.Set("Range", fmt.Sprintf("bytes=%v-", size))
+		request.Header.Set("If-Range", validator)
+		rest, requestErr := doRequest(request)
+		if requestErr != nil {
+			err = requestErr
+			continue
+		}
+		switch rest.StatusCode {
+		case http.StatusPartialContent:
+			var more int64
+			more, err = io.Copy(writer, rest.Body)
+			size += more
+		case http.StatusOK:
+			// Changed since, or the server ignored the range.
+			md5Hash.Reset()
+			sha256Hash.Reset()
+			if _, err = file.Seek(0, io.SeekStart); err == nil {
+				err = file.Truncate(0)
+			}
+			if err == nil {
+				size, err = io.Copy(writer, rest.Body)
+			}
+		default:
+			err = errors.New("Failed to resume the download of " + url + ": " + rest.Status)
+			canResume = false
+		}
+		closeResponse(rest)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		if canResume && size > 0 {
+			if saveErr := savePartialDownload(&PartialDownload{Url: url, Validator: validator}, file.Name()); saveErr != nil {
+				logf(LogVerbose, "Failed to keep the partial download of %v: %v", url, saveErr.Error())
+			}
+		}
+		os.Remove(file.Name())
+		return nil, err
+	}
+	removePartialDownload(url)
+	return &DownloadedFile{file.Name(), size, md5Hash.Sum(nil), hex.EncodeToString(sha256Hash.Sum(nil))}, nil
+}
+
+// Primary URL for downloading grid images.
+const akamaiUrlFormat = `https://steamcdn-a.akamaihd.net/steam/apps/%v/header.jpg`
+
+// The subreddit mentions this as primary, but I've found Akamai to contain
+// more images and answer faster.
+const steamCdnUrlFormat = `http://cdn.steampowered.com/v/gfx/apps/%v/header.jpg`
+
+// Images that could be used for a game, downloaded one at a time in order:
+// the official ones, then the Google results. Only banners are searched,
+// since the search is for banner sizes, and never with --no-search.
+type ImageCandidates struct {
+	game  *Game
+	asset *AssetType
+	urls  []string
+	// Index of the first URL from the search, or -1 before searching.
+	searchStart int
+	next        int
+	// Set when other sources come before the search, until Search is
+	// called.
+	officialOnly bool
+	// URL of the last candidate returned.
+	Url string
+}
+
+// Lists the official image URLs of a game. The search only happens if they
+// all fail.
+func NewImageCandidates(game *Game, asset *AssetType) *ImageCandidates {
+	candidates := &ImageCandidates{game: game, asset: asset, searchStart: -1, officialOnly: getSourceOrder()[0] != "search"}
+	for _, id := range []string{game.Id, game.Id2} {
+		if id == "" {
+			continue
+		}
+		for _, urlFormat := range asset.UrlFormats {
+			candidates.urls = append(candidates.urls, fmt.Sprintf(urlFormat, id))
+		}
+	}
+	return candidates
+}
+
+// Fetches the next candidate that exists. Returns the response, nil when
+// there are no more candidates, and a flag indicating if it was from a Google
+// search (useful because we want to log the lower quality images). Failed
+// downloads are skipped, and only failing to search is an error.
+func (c *ImageCandidates) Next() (response *http.Response, fromSearch bool, err error) {
+	for {
+		if c.next >= len(c.urls) {
+			if c.searchStart >= 0 || c.asset != bannerAsset || *noSearch || c.officialOnly {
+				return nil, false, nil
+			}
+			c.searchStart = len(c.urls)
+			urls, err := getGoogleImages(c.game.Name)
+			if err != nil {
+				return nil, false, err
+			}
+			c.urls = append(c.urls, urls...)
+			continue
+		}
+
+		url := c.urls[c.next]
+		c.next++
+		response, err := tryDownload(url)
+		if err == nil && response != nil {
+			c.Url = url
+			return response, c.searchStart >= 0, nil
+		}
+	}
+}
+
+// Returns the sources looked at so far: "steam" for the official images,
+// and "search" if it came to that.
+func (c *ImageCandidates) Tried() []string {
+	tried := make([]string, 0, 2)
+	if c.next > 0 && c.searchStart != 0 {
+		tried = append(tried, "steam")
+	}
+	if c.searchStart >= 0 {
+		tried = append(tried, "search")
+	}
+	return tried
+}
+
+// Downloads the next candidate into game.ImageFile, skipping the ones that
+// fail halfway. Returns false if there are no more candidates.
+func (c *ImageCandidates) Download() (bool, error) {
+	var imagePath string
+	var fromSearch bool
+	for {
+		var response *http.Response
+		var err error
+		response, fromSearch, err = c.Next()
+		if response == nil || err != nil {
+			return false, err
+		}
+
+		imagePath, err = readDownload(c.Url, response)
+		if err == nil {
+			break
+		}
+		// Cut short or corrupt, so try the next one.
+	}
+
 	if fromSearch {
-		game.ImageSource = "search"
+		c.game.ImageSource = "search"
 	} else {
-		game.ImageSource = "download"
+		c.game.ImageSource = "download"
 	}
 
-	game.ImageBytes = imageBytes
+	c.game.ImageBytes, c.game.ImageFile = nil, imagePath
+	c.game.ImageUrl = c.Url
+	return true, nil
+}
+
+// Goes on with the Google results once the official images are used up, when
+// the search comes after other sources. Returns false if there are none.
+func (c *ImageCandidates) Search() (bool, error) {
+	c.officialOnly = false
+	return c.Download()
+}
+
+// Tries to download the game images, saving it in game.ImageFile. Banners
+// pre-fetched from the wishlist are used first. Returns the remaining
+// candidates, for when the image is rejected in the review.
+func DownloadImage(game *Game, asset *AssetType) (*ImageCandidates, error) {
+	candidates := NewImageCandidates(game, asset)
+	if asset == bannerAsset && loadCachedImage(game) {
+		return candidates, nil
+	}
+
+	_, err := candidates.Download()
+	return candidates, err
+}
+
+// Images to download again even if we have them, given with
+// --force-refresh: "all", or asset type names and sources (download,
+// search, collage, generated), like "portrait,search".
+var forceRefresh = new(string)
+
+// Sources that --force-refresh can pick.
+var refreshSources = []string{"download", "search", "collage", "generated"}
+
+// Order of the sources tried for the images Steam doesn't have, given with
+// --source-order.
+var sourceOrder = new(string)
+
+// Sources that --source-order can order.
+var fallbackSources = []string{"search", "collage", "placeholder"}
+
+// Returns the sources to try after the official images, in order. The ones
+// missing from --source-order come last.
+func getSourceOrder() []string {
+	order := make([]string, 0, len(fallbackSources))
+	for _, source := range append(splitList(*sourceOrder), fallbackSources...) {
+		if containsString(fallbackSources, source) && !containsString(order, source) {
+			order = append(order, source)
+		}
+	}
+	return order
+}
+
+// Checks that --source-order only has sources we know.
+func checkSourceOrder() error {
+	for _, source := range splitList(*sourceOrder) {
+		if !containsString(fallbackSources, source) {
+			return errors.New(tr("Unknown --source-order '%v', expected some of: %v", source, strings.Join(fallbackSources, ", ")))
+		}
+	}
 	return nil
 }
+
+// Returns true if the image we installed for a game should be downloaded
+// again, per --force-refresh. Images the user had before us are never
+// refreshed, since we can't get them back.
+func isForcedRefresh(user User, game *Game, asset *AssetType) bool {
+	items := splitList(*forceRefresh)
+	if len(items) == 0 {
+		return false
+	}
+	source, err := ioutil.ReadFile(filepath.Join(getImageBackupDir(user, game.Id, asset), downloadedMarkName))
+	if err != nil {
+		return false
+	}
+	if containsString(items, "all") {
+		return true
+	}
+	// Each list only counts if it was given.
+	assetMatches, sourceMatches := true, true
+	for _, item := range items {
+		if containsString(refreshSources, item) {
+			sourceMatches = false
+		} else {
+			assetMatches = false
+		}
+	}
+	for _, item := range items {
+		assetMatches = assetMatches || item == asset.Name
+		sourceMatches = sourceMatches || item == string(source)
+	}
+	return assetMatches && sourceMatches
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Writes a file in the Steam folder. In dry-run mode nothing is written,
+// and the file that would be created or overwritten is printed to output
+// instead, or to the console if it's nil.
+func writeFile(output io.Writer, path string, data []byte) error {
+	return writeFileFrom(output, path, bytes.NewReader(data), int64(len(data)))
+}
+
+// Writes a file in the Steam folder like writeFile, with the contents read
+// from a reader of the given size.
+func writeFileFrom(output io.Writer, path string, reader io.Reader, size int64) error {
+	if *dryRun {
+		if _, err := os.Stat(path); err == nil {
+			fmt.Fprint(getOutput(output), tr("  would overwrite %v\n", path))
+		} else {
+			fmt.Fprint(getOutput(output), tr("  would create %v\n", path))
+		}
+		return nil
+	}
+	if err := journalFile(path); err != nil {
+		return err
+	}
+	logf(LogVerbose, "Writing %v (%v)", path, formatBytes(size))
+	return writeReaderAtomic(path, reader, 0644)
+}
+
+// Writes a file by writing a temporary file next to it and renaming it
+// over, so a crash, or Steam reading it halfway, never sees it half
+// written.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeReaderAtomic(path, bytes.NewReader(data), perm)
+}
+
+// Writes a file atomically like writeFileAtomic, with the contents read
+// from a reader. A file replaced keeps its permissions, a new one gets perm.
+func writeReaderAtomic(path string, reader io.Reader, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	file, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	// The rename itself is only durable once the folder is synced, which
+	// Windows doesn't support.
+	if runtime.GOOS != "windows" {
+		if dirFile, err := os.Open(dir); err == nil {
+			dirFile.Sync()
+			dirFile.Close()
+		}
+	}
+	return nil
+}
+
+// Deletes a file in the Steam folder, if it exists, keeping a copy in the
+// journal of the run. In dry-run mode it's only printed, like in writeFile.
+func removeFile(output io.Writer, path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	if *dryRun {
+		fmt.Fprint(getOutput(output), tr("  would delete %v\n", path))
+		return nil
+	}
+	if err := journalFile(path); err != nil {
+		return err
+	}
+	logf(LogVerbose, "Deleting %v", path)
+	return os.Remove(path)
+}
+
+// Returns where to print, the console unless output is set.
+func getOutput(output io.Writer) io.Writer {
+	if output == nil {
+		return os.Stdout
+	}
+	return output
+}
@@ -3,14 +3,20 @@ package main
 import (
 	"fmt"
 	"bytes"
+	"encoding/json"
+	"html"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"os/exec"
+	"unicode"
+	"unicode/utf8"
 )
 
 // A Steam game in a library. May or may not be installed.
@@ -21,19 +27,84 @@ type Game struct {
 	Name string
 	// Tags, including user-created category and Steam's "Favorite" tag.
 	Tags []string
-	// Path for the grid image.
+	// Extra tags that are not Steam categories, like store genres. They are
+	// used for overlays but never written back to Steam.
+	VirtualTags []string
+	// Path for the grid image of the asset type being processed, loaded with
+	// LoadGridImage.
 	ImagePath string
 	// Raw bytes of the encoded image (usually jpg).
 	ImageBytes []byte
+	// File with the image downloaded, when it wasn't read into ImageBytes,
+	// which only happens to decode it. ImageBytes wins if both are set.
+	ImageFile string
 	// Description of where the image was found (backup, official, search).
 	ImageSource string
+	// URL or file the image was loaded from, to tell in errors and reports.
+	ImageUrl string
 	// Real id for non-steam games
 	Id2 string
+	// True if the game is installed in one of the Steam libraries. Non-Steam
+	// games are always considered installed.
+	Installed bool
+	// True if the user hid the game in Steam.
+	Hidden bool
+	// True if the game is in Steam's favorites.
+	Favorite bool
+	// True if the game is borrowed through Family Sharing: a game found only
+	// in the local config, when the profile did load.
+	Shared bool
+	// Minutes played, from the local config.
+	Playtime int
+	// Labels to draw on top of the image, after the overlays.
+	Badges []Badge
+	// Store metadata, only loaded when a feature needs it.
+	Store *StoreDetails
+	// Where the lines about the game go while it's processed, nil for the
+	// console. Runs doing several games at once keep each game's apart.
+	Output io.Writer
+}
+
+// Returns both Steam categories and virtual tags.
+func (game *Game) AllTags() []string {
+	tags := make([]string, 0, len(game.Tags)+len(game.VirtualTags))
+	tags = append(tags, game.Tags...)
+	return append(tags, game.VirtualTags...)
 }
 
 // Pattern of game declarations in the public profile. It's actually JSON
-// inside Javascript, but this way is easier to extract.
-const profileGamePattern = `\{"appid":\s*(\d+),\s*"name":\s*"(.+?)"`
+// inside Javascript, but this way is easier to extract. Names can have
+// escaped quotes.
+const profileGamePattern = `\{"appid":\s*(\d+),\s*"name":\s*"((?:[^"\\]|\\.)*)"`
+
+// Cleans up a game name from the profile or the shortcuts: decodes HTML
+// entities like "&amp;", which some names have twice, and removes invisible
+// characters, broken bytes and repeated or odd spaces.
+func normalizeGameName(name string) string {
+	for i := 0; i < 2 && strings.Contains(name, "&"); i++ {
+		name = html.UnescapeString(name)
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, name)
+	// Also turns non-breaking and other Unicode spaces into plain ones.
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// Returns a game name fit for search queries, without the trademark signs
+// that make searches miss.
+func getSearchName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '™' || r == '®' || r == '©' {
+			return ' '
+		}
+		return r
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
 
 // Fetches the list of games from the public user profile. This is better than
 // looking locally because the profiles give the full game name, which can be
@@ -48,10 +119,12 @@ func addGamesFromProfile(user User, games map[string]*Game) (err error) {
 	pattern := regexp.MustCompile(profileGamePattern)
 	for _, groups := range pattern.FindAllStringSubmatch(profile, -1) {
 		gameId := groups[1]
+		// Decodes the JSON escapes, like \u00ae and \".
 		gameName := groups[2]
+		json.Unmarshal([]byte(`"`+groups[2]+`"`), &gameName)
 		tags := []string{""}
 		imagePath := ""
-		games[gameId] = &Game{gameId, gameName, tags, imagePath, nil, "", ""}
+		games[gameId] = &Game{Id: gameId, Name: gameName, Tags: tags, ImagePath: imagePath}
 	}
 
 	return
@@ -71,16 +144,31 @@ func addUnknownGames(user User, games map[string]*Game) {
 		return
 	}
 
-	sharedConf := string(sharedConfBytes)
-	// VDF pattern: "steamid" { "tags { "0" "category" } }
-	gamePattern := regexp.MustCompile(`"([0-9]+)"\s*{[^}]+?"tags"\s*{([^}]+?)}`)
-	tagsPattern := regexp.MustCompile(`"[0-9]+"\s*"(.+?)"`)
-	for _, gameGroups := range gamePattern.FindAllStringSubmatch(sharedConf, -1) {
-		gameId := gameGroups[1]
-		tagsText := gameGroups[2]
+	root, err := ParseVdf(sharedConfBytes)
+	if err != nil {
+		logf(LogWarning, "Failed to read the categories in %v: %v", sharedConfFile, err.Error())
+		return
+	}
 
-		for _, tagGroups := range tagsPattern.FindAllStringSubmatch(tagsText, -1) {
-			tag := tagGroups[1]
+	// "UserRoamingConfigStore" { ... "apps" { "steamid" { "tags" { "0" "category" } } } }
+	apps := root.Path("UserRoamingConfigStore", "Software", "Valve", "Steam", "apps")
+	if apps == nil {
+		return
+	}
+	for _, app := range apps.Children {
+		if !app.IsBlock || !appIdPattern.MatchString(app.Key) {
+			continue
+		}
+		gameId := app.Key
+		var tags []*VdfNode
+		if node := app.Child("tags"); node != nil {
+			tags = node.Children
+		}
+		for _, tagNode := range tags {
+			tag := tagNode.Value
+			if tagNode.IsBlock || tag == "" {
+				continue
+			}
 
 			game, ok := games[gameId]
 			if ok {
@@ -89,9 +177,83 @@ func addUnknownGames(user User, games map[string]*Game) {
 				// If for some reason it wasn't included in the profile, create a new
 				// entry for it now. Unfortunately we don't have a name.
 				gameName := ""
-				games[gameId] = &Game{gameId, gameName, []string{tag}, "", nil, "", ""}
+				game = &Game{Id: gameId, Name: gameName, Tags: []string{tag}}
+				games[gameId] = game
+			}
+			if strings.EqualFold(tag, "hidden") {
+				game.Hidden = true
+			}
+			if strings.EqualFold(tag, "favorite") {
+				game.Favorite = true
+			}
+		}
+		// Hidden games may also be flagged outside the tags, with "Hidden" "1".
+		if game, ok := games[gameId]; ok && app.Get("Hidden") == "1" {
+			game.Hidden = true
+		}
+	}
+}
+
+// Loads the playtimes and adds games that are not in the profile because
+// they are borrowed through Family Sharing. Steam keeps a local entry for
+// every app the user has launched or downloaded, including shared ones, in
+// the "apps" block of config/localconfig.vdf. Tools, redistributables and
+// servers are there too, so only apps that appinfo.vdf says are games are
+// added, or apps that were played when it can't be read. They are only
+// marked as shared when the profile loaded, else they may be owned games
+// it failed to list.
+func addLocalConfigGames(user User, games map[string]*Game, profileLoaded bool) {
+	localConfBytes, err := ioutil.ReadFile(filepath.Join(user.Dir, "config", "localconfig.vdf"))
+	if err != nil {
+		return
+	}
+	root, err := ParseVdf(localConfBytes)
+	if err != nil {
+		return
+	}
+
+	apps := root.Path("UserLocalConfigStore", "Software", "Valve", "Steam", "apps")
+	if apps == nil {
+		return
+	}
+	unknownIds := make(map[string]bool)
+	for _, app := range apps.Children {
+		if !app.IsBlock || !appIdPattern.MatchString(app.Key) {
+			continue
+		}
+		if game, ok := games[app.Key]; ok {
+			// Minutes played, as shown in the library.
+			game.Playtime, _ = strconv.Atoi(app.Get("Playtime"))
+		} else {
+			unknownIds[app.Key] = true
+		}
+	}
+	if len(unknownIds) == 0 {
+		return
+	}
+
+	// The user folder is in userdata, in the Steam installation.
+	infos, infoErr := GetAppInfo(filepath.Dir(filepath.Dir(user.Dir)), unknownIds)
+	for _, app := range apps.Children {
+		if !unknownIds[app.Key] {
+			continue
+		}
+		name := ""
+		if info, ok := infos[app.Key]; ok {
+			if info.Type != "game" {
+				continue
 			}
+			name = info.Name
+		} else if infoErr == nil || app.Get("LastPlayed") == "" {
+			continue
 		}
+		game := &Game{Id: app.Key, Name: name, Tags: []string{}, Shared: profileLoaded}
+		if game.Shared {
+			// Lets users have a "shared" overlay and category.
+			game.VirtualTags = []string{"shared"}
+		}
+		game.Playtime, _ = strconv.Atoi(app.Get("Playtime"))
+		games[app.Key] = game
 	}
 }
 
@@ -132,7 +294,7 @@ func addNonSteamGames(user User, games map[string]*Game) {
 
 		gameId2 := string(out)
 
-		game := Game{gameId, string(gameName), []string{}, "", nil, "", gameId2}
+		game := Game{Id: gameId, Name: string(gameName), Tags: []string{}, Id2: gameId2, Installed: true}
 		games[gameId] = &game
 
 		tagsText := gameGroups[3]
@@ -143,46 +305,55 @@ func addNonSteamGames(user User, games map[string]*Game) {
 	}
 }
 
+// Removes the games that don't match any of the comma separated patterns in
+// the filter, which are app ids or case insensitive name globs like
+// "Half-Life*". An empty filter keeps all games.
+func FilterGames(games map[string]*Game, filter string) {
+	if len(splitList(filter)) == 0 {
+		return
+	}
+	for id, game := range games {
+		if !matchesAnyIdOrName(game, filter) {
+			delete(games, id)
+		}
+	}
+}
+
+// Returns the games in the order they should be processed: sorted by name
+// and id, with favorites first if favoritesFirst is set, because they are
+// the most visible in the library.
+func SortGames(games map[string]*Game, favoritesFirst bool) []*Game {
+	sorted := make([]*Game, 0, len(games))
+	for _, game := range games {
+		sorted = append(sorted, game)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if favoritesFirst && a.Favorite != b.Favorite {
+			return a.Favorite
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Id < b.Id
+	})
+	return sorted
+}
+
 // Returns all games from a given user, using both the public profile and local
-// files to gather the data. Returns a map of game by ID.
-func GetGames(user User) map[string]*Game {
+// files to gather the data. Returns a map of game by ID. If the public
+// profile couldn't be loaded, the games found locally are returned along with
+// the error, since the list may be incomplete.
+func GetGames(user User) (map[string]*Game, error) {
 	games := make(map[string]*Game, 0)
 
-	addGamesFromProfile(user, games)
+	profileErr := addGamesFromProfile(user, games)
 	addUnknownGames(user, games)
+	addLocalConfigGames(user, games, profileErr == nil)
 	addNonSteamGames(user, games)
 
-	suffixes := []string{
-		" (original)..jpg", // Mistakes were made, own up to them.
-		" (original)..png",
-		" (original).jpg",
-		" (original).png",
-		".jpg",
-		".jpeg",
-		".png",
-	}
-
-	// Load existing and backup images.
 	for _, game := range games {
-		gridDir := filepath.Join(user.Dir, "config", "grid")
-		for _, suffix := range suffixes {
-			imagePath := filepath.Join(gridDir, game.Id+suffix)
-			imageBytes, err := ioutil.ReadFile(imagePath)
-			if err == nil {
-				game.ImagePath = filepath.Join(gridDir, game.Id+filepath.Ext(suffix))
-				game.ImageBytes = imageBytes
-				if strings.HasPrefix(suffix, " (original)") {
-					game.ImageSource = "backup"
-				} else {
-					game.ImageSource = "manual customization"
-				}
-				break
-			}
-		}
-		if game.ImageBytes == nil {
-			game.ImagePath = filepath.Join(gridDir, game.Id+".jpg")
-		}
+		game.Name = normalizeGameName(game.Name)
 	}
-
-	return games
+	return games, profileErr
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// Returns the flag set of the gui command, which has the options of a
+// download run.
+func newGuiFlags() *flag.FlagSet {
+	flags := newCommandFlags("gui")
+	addDownloadFlags(flags)
+	return flags
+}
+
+// Opens the web UI of the serve command in an app window of Chrome or Edge,
+// or else a browser tab: the library with thumbnails, the artwork picker with
+// each image before and after the overlays, the run controls and the
+// settings. It runs on a free local port until the quit button is pressed or
+// the program is stopped.
+func runGui(args []string) {
+	flags := newGuiFlags()
+	parseCommandFlags(flags, args)
+	// Everything happens in the window.
+	*headless = true
+	*terminalUI = false
+	checkDownloadFlags()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		errorAndExit(err)
+	}
+	server := newWebServer(flags)
+	server.isApp = true
+	url := "http://" + listener.Addr().String() + "/"
+	if err := openAppWindow(url); err != nil {
+		fmt.Print(tr("Failed to open a window (%v), open %v in a browser instead.\n", err.Error(), url))
+	} else {
+		fmt.Print(tr("SteamGrid is open at %v, close it with the Quit button.\n", url))
+	}
+	server.serve(listener)
+}
+
+// Stops the server of the gui command, once the run going on is cancelled.
+func (server *WebServer) handleQuit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || !server.isApp {
+		http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprint(w, "<!DOCTYPE html><html><body style=\"font-family: sans-serif\">SteamGrid is closed, you can close this window.</body></html>")
+	server.quitOnce.Do(func() { close(server.quit) })
+}
+
+// Returns the Chromium based browsers that can open a page as an app, in a
+// window without tabs or address bar, in the order they are tried.
+func getAppBrowsers() []string {
+	switch runtime.GOOS {
+	case "windows":
+		browsers := make([]string, 0)
+		for _, dir := range []string{os.Getenv("ProgramFiles(x86)"), os.Getenv("ProgramFiles"), os.Getenv("LocalAppData")} {
+			if dir != "" {
+				browsers = append(browsers,
+					filepath.Join(dir, "Microsoft", "Edge", "Application", "msedge.exe"),
+					filepath.Join(dir, "Google", "Chrome", "Application", "chrome.exe"))
+			}
+		}
+		return browsers
+	case "darwin":
+		return []string{
+			"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+			"/Applications/Microsoft Edge.app/Contents/MacOS/Microsoft Edge",
+			"/Applications/Chromium.app/Contents/MacOS/Chromium",
+		}
+	}
+	return []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "microsoft-edge", "brave-browser"}
+}
+
+// Opens a page in an app window of Chrome or Edge if there's one, or else in
+// the default browser.
+func openAppWindow(url string) error {
+	var cmd *exec.Cmd
+	for _, browser := range getAppBrowsers() {
+		if path, err := exec.LookPath(browser); err == nil {
+			cmd = exec.Command(path, "--app="+url)
+			break
+		}
+	}
+	if cmd == nil {
+		switch runtime.GOOS {
+		case "windows":
+			cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+		case "darwin":
+			cmd = exec.Command("open", url)
+		case "linux", "freebsd", "openbsd", "netbsd":
+			cmd = exec.Command("xdg-open", url)
+		default:
+			return errors.New(tr("No browser known on %v", runtime.GOOS))
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// The browser may keep running long after, or hand the page to one
+	// that was open already and exit.
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Line from an INI-style config file. Entries before any [section] header
+// have an empty Section.
+type IniEntry struct {
+	Section string
+	Key     string
+	Value   string
+}
+
+// Reads a simple INI-style file: "key = value" lines, optional [section]
+// headers and full line comments starting with # or ;. Values may be wrapped
+// in double quotes. Entries are returned in file order. A missing file is not
+// an error, it just has no entries.
+func LoadIni(path string) ([]IniEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]IniEntry, 0)
+	section := ""
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		separator := strings.Index(line, "=")
+		if separator == -1 {
+			return nil, fmt.Errorf("Invalid line %v in %v, expected 'key = value': %v", lineNumber, path, line)
+		}
+		key := strings.TrimSpace(line[:separator])
+		value := strings.TrimSpace(line[separator+1:])
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		entries = append(entries, IniEntry{section, key, value})
+	}
+	return entries, scanner.Err()
+}
+
+// Splits a comma separated config value, dropping empty items.
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files changed by the last run in the Steam folder, with copies of what
+// was there before, so the undo command can put everything back exactly
+// as it was.
+type Journal struct {
+	Started time.Time
+	Command string
+	Entries []JournalEntry
+}
+
+// File changed by a run.
+type JournalEntry struct {
+	Path string
+	// Name of the copy of the previous contents in the journal folder, or ""
+	// if the file didn't exist.
+	Saved string
+}
+
+// Journal of the current run. It's only written, replacing the one of the
+// previous run, when the first file changes, so runs that change nothing,
+// like most of the ones of watch, keep the last one undoable.
+var journal = struct {
+	mutex   sync.Mutex
+	current *Journal
+	written bool
+	paths   map[string]bool
+}{}
+
+// Returns the folder of the journal, creating it if necessary. It's kept
+// with the backups rather than in the cache, since cache cleaners would
+// take the only copy of what a run replaced. A journal left in the cache by
+// older versions is moved there.
+func getJournalDir() (string, error) {
+	dir := filepath.Join(getDataDir(), "journal")
+	if !fileExists(dir) {
+		if baseDir, err := os.UserCacheDir(); err == nil {
+			if oldDir := filepath.Join(baseDir, "steamgrid", "journal"); fileExists(oldDir) {
+				if err := moveFile(oldDir, dir); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	return dir, os.MkdirAll(dir, 0777)
+}
+
+// Starts the journal of a new run.
+func startJournal(command string) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	journal.current = &Journal{Started: time.Now(), Command: command, Entries: []JournalEntry{}}
+	journal.written = false
+	journal.paths = make(map[string]bool)
+}
+
+// Saves what is at path before the run changes it, the first time only.
+// Does nothing outside a run. With --no-backup nothing is copied, and the
+// journal of the previous run is dropped since it can't be undone anymore.
+func journalFile(path string) error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	if journal.current == nil || journal.paths[path] {
+		return nil
+	}
+	dir, err := getJournalDir()
+	if err != nil {
+		return err
+	}
+	if !journal.written {
+		// Forget the previous run.
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+		}
+		journal.written = true
+	}
+	if *noBackup {
+		journal.paths[path] = true
+		return nil
+	}
+
+	entry := JournalEntry{Path: path}
+	previous, err := ioutil.ReadFile(path)
+	if err == nil {
+		entry.Saved = strconv.Itoa(len(journal.current.Entries)) + filepath.Ext(path)
+		if err := ioutil.WriteFile(filepath.Join(dir, entry.Saved), previous, 0644); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	journal.current.Entries = append(journal.current.Entries, entry)
+	journal.paths[path] = true
+
+	// Saved after each file, so a run that crashes can be undone too.
+	journalBytes, err := json.MarshalIndent(journal.current, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "journal.json"), journalBytes, 0644)
+}
+
+// Loads the journal of the last run, or nil if there's none.
+func loadJournal() (*Journal, error) {
+	dir, err := getJournalDir()
+	if err != nil {
+		return nil, err
+	}
+	journalBytes, err := ioutil.ReadFile(filepath.Join(dir, "journal.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	last := &Journal{}
+	return last, json.Unmarshal(journalBytes, last)
+}
+
+// Puts back every file changed by the last run, newest first, and deletes
+// the ones it created. The journal is removed once everything is back, so
+// a failed undo can be tried again.
+func UndoJournal(last *Journal) error {
+	dir, err := getJournalDir()
+	if err != nil {
+		return err
+	}
+	for i := len(last.Entries) - 1; i >= 0; i-- {
+		entry := last.Entries[i]
+		if entry.Saved == "" {
+			if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		previous, err := ioutil.ReadFile(filepath.Join(dir, entry.Saved))
+		if err != nil {
+			return err
+		}
+		// Folders deleted whole, like the backups of a cleaned game, are
+		// made again.
+		if err := os.MkdirAll(filepath.Dir(entry.Path), 0777); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(entry.Path, previous, 0644); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// Returns the flag set of the undo command.
+func newUndoFlags() *flag.FlagSet {
+	flags := newCommandFlags("undo")
+	flags.BoolVar(dryRun, "dry-run", false, "Only list the files that would be put back or deleted.")
+	return flags
+}
+
+// Undoes the last run that changed something in the Steam folder.
+func runUndo(args []string) {
+	flags := newUndoFlags()
+	parseCommandFlags(flags, args)
+
+	last, err := loadJournal()
+	if err != nil {
+		errorAndExit(err)
+	}
+	if last == nil || len(last.Entries) == 0 {
+		errorAndExit(errors.New(tr("There's no run to undo.")))
+	}
+	fmt.Print(tr("The last run was '%v', on %v, and changed %v files.\n", strings.TrimSpace("steamgrid "+last.Command), last.Started.Format("2006-01-02 15:04"), len(last.Entries)))
+	if *dryRun {
+		for _, entry := range last.Entries {
+			if entry.Saved == "" {
+				fmt.Println(tr("  would delete %v", entry.Path))
+			} else {
+				fmt.Println(tr("  would put back %v", entry.Path))
+			}
+		}
+		return
+	}
+	if err := UndoJournal(last); err != nil {
+		errorAndExit(errors.New(tr("Failed to undo the last run, run undo again to retry: %v", err.Error())))
+	}
+	logEvent(LogInfo, "undo", LogFields{"files": len(last.Entries)}, "Undid the last run, %v files", len(last.Entries))
+	fmt.Println(tr("Everything is back as it was before the last run."))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the JPEG encoder, given with --jpeg-encoder.
+var jpegEncoder = new(string)
+
+// Chroma subsamplings of --jpeg-subsampling.
+var jpegSubsamplings = []string{"420", "422", "444", "gray"}
+
+// How an image is encoded as JPEG.
+type jpegOptions struct {
+	// From 1 to 100.
+	Quality int
+	// One of jpegSubsamplings.
+	Subsampling string
+}
+
+// Encodes an image as JPEG with the given options.
+type jpegEncodeFunc func(w io.Writer, img image.Image, options jpegOptions) error
+
+// JPEG encoders by name. The standard library one is always there, faster
+// ones register themselves when built in, like libjpeg-turbo with
+// -tags turbojpeg.
+var jpegEncoders = map[string]jpegEncodeFunc{
+	"std": encodeStdJpeg,
+}
+
+// Encodes an image as JPEG with the standard library, which only writes
+// 4:2:0, and with our own writer for the other subsamplings.
+func encodeStdJpeg(w io.Writer, img image.Image, options jpegOptions) error {
+	switch options.Subsampling {
+	case "420":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: options.Quality})
+	case "gray":
+		gray := image.NewGray(img.Bounds())
+		draw.Draw(gray, gray.Rect, img, img.Bounds().Min, draw.Src)
+		return jpeg.Encode(w, gray, &jpeg.Options{Quality: options.Quality})
+	}
+	return writeJpeg(w, img, options.Quality, options.Subsampling)
+}
+
+// Parses an option set per asset type, a list of values for some assets like
+// "hero:85" and at most one without an asset name, the default for the
+// others, which is returned with the key "".
+func parseAssetValues(value string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, item := range splitList(value) {
+		name := ""
+		if i := strings.Index(item, ":"); i >= 0 {
+			name, item = strings.ToLower(strings.TrimSpace(item[:i])), strings.TrimSpace(item[i+1:])
+			if _, err := GetAssetTypes(name); err != nil || name == "all" {
+				return nil, errors.New(tr("Unknown asset type '%v', expected some of: banner, portrait, hero, logo", name))
+			}
+		}
+		if _, ok := values[name]; ok {
+			if name == "" {
+				return nil, errors.New(tr("'%v' has more than one default value.", value))
+			}
+			return nil, errors.New(tr("'%v' has more than one value for %v.", value, name))
+		}
+		values[name] = item
+	}
+	return values, nil
+}
+
+// Returns the value of an option set per asset type for the given asset,
+// or its default.
+func getAssetValue(value string, asset *AssetType, defaultValue string) string {
+	values, err := parseAssetValues(value)
+	if err != nil {
+		return defaultValue
+	}
+	if assetValue, ok := values[asset.Name]; ok {
+		return assetValue
+	}
+	if assetValue, ok := values[""]; ok {
+		return assetValue
+	}
+	return defaultValue
+}
+
+// Returns an error if --jpeg-quality or --jpeg-subsampling can't be parsed
+// or has values out of range.
+func checkJpegOptions() error {
+	qualities, err := parseAssetValues(*jpegQuality)
+	if err != nil {
+		return err
+	}
+	for _, quality := range qualities {
+		if n, err := strconv.Atoi(quality); err != nil || n < 1 || n > 100 {
+			return errors.New(tr("The JPEG quality must be between 1 and 100."))
+		}
+	}
+	subsamplings, err := parseAssetValues(*jpegSubsampling)
+	if err != nil {
+		return err
+	}
+	for _, subsampling := range subsamplings {
+		if !containsString(jpegSubsamplings, subsampling) {
+			return errors.New(tr("Unknown JPEG subsampling '%v', expected one of: %v", subsampling, strings.Join(jpegSubsamplings, ", ")))
+		}
+	}
+	return nil
+}
+
+// Returns the JPEG options of the given asset type.
+func getJpegOptions(asset *AssetType) jpegOptions {
+	quality, err := strconv.Atoi(getAssetValue(*jpegQuality, asset, "90"))
+	if err != nil {
+		quality = 90
+	}
+	return jpegOptions{quality, getAssetValue(*jpegSubsampling, asset, "420")}
+}
+
+// Returns the asset type of a grid image path, from its suffix.
+func getImageAsset(path string) *AssetType {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, asset := range assetTypes {
+		if asset.Suffix != "" && strings.HasSuffix(base, asset.Suffix) {
+			return asset
+		}
+	}
+	return bannerAsset
+}
+
+// Build tags of the encoders that need a C library, so we can tell how to
+// get them when they're missing.
+var jpegEncoderTags = map[string]string{"turbo": "turbojpeg"}
+
+// Returns the names of every JPEG encoder, built in or not, sorted.
+func getJpegEncoderNames() []string {
+	names := make([]string, 0, len(jpegEncoders)+len(jpegEncoderTags))
+	for name := range jpegEncoders {
+		names = append(names, name)
+	}
+	for name := range jpegEncoderTags {
+		if _, ok := jpegEncoders[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns an error if the JPEG encoder of the options is unknown or wasn't
+// built in.
+func checkJpegEncoder() error {
+	if _, ok := jpegEncoders[*jpegEncoder]; ok {
+		return nil
+	}
+	if tag, ok := jpegEncoderTags[*jpegEncoder]; ok {
+		return errors.New(tr("The %v JPEG encoder isn't built in, build steamgrid with '-tags %v' to use it.", *jpegEncoder, tag))
+	}
+	return errors.New(tr("Unknown JPEG encoder '%v', expected one of: %v", *jpegEncoder, strings.Join(getJpegEncoderNames(), ", ")))
+}
+
+// Encodes an image of the given asset type as JPEG with the encoder,
+// quality and subsampling of the options.
+func encodeJpeg(w io.Writer, img image.Image, asset *AssetType) error {
+	encode, ok := jpegEncoders[*jpegEncoder]
+	if !ok {
+		encode = jpegEncoders["std"]
+	}
+	return encode(w, img, getJpegOptions(asset))
+}
@@ -0,0 +1,59 @@
+//go:build turbojpeg
+// +build turbojpeg
+
+package main
+
+// #cgo LDFLAGS: -lturbojpeg
+// #include <stdlib.h>
+// #include <turbojpeg.h>
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+func init() {
+	jpegEncoders["turbo"] = encodeTurboJpeg
+}
+
+// libjpeg-turbo subsamplings by --jpeg-subsampling value.
+var turboSubsamplings = map[string]C.int{
+	"420":  C.TJSAMP_420,
+	"422":  C.TJSAMP_422,
+	"444":  C.TJSAMP_444,
+	"gray": C.TJSAMP_GRAY,
+}
+
+// Encodes an image as JPEG with libjpeg-turbo, a few times faster than the
+// standard library and with smaller files at the same quality.
+func encodeTurboJpeg(w io.Writer, img image.Image, options jpegOptions) error {
+	rgba, ok := img.(*image.RGBA)
+	if !ok || rgba.Rect.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	}
+	if len(rgba.Pix) == 0 {
+		return errors.New("empty image")
+	}
+
+	handle := C.tjInitCompress()
+	if handle == nil {
+		return errors.New("failed to start libjpeg-turbo: " + C.GoString(C.tjGetErrorStr2(nil)))
+	}
+	defer C.tjDestroy(handle)
+
+	var out *C.uchar
+	var size C.ulong
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+	if C.tjCompress2(handle, (*C.uchar)(unsafe.Pointer(&rgba.Pix[0])), C.int(width), C.int(rgba.Stride), C.int(height),
+		C.TJPF_RGBA, &out, &size, turboSubsamplings[options.Subsampling], C.int(options.Quality), 0) != 0 {
+		return errors.New("libjpeg-turbo failed to encode: " + C.GoString(C.tjGetErrorStr2(handle)))
+	}
+	defer C.tjFree(out)
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(out), C.int(size)))
+	return err
+}
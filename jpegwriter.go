@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+)
+
+// Baseline JPEG writer for the chroma subsamplings the standard library
+// doesn't write: 4:4:4, which keeps colored text and thin lines sharp, and
+// 4:2:2. It uses the quantization and Huffman tables of the JPEG spec,
+// annex K, like the standard library.
+
+// Order of the coefficients of a block in the file, by their index in the
+// block row by row.
+var jpegZigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Quantization tables for quality 50, luminance and chrominance, in zigzag
+// order.
+var jpegBaseQuant = [2][64]int{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26, 26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// Huffman table: the number of codes of each length from 1 to 16 bits, and
+// the values they code, shortest first.
+type jpegHuffmanSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// Huffman tables for the DC and AC coefficients of luminance, then of
+// chrominance.
+var jpegHuffmanSpecs = [4]jpegHuffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// Code of each value of a Huffman table, and its length in bits.
+type jpegHuffmanCode struct {
+	code   uint32
+	length uint
+}
+
+// Returns the codes of the values of a Huffman table, canonical like the
+// spec says.
+func buildJpegHuffmanCodes(spec jpegHuffmanSpec) [256]jpegHuffmanCode {
+	var codes [256]jpegHuffmanCode
+	code, k := uint32(0), 0
+	for length, count := range spec.counts {
+		for i := 0; i < int(count); i++ {
+			codes[spec.values[k]] = jpegHuffmanCode{code, uint(length + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// Cosines of the DCT, scaled: jpegCosines[x][u] is C(u)/2 cos((2x+1)uπ/16).
+var jpegCosines = func() (cosines [8][8]float64) {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c := 0.5
+			if u == 0 {
+				c = 0.5 / math.Sqrt2
+			}
+			cosines[x][u] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return
+}()
+
+// Writes the entropy coded data, with the bits of the Huffman codes packed
+// into bytes and every 0xFF byte followed by 0x00.
+type jpegBitWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint
+}
+
+// Writes the lowest n bits of value.
+func (b *jpegBitWriter) write(value uint32, n uint) {
+	b.bits = b.bits<<n | value&(1<<n-1)
+	b.nBits += n
+	for b.nBits >= 8 {
+		c := byte(b.bits >> (b.nBits - 8))
+		b.w.WriteByte(c)
+		if c == 0xff {
+			b.w.WriteByte(0)
+		}
+		b.nBits -= 8
+	}
+}
+
+// Pads the last byte with ones.
+func (b *jpegBitWriter) flush() {
+	if b.nBits > 0 {
+		b.write(0x7f, 8-b.nBits)
+	}
+}
+
+// Returns the number of bits of the magnitude of a coefficient, its
+// category, and the bits that code it: the value itself if positive, else
+// its ones' complement.
+func jpegCategory(value int) (uint, uint32) {
+	magnitude := value
+	if magnitude < 0 {
+		magnitude = -magnitude
+		value--
+	}
+	n := uint(0)
+	for magnitude > 0 {
+		n++
+		magnitude >>= 1
+	}
+	return n, uint32(value)
+}
+
+// Encodes an image as a baseline JPEG with the given quality, from 1 to
+// 100, and subsampling: "444", "422", "420" or "gray".
+func writeJpeg(w io.Writer, img image.Image, quality int, subsampling string) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 || width >= 1<<16 || height >= 1<<16 {
+		return errors.New("invalid image size for a JPEG")
+	}
+	hSampling, vSampling := 1, 1
+	switch subsampling {
+	case "444", "gray":
+	case "422":
+		hSampling = 2
+	case "420":
+		hSampling, vSampling = 2, 2
+	default:
+		return errors.New("unknown chroma subsampling " + subsampling)
+	}
+	nComponents := 3
+	if subsampling == "gray" {
+		nComponents = 1
+	}
+
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	}
+	// Y, Cb and Cr of every pixel, the edges repeated to fill the last
+	// blocks.
+	pixel := func(x, y int) (uint8, uint8, uint8) {
+		if x >= width {
+			x = width - 1
+		}
+		if y >= height {
+			y = height - 1
+		}
+		i := rgba.PixOffset(rgba.Rect.Min.X+x, rgba.Rect.Min.Y+y)
+		return color.RGBToYCbCr(rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+	}
+
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [2][64]int
+	for i := range quant {
+		for j, base := range jpegBaseQuant[i] {
+			value := (base*scale + 50) / 100
+			if value < 1 {
+				value = 1
+			} else if value > 255 {
+				value = 255
+			}
+			quant[i][j] = value
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	// Start of image, and the quantization tables.
+	out.Write([]byte{0xff, 0xd8})
+	nTables := 2
+	if nComponents == 1 {
+		nTables = 1
+	}
+	out.Write([]byte{0xff, 0xdb, 0, byte(2 + 65*nTables)})
+	for i := 0; i < nTables; i++ {
+		out.WriteByte(byte(i))
+		for _, value := range quant[i] {
+			out.WriteByte(byte(value))
+		}
+	}
+	// Frame header, with the sampling of each component.
+	out.Write([]byte{0xff, 0xc0, 0, byte(8 + 3*nComponents), 8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(nComponents)})
+	out.Write([]byte{1, byte(hSampling<<4 | vSampling), 0})
+	if nComponents == 3 {
+		out.Write([]byte{2, 0x11, 1, 3, 0x11, 1})
+	}
+	// Huffman tables.
+	for i := 0; i < 2*nTables; i++ {
+		spec := jpegHuffmanSpecs[i]
+		length := 2 + 1 + 16 + len(spec.values)
+		class := byte(i%2<<4 | i/2)
+		out.Write([]byte{0xff, 0xc4, byte(length >> 8), byte(length), class})
+		out.Write(spec.counts[:])
+		out.Write(spec.values)
+	}
+	// Start of scan.
+	out.Write([]byte{0xff, 0xda, 0, byte(6 + 2*nComponents), byte(nComponents), 1, 0x00})
+	if nComponents == 3 {
+		out.Write([]byte{2, 0x11, 3, 0x11})
+	}
+	out.Write([]byte{0, 63, 0})
+
+	var codes [4][256]jpegHuffmanCode
+	for i := range codes {
+		codes[i] = buildJpegHuffmanCodes(jpegHuffmanSpecs[i])
+	}
+	bits := &jpegBitWriter{w: out}
+	previousDc := [3]int{}
+	var samples [64]float64
+	// Transforms, quantizes and writes the block of a component, whose
+	// samples are already centered around 0.
+	writeBlock := func(component int) {
+		table := 0
+		if component > 0 {
+			table = 1
+		}
+		var coefficients [64]int
+		for v := 0; v < 8; v++ {
+			for u := 0; u < 8; u++ {
+				sum := 0.0
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						sum += samples[y*8+x] * jpegCosines[x][u] * jpegCosines[y][v]
+					}
+				}
+				coefficients[v*8+u] = int(math.Round(sum))
+			}
+		}
+		var zigzag [64]int
+		for i, index := range jpegZigzag {
+			zigzag[i] = int(math.Round(float64(coefficients[index]) / float64(quant[table][i])))
+		}
+
+		dc, ac := codes[2*table], codes[2*table+1]
+		n, value := jpegCategory(zigzag[0] - previousDc[component])
+		previousDc[component] = zigzag[0]
+		bits.write(dc[n].code, dc[n].length)
+		bits.write(value, n)
+		run := 0
+		for i := 1; i < 64; i++ {
+			if zigzag[i] == 0 {
+				run++
+				continue
+			}
+			for ; run > 15; run -= 16 {
+				bits.write(ac[0xf0].code, ac[0xf0].length)
+			}
+			n, value := jpegCategory(zigzag[i])
+			symbol := byte(run<<4) | byte(n)
+			bits.write(ac[symbol].code, ac[symbol].length)
+			bits.write(value, n)
+			run = 0
+		}
+		if run > 0 {
+			bits.write(ac[0].code, ac[0].length)
+		}
+	}
+
+	mcuWidth, mcuHeight := 8*hSampling, 8*vSampling
+	for top := 0; top < height; top += mcuHeight {
+		for left := 0; left < width; left += mcuWidth {
+			for by := 0; by < vSampling; by++ {
+				for bx := 0; bx < hSampling; bx++ {
+					for y := 0; y < 8; y++ {
+						for x := 0; x < 8; x++ {
+							luma, _, _ := pixel(left+bx*8+x, top+by*8+y)
+							samples[y*8+x] = float64(luma) - 128
+						}
+					}
+					writeBlock(0)
+				}
+			}
+			if nComponents == 1 {
+				continue
+			}
+			// Each chroma sample is the average of the pixels it covers.
+			for component := 1; component < 3; component++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						sum := 0
+						for sy := 0; sy < vSampling; sy++ {
+							for sx := 0; sx < hSampling; sx++ {
+								_, cb, cr := pixel(left+x*hSampling+sx, top+y*vSampling+sy)
+								if component == 1 {
+									sum += int(cb)
+								} else {
+									sum += int(cr)
+								}
+							}
+						}
+						samples[y*8+x] = float64(sum)/float64(hSampling*vSampling) - 128
+					}
+				}
+				writeBlock(component)
+			}
+		}
+	}
+	bits.flush()
+	out.Write([]byte{0xff, 0xd9})
+	return out.Flush()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// Returns a test image with gradients and one pixel wide colored stripes,
+// an odd size so the last blocks and chroma samples are partial.
+func newJpegTestImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 61, 37))
+	for y := 0; y < 37; y++ {
+		for x := 0; x < 61; x++ {
+			c := color.RGBA{uint8(x * 4), uint8(y * 6), uint8(255 - x*2), 255}
+			if x > 40 && x%2 == 0 {
+				c = color.RGBA{255, 0, 0, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// Returns the peak signal to noise ratio between two images in the given
+// bounds, in decibels, over the luma only or over every RGB channel.
+func getPsnr(a image.Image, b image.Image, bounds image.Rectangle, lumaOnly bool) float64 {
+	sum, n := 0.0, 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var ca, cb []float64
+			if lumaOnly {
+				ga := color.GrayModel.Convert(a.At(x, y)).(color.Gray)
+				gb := color.GrayModel.Convert(b.At(x, y)).(color.Gray)
+				ca, cb = []float64{float64(ga.Y)}, []float64{float64(gb.Y)}
+			} else {
+				ra, ga, ba, _ := a.At(x, y).RGBA()
+				rb, gb, bb, _ := b.At(x, y).RGBA()
+				ca = []float64{float64(ra >> 8), float64(ga >> 8), float64(ba >> 8)}
+				cb = []float64{float64(rb >> 8), float64(gb >> 8), float64(bb >> 8)}
+			}
+			for i := range ca {
+				sum += (ca[i] - cb[i]) * (ca[i] - cb[i])
+				n++
+			}
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/float64(n)))
+}
+
+func TestWriteJpegRoundTrip(t *testing.T) {
+	img := newJpegTestImage()
+	tests := []struct {
+		subsampling string
+		ratio       image.YCbCrSubsampleRatio
+		minPsnr     float64
+	}{
+		{"444", image.YCbCrSubsampleRatio444, 40},
+		{"422", image.YCbCrSubsampleRatio422, 35},
+		{"420", image.YCbCrSubsampleRatio420, 35},
+		{"gray", 0, 40},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := writeJpeg(buf, img, 95, test.subsampling); err != nil {
+			t.Fatalf("%v: %v", test.subsampling, err)
+		}
+		decoded, err := jpeg.Decode(buf)
+		if err != nil {
+			t.Fatalf("%v: can't decode our own JPEG: %v", test.subsampling, err)
+		}
+		if decoded.Bounds() != img.Bounds() {
+			t.Errorf("%v: decoded size %v, expected %v", test.subsampling, decoded.Bounds(), img.Bounds())
+			continue
+		}
+		lumaOnly := test.subsampling == "gray"
+		if lumaOnly {
+			if _, ok := decoded.(*image.Gray); !ok {
+				t.Errorf("gray: decoded a %T, expected *image.Gray", decoded)
+			}
+		} else if ycbcr, ok := decoded.(*image.YCbCr); !ok {
+			t.Errorf("%v: decoded a %T, expected *image.YCbCr", test.subsampling, decoded)
+		} else if ycbcr.SubsampleRatio != test.ratio {
+			t.Errorf("%v: decoded subsampling %v, expected %v", test.subsampling, ycbcr.SubsampleRatio, test.ratio)
+		}
+		// The gradients, left of the stripes.
+		if psnr := getPsnr(img, decoded, image.Rect(0, 0, 40, 37), lumaOnly); psnr < test.minPsnr {
+			t.Errorf("%v: PSNR %.1f dB, expected at least %v", test.subsampling, psnr, test.minPsnr)
+		}
+	}
+}
+
+func TestWriteJpeg444KeepsColorEdges(t *testing.T) {
+	img := newJpegTestImage()
+	psnrs := make(map[string]float64)
+	for _, subsampling := range []string{"444", "420"} {
+		buf := new(bytes.Buffer)
+		if err := writeJpeg(buf, img, 95, subsampling); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := jpeg.Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		psnrs[subsampling] = getPsnr(img, decoded, image.Rect(41, 0, 61, 37), false)
+	}
+	if psnrs["444"] <= psnrs["420"] {
+		t.Errorf("4:4:4 stripes have a PSNR of %.1f dB, not better than 4:2:0 with %.1f dB", psnrs["444"], psnrs["420"])
+	}
+}
+
+func TestWriteJpegRejectsUnknownSubsampling(t *testing.T) {
+	if err := writeJpeg(new(bytes.Buffer), newJpegTestImage(), 90, "411"); err == nil {
+		t.Error("expected an error for subsampling 411")
+	}
+}
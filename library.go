@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Returns the "steamapps" folders of all Steam libraries, starting with the
+// one inside the installation dir. Extra libraries are listed in
+// libraryfolders.vdf, either as "1" "path" (old format) or as blocks with a
+// "path" key (new format).
+func getLibraryDirs(installationDir string) []string {
+	mainDir := filepath.Join(installationDir, "steamapps")
+	dirs := []string{mainDir}
+
+	libraryBytes, err := ioutil.ReadFile(filepath.Join(mainDir, "libraryfolders.vdf"))
+	if err != nil {
+		return dirs
+	}
+	root, err := ParseVdf(libraryBytes)
+	if err != nil {
+		return dirs
+	}
+	folders := root.Child("libraryfolders")
+	if folders == nil {
+		return dirs
+	}
+
+	for _, folder := range folders.Children {
+		if _, err := strconv.Atoi(folder.Key); err != nil {
+			continue
+		}
+		path := folder.Value
+		if folder.IsBlock {
+			path = folder.Get("path")
+		}
+		if path == "" {
+			continue
+		}
+		dir := filepath.Join(path, "steamapps")
+		if dir != mainDir {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Manifest of an installed game, named after its app id.
+var manifestPattern = regexp.MustCompile(`^appmanifest_(\d+)\.acf$`)
+
+// Returns the set of Steam app ids installed in any library, found by their
+// appmanifest_ID.acf files.
+func GetInstalledGames(installationDir string) map[string]bool {
+	installed := make(map[string]bool)
+	for _, dir := range getLibraryDirs(installationDir) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if groups := manifestPattern.FindStringSubmatch(file.Name()); groups != nil {
+				installed[groups[1]] = true
+			}
+		}
+	}
+	return installed
+}
+
+// Store data Steam keeps about an app.
+type AppInfo struct {
+	// In lower case, like "game", "tool", "application", "config" or "dlc".
+	Type string
+	Name string
+}
+
+// Magic numbers at the start of the appinfo.vdf versions we can read.
+// Version 28 added a second hash to the app headers, and version 29 moved
+// the keys to a string table at the end of the file.
+const (
+	appInfoVersion27 = 0x07564427
+	appInfoVersion28 = 0x07564428
+	appInfoVersion29 = 0x07564429
+)
+
+// Returns the type and name of the given apps, from appcache/appinfo.vdf
+// where Steam caches the store data of every app it has seen. Apps that
+// aren't in it are left out.
+func GetAppInfo(installationDir string, ids map[string]bool) (map[string]AppInfo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(installationDir, "appcache", "appinfo.vdf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 16 {
+		return nil, errors.New("appinfo.vdf is too short.")
+	}
+	// Bytes between the size of an app and its data.
+	headerSize := 60
+	pos := 8
+	var keys []string
+	switch binary.LittleEndian.Uint32(data) {
+	case appInfoVersion27:
+		headerSize = 40
+	case appInfoVersion28:
+	case appInfoVersion29:
+		pos = 16
+		if keys, err = readAppInfoKeys(data, binary.LittleEndian.Uint64(data[8:])); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Unknown version of appinfo.vdf.")
+	}
+
+	infos := make(map[string]AppInfo)
+	for pos+8 <= len(data) {
+		appId := binary.LittleEndian.Uint32(data[pos:])
+		if appId == 0 {
+			break
+		}
+		start, end := pos+8+headerSize, pos+8+int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if start > end || end > len(data) {
+			return infos, errors.New("appinfo.vdf is truncated.")
+		}
+		pos = end
+		id := strconv.FormatUint(uint64(appId), 10)
+		if !ids[id] {
+			continue
+		}
+		root, err := ParseBinaryVdf(data[start:end], keys)
+		if err != nil {
+			return infos, err
+		}
+		common := root.Path("appinfo", "common")
+		infos[id] = AppInfo{strings.ToLower(common.Get("type")), common.Get("name")}
+	}
+	return infos, nil
+}
+
+// Reads the string table of appinfo.vdf: a count and as many null
+// terminated strings.
+func readAppInfoKeys(data []byte, offset uint64) ([]string, error) {
+	if offset+4 > uint64(len(data)) {
+		return nil, errors.New("appinfo.vdf is truncated.")
+	}
+	count := binary.LittleEndian.Uint32(data[offset:])
+	if uint64(count) > uint64(len(data))-offset {
+		return nil, errors.New("appinfo.vdf is truncated.")
+	}
+	p := &binaryVdfParser{data: data, pos: int(offset) + 4}
+	keys := make([]string, count)
+	for i := range keys {
+		key, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Language of the messages, given with --language, or "auto" to use the
+// one of the system.
+var language = new(string)
+
+// Translations of the messages, by their English text, loaded from
+// "locales/LANGUAGE.json" next to the program. Empty for English.
+var translations = map[string]string{}
+
+// Returns the language of the system, like "pt_BR.UTF-8" or "de-DE", from
+// the usual variables on Linux and asking the system elsewhere.
+func detectLanguage() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG", "LANGUAGE"} {
+		// LANGUAGE is a list of preferences, like "pt_BR:pt".
+		value := strings.Split(os.Getenv(name), ":")[0]
+		if value != "" && value != "C" && value != "POSIX" {
+			return value
+		}
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", "(Get-Culture).Name")
+	case "darwin":
+		cmd = exec.Command("defaults", "read", "-g", "AppleLocale")
+	default:
+		return ""
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Returns the locale files to try for a language, most specific first:
+// "pt_BR.UTF-8" gives pt-BR and pt.
+func getLanguageCandidates(name string) []string {
+	name = strings.SplitN(name, ".", 2)[0]
+	name = strings.SplitN(name, "@", 2)[0]
+	name = strings.Replace(name, "_", "-", -1)
+	if name == "" {
+		return nil
+	}
+	parts := strings.SplitN(name, "-", 2)
+	candidates := []string{strings.ToLower(parts[0])}
+	if len(parts) == 2 {
+		candidates = append([]string{strings.ToLower(parts[0]) + "-" + strings.ToUpper(parts[1])}, candidates...)
+	}
+	return candidates
+}
+
+// Loads the translations of the chosen language. Messages missing from the
+// file, or a broken file, stay in English.
+func loadTranslations() {
+	name := *language
+	if name == "" || name == "auto" {
+		name = detectLanguage()
+	}
+	translations = map[string]string{}
+	for _, candidate := range getLanguageCandidates(name) {
+		if candidate == "en" {
+			return
+		}
+		path := filepath.Join(filepath.Dir(os.Args[0]), "locales", candidate+".json")
+		localeBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(localeBytes, &translations); err != nil {
+			translations = map[string]string{}
+			fmt.Printf("Failed to load the translations in %v, using English: %v\n", path, err.Error())
+		}
+		logf(LogVerbose, "Using the translations in %v", path)
+		return
+	}
+	if *language != "" && *language != "auto" {
+		fmt.Printf("There's no translation for '%v' in the locales folder, using English.\n", *language)
+	}
+}
+
+// Translates a message and formats it like fmt.Sprintf. The English text is
+// the key in the locale files, so it works without them.
+func tr(format string, args ...interface{}) string {
+	if translated, ok := translations[format]; ok && translated != "" {
+		format = translated
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Importance of a log message. The console only shows the verbose and debug
+// messages asked for, since the rest is printed as usual, while the log file
+// gets everything up to the chosen level.
+type LogLevel int
+
+const (
+	LogError LogLevel = iota
+	LogWarning
+	LogInfo
+	LogVerbose
+	LogDebug
+)
+
+// Names of the levels in the log.
+var logLevelNames = []string{"error", "warning", "info", "verbose", "debug"}
+
+// When the log file is rotated, and how many old ones are kept, as
+// steamgrid.log.1 to steamgrid.log.3.
+const (
+	logMaxSize  = 1 << 20
+	logMaxFiles = 3
+)
+
+var (
+	verbose   = new(bool)
+	debug     = new(bool)
+	logPath   = new(string)
+	logFormat = new(string)
+)
+
+// Destination of the log messages, set up by startLogging.
+var logger = struct {
+	mutex sync.Mutex
+	level LogLevel
+	json  bool
+	// True once the start was logged.
+	started bool
+	// Where the JSON events go, which is the real stdout: everything else
+	// printed goes to stderr in that mode, so scripts only read events.
+	console *os.File
+	file    *os.File
+	size    int64
+}{level: LogInfo}
+
+// Details of a log event, kept as separate fields in the JSON log, like
+// the game id or the source of an image.
+type LogFields map[string]interface{}
+
+// Starts logging at the level given on the command line, and to the log
+// file if one was given.
+func startLogging() error {
+	logger.mutex.Lock()
+	logger.level = LogInfo
+	if *verbose {
+		logger.level = LogVerbose
+	}
+	if *debug {
+		logger.level = LogDebug
+	}
+	switch *logFormat {
+	case "", "text":
+	case "json":
+		if !logger.json {
+			logger.json, logger.console = true, os.Stdout
+			os.Stdout = os.Stderr
+			// It would draw on stdout, between the events.
+			*terminalUI = false
+		}
+	default:
+		logger.mutex.Unlock()
+		return errors.New("Unknown log format '" + *logFormat + "', expected 'text' or 'json'.")
+	}
+	if *logPath != "" && logger.file == nil {
+		if err := openLogFile(); err != nil {
+			logger.mutex.Unlock()
+			return err
+		}
+	}
+	// Only worth saying where someone reads the info messages.
+	started := logger.started || (logger.file == nil && !logger.json)
+	logger.started = true
+	logger.mutex.Unlock()
+	if started {
+		return nil
+	}
+	logEvent(LogInfo, "start", LogFields{"os": runtime.GOOS, "arch": runtime.GOARCH, "args": os.Args[1:]}, "steamgrid started on %v/%v with %v", runtime.GOOS, runtime.GOARCH, strings.Join(os.Args[1:], " "))
+	return nil
+}
+
+// Opens the log file for appending, rotating it first if it's too big.
+// Called with the mutex held.
+func openLogFile() error {
+	if info, err := os.Stat(*logPath); err == nil && info.Size() >= logMaxSize {
+		rotateLogFiles()
+	}
+	file, err := os.OpenFile(*logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("Failed to open the log file: %v", err.Error())
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	logger.file, logger.size = file, info.Size()
+	return nil
+}
+
+// Renames the log file to .1, the old .1 to .2 and so on, dropping the
+// oldest.
+func rotateLogFiles() {
+	os.Remove(*logPath + "." + strconv.Itoa(logMaxFiles))
+	for i := logMaxFiles - 1; i >= 1; i-- {
+		os.Rename(*logPath+"."+strconv.Itoa(i), *logPath+"."+strconv.Itoa(i+1))
+	}
+	os.Rename(*logPath, *logPath+".1")
+}
+
+// Logs a message at the given level, with fmt.Printf formatting.
+func logf(level LogLevel, format string, args ...interface{}) {
+	logEvent(level, "", nil, format, args...)
+}
+
+// Logs an event like "image" or "not_found" with its details.
+// The message is for people and is the only thing in the text log.
+// The JSON log has one object per line with the time, level, event,
+// message and fields.
+func logEvent(level LogLevel, event string, fields LogFields, format string, args ...interface{}) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if level > logger.level {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	now := time.Now()
+
+	var line string
+	if logger.json {
+		line = formatJsonLogLine(now, level, event, fields, message)
+		fmt.Fprint(logger.console, line)
+	} else {
+		line = fmt.Sprintf("%v %-7v %v\n", now.Format("2006-01-02 15:04:05.000"), logLevelNames[level], message)
+		// The terminal UI owns the screen, so it only goes to the file there.
+		if level >= LogVerbose && activeTerminalUI == nil {
+			fmt.Fprintf(os.Stderr, "[%v] %v\n", logLevelNames[level], message)
+		}
+	}
+
+	if logger.file == nil {
+		return
+	}
+	if logger.size+int64(len(line)) > logMaxSize {
+		logger.file.Close()
+		logger.file = nil
+		rotateLogFiles()
+		if openLogFile() != nil {
+			return
+		}
+	}
+	n, _ := logger.file.WriteString(line)
+	logger.size += int64(n)
+}
+
+// Returns the fields of an event about a game, and an asset type if not
+// nil, with the extra fields added.
+func gameLogFields(user User, game *Game, asset *AssetType, extra LogFields) LogFields {
+	fields := LogFields{"user": user.Name, "game_id": game.Id, "game": game.Name}
+	if asset != nil {
+		fields["asset"] = asset.Name
+	}
+	if game.ImageUrl != "" {
+		fields["url"] = game.ImageUrl
+	}
+	for key, value := range extra {
+		fields[key] = value
+	}
+	return fields
+}
+
+// Formats an event as a line of JSON, with the common keys first and the
+// fields sorted, so lines are easy to read too.
+func formatJsonLogLine(now time.Time, level LogLevel, event string, fields LogFields, message string) string {
+	encode := func(v interface{}) string {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			encoded, _ = json.Marshal(fmt.Sprint(v))
+		}
+		return string(encoded)
+	}
+
+	var line strings.Builder
+	line.WriteString(`{"time":` + encode(now.Format(time.RFC3339Nano)) + `,"level":` + encode(logLevelNames[level]))
+	if event != "" {
+		line.WriteString(`,"event":` + encode(event))
+	}
+	line.WriteString(`,"message":` + encode(message))
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		line.WriteString("," + encode(key) + ":" + encode(fields[key]))
+	}
+	line.WriteString("}\n")
+	return line.String()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Shows a desktop notification, with notify-send on Linux, AppleScript on
+// macOS and a toast on Windows.
+func sendNotification(title string, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		cmd = exec.Command("notify-send", "--app-name=SteamGrid", title, message)
+	case "darwin":
+		script := "display notification " + quoteAppleScript(message) + " with title " + quoteAppleScript(title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		// Single quoted PowerShell strings only escape quotes, by doubling.
+		quote := func(text string) string {
+			return "'" + strings.Replace(text, "'", "''", -1) + "'"
+		}
+		script := `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$texts = $template.GetElementsByTagName('text')
+$texts.Item(0).AppendChild($template.CreateTextNode(` + quote(title) + `)) | Out-Null
+$texts.Item(1).AppendChild($template.CreateTextNode(` + quote(message) + `)) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('SteamGrid').Show($toast)`
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	default:
+		return errors.New("Notifications are not supported on " + runtime.GOOS)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(strings.TrimSpace(string(out))) > 0 {
+		return errors.New(err.Error() + ": " + strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// Quotes a string for AppleScript.
+func quoteAppleScript(text string) string {
+	text = strings.Replace(text, `\`, `\\`, -1)
+	return `"` + strings.Replace(text, `"`, `\"`, -1) + `"`
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"image"
 	"image/draw"
-	"image/jpeg"
+	_ "image/gif"
 	"image/png"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -24,20 +28,182 @@ func loadImage(path string) (img image.Image, err error) {
 	return
 }
 
-// Loads the overlays from the given dir, returning a map of name -> image.
-func LoadOverlays(dir string) (overlays map[string]image.Image, err error) {
-	overlays = make(map[string]image.Image, 0)
+// Overlay image and the categories that get it.
+type Overlay struct {
+	// File name, including extension.
+	File  string
+	Image image.Image
+	// Vector source of SVG overlays, rendered again at the final size
+	// instead of resizing Image.
+	Svg *SvgImage
+	// Normalized names of the categories that get this overlay. May be globs
+	// like "rpg*".
+	Categories []string
+	// Regular expressions for categories that get this overlay, written as
+	// /pattern/ in overlays.ini and matched ignoring case.
+	Patterns []*regexp.Regexp
+	// Overlays with higher priority are drawn on top of the others.
+	Priority int
+	// Where the overlay is pinned, like "top-left" (default), "bottom" or
+	// "center".
+	Anchor string
+	// Distance in pixels from the anchored edges, towards the center.
+	OffsetX, OffsetY int
+	// Width of the overlay relative to the game image, keeping the aspect
+	// ratio. Zero means the overlay is designed for the official size of its
+	// asset type and is scaled by as much as the game image differs from it.
+	Scale float64
+}
+
+// Valid values for Overlay.Anchor.
+var overlayAnchors = []string{"top-left", "top", "top-right", "left", "center", "right", "bottom-left", "bottom", "bottom-right"}
+
+// Name of the optional file, inside the overlays dir, with the rules for
+// each overlay.
+const overlayRulesFile = "overlays.ini"
+
+// Normalizes a category or overlay name by lower-casing it and removing
+// trailing "s" from plurals. Also, <, > and / are replaced with - because you
+// can't have them in Windows paths.
+func normalizeCategory(name string) string {
+	name = strings.TrimRight(strings.ToLower(name), "s")
+	name = strings.Replace(name, "<", "-", -1)
+	name = strings.Replace(name, ">", "-", -1)
+	name = strings.Replace(name, "/", "-", -1)
+	return name
+}
+
+// Overlays loaded from a dir, with the options that apply to all of them.
+type OverlaySet struct {
+	Overlays []*Overlay
+	// Asset type the overlays are drawn on.
+	Asset *AssetType
+	// Apply only the matching overlay with the highest priority, instead of
+	// stacking all of them.
+	Single bool
+	// Saturation of the images of games that are not installed, from 0
+	// (grayscale) to 1 (unchanged).
+	UninstalledSaturation float64
+	// Normalized names, or globs, of the categories whose games are left
+	// untouched: no overlays, badges or desaturation, whatever else matches.
+	Excluded []string
+}
+
+// Applies an option from the overlay's section in overlays.ini.
+func (overlay *Overlay) setOption(key, value string) error {
+	var err error
+	switch strings.ToLower(key) {
+	case "categories":
+		overlay.Categories = make([]string, 0)
+		overlay.Patterns = make([]*regexp.Regexp, 0)
+		for _, category := range splitList(value) {
+			if len(category) >= 2 && strings.HasPrefix(category, "/") && strings.HasSuffix(category, "/") {
+				pattern, err := regexp.Compile("(?i)" + category[1:len(category)-1])
+				if err != nil {
+					return errors.New("Invalid category pattern for " + overlay.File + " in " + overlayRulesFile + ": " + err.Error())
+				}
+				overlay.Patterns = append(overlay.Patterns, pattern)
+			} else {
+				overlay.Categories = append(overlay.Categories, normalizeCategory(category))
+			}
+		}
+	case "priority":
+		overlay.Priority, err = strconv.Atoi(value)
+		if err != nil {
+			return errors.New("Invalid priority for " + overlay.File + " in " + overlayRulesFile + ": " + value)
+		}
+	case "anchor":
+		overlay.Anchor = strings.ToLower(value)
+		if !containsString(overlayAnchors, overlay.Anchor) {
+			return errors.New("Invalid anchor for " + overlay.File + " in " + overlayRulesFile + ", expected one of " + strings.Join(overlayAnchors, ", ") + ": " + value)
+		}
+	case "offset":
+		offsets := splitList(value)
+		var errX, errY error
+		if len(offsets) == 2 {
+			overlay.OffsetX, errX = strconv.Atoi(offsets[0])
+			overlay.OffsetY, errY = strconv.Atoi(offsets[1])
+		}
+		if len(offsets) != 2 || errX != nil || errY != nil {
+			return errors.New("Invalid offset for " + overlay.File + " in " + overlayRulesFile + ", expected 'x, y': " + value)
+		}
+	case "scale":
+		overlay.Scale, err = strconv.ParseFloat(value, 64)
+		if err != nil || overlay.Scale <= 0 {
+			return errors.New("Invalid scale for " + overlay.File + " in " + overlayRulesFile + ", expected a number like 0.25: " + value)
+		}
+	default:
+		return errors.New("Unknown option for " + overlay.File + " in " + overlayRulesFile + ": " + key)
+	}
+	return nil
+}
+
+// Applies an option from the top of overlays.ini, before any section.
+func (set *OverlaySet) setOption(key, value string) error {
+	switch strings.ToLower(key) {
+	case "single":
+		single, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("Invalid value for 'single' in " + overlayRulesFile + ", expected true or false: " + value)
+		}
+		set.Single = single
+	case "uninstalled saturation":
+		saturation, err := strconv.ParseFloat(value, 64)
+		if err != nil || saturation < 0 || saturation > 1 {
+			return errors.New("Invalid value for 'uninstalled saturation' in " + overlayRulesFile + ", expected a number from 0 to 1: " + value)
+		}
+		set.UninstalledSaturation = saturation
+	case "exclude":
+		set.Excluded = make([]string, 0)
+		for _, category := range splitList(value) {
+			set.Excluded = append(set.Excluded, normalizeCategory(category))
+		}
+	default:
+		return errors.New("Unknown option in " + overlayRulesFile + ": " + key)
+	}
+	return nil
+}
+
+// Loads the overlays from the given dir. By default an overlay is used for
+// the category with the same name as the file, but overlays.ini can have a
+// section for each file with explicit rules, after the options for all
+// overlays:
+//
+//	single = true
+//	uninstalled saturation = 0.2
+//	exclude = Pristine, Hand made*
+//
+//	[heart.png]
+//	categories = Favorites, Games I Love, rpg*, /^(co-op|multi)/
+//	priority = 10
+//	anchor = bottom-right
+//	offset = 5, 5
+//	scale = 0.2
+func LoadOverlays(dir string, asset *AssetType) (*OverlaySet, error) {
+	set := &OverlaySet{Overlays: make([]*Overlay, 0), Asset: asset, UninstalledSaturation: 1}
 
-	if _, err = os.Stat(dir); err != nil {
-		return overlays, nil
+	if _, err := os.Stat(dir); err != nil {
+		return set, nil
 	}
 
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return
+		return set, err
 	}
 
-	imageExtensions := []string{"png", "jpg", "jpeg", "gif"}
+	rules, err := LoadIni(filepath.Join(dir, overlayRulesFile))
+	if err != nil {
+		return set, err
+	}
+	for _, rule := range rules {
+		if rule.Section == "" {
+			if err := set.setOption(rule.Key, rule.Value); err != nil {
+				return set, err
+			}
+		}
+	}
+
+	imageExtensions := []string{"png", "jpg", "jpeg", "gif", "svg"}
 
 	for _, file := range files {
 		isImage := false
@@ -48,49 +214,201 @@ func LoadOverlays(dir string) (overlays map[string]image.Image, err error) {
 			continue
 		}
 
-		img, err := loadImage(filepath.Join(dir, file.Name()))
-		if err != nil {
-			return overlays, err
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		overlay := &Overlay{File: file.Name(), Categories: []string{normalizeCategory(name)}}
+		if strings.HasSuffix(file.Name(), "svg") {
+			// The natural size of an SVG is in pixels of the asset's
+			// official size, like the size of any other overlay.
+			overlay.Svg, err = LoadSvg(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return set, errors.New("Failed to load overlay " + file.Name() + ": " + err.Error())
+			}
+			overlay.Image = overlay.Svg.Render(int(overlay.Svg.Width+0.5), int(overlay.Svg.Height+0.5))
+		} else {
+			overlay.Image, err = loadImage(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return set, err
+			}
+		}
+		for _, rule := range rules {
+			if strings.EqualFold(rule.Section, file.Name()) {
+				if err := overlay.setOption(rule.Key, rule.Value); err != nil {
+					return set, err
+				}
+			}
 		}
+		set.Overlays = append(set.Overlays, overlay)
+	}
 
-		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
-		// Normalize overlay name.
-		name = strings.TrimRight(strings.ToLower(name), "s")
-		overlays[name] = img
+	return set, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
 	}
+	return false
+}
 
-	return
+// Returns the overlay image scaled for a game image with the given bounds,
+// and the rectangle where it should be drawn according to its anchor and
+// offset. Offsets are in pixels of the asset's official size, so they scale
+// with the game image.
+func (overlay *Overlay) Placement(bounds image.Rectangle, asset *AssetType) (image.Image, image.Rectangle) {
+	img := overlay.Image
+	size := img.Bounds().Size()
+	scaleX := float64(bounds.Dx()) / float64(asset.Width)
+	scaleY := float64(bounds.Dy()) / float64(asset.Height)
+	if overlay.Scale > 0 {
+		scaleX = overlay.Scale * float64(bounds.Dx()) / float64(size.X)
+		scaleY = scaleX
+	}
+	if scaleX != 1 || scaleY != 1 {
+		size = image.Pt(int(float64(size.X)*scaleX+0.5), int(float64(size.Y)*scaleY+0.5))
+		if overlay.Svg != nil {
+			img = overlay.Svg.Render(size.X, size.Y)
+		} else {
+			img = resizeImage(img, size.X, size.Y)
+		}
+	}
+	offsetX := overlay.OffsetX * bounds.Dx() / asset.Width
+	offsetY := overlay.OffsetY * bounds.Dy() / asset.Height
+
+	x, y := bounds.Min.X+offsetX, bounds.Min.Y+offsetY
+	if strings.HasSuffix(overlay.Anchor, "right") {
+		x = bounds.Max.X - size.X - offsetX
+	} else if overlay.Anchor == "top" || overlay.Anchor == "center" || overlay.Anchor == "bottom" {
+		x = bounds.Min.X + (bounds.Dx()-size.X)/2 + offsetX
+	}
+	if strings.HasPrefix(overlay.Anchor, "bottom") {
+		y = bounds.Max.Y - size.Y - offsetY
+	} else if overlay.Anchor == "left" || overlay.Anchor == "center" || overlay.Anchor == "right" {
+		y = bounds.Min.Y + (bounds.Dy()-size.Y)/2 + offsetY
+	}
+	return img, image.Rect(x, y, x+size.X, y+size.Y)
+}
+
+// Returns true if the overlay should be applied to games with the given
+// category.
+func (overlay *Overlay) Matches(category string) bool {
+	normalized := normalizeCategory(category)
+	for _, overlayCategory := range overlay.Categories {
+		// Exact names first, so names with brackets still work.
+		if overlayCategory == normalized {
+			return true
+		}
+		if matched, _ := filepath.Match(overlayCategory, normalized); matched {
+			return true
+		}
+	}
+	for _, pattern := range overlay.Patterns {
+		if pattern.MatchString(category) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any of the tags is in the excluded categories.
+func (set *OverlaySet) IsExcluded(tags []string) bool {
+	for _, tag := range tags {
+		normalized := normalizeCategory(tag)
+		for _, excluded := range set.Excluded {
+			if excluded == normalized {
+				return true
+			}
+			if matched, _ := filepath.Match(excluded, normalized); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the overlays for a game with the given tags, each only once even
+// if several tags match it, in the order they should be drawn. The order
+// depends only on the overlays, never on the order of the tags, so repeated
+// runs give identical images: by priority, then by file name. In single
+// mode only the last one, which would be drawn on top, is returned.
+func (set *OverlaySet) Match(tags []string) []*Overlay {
+	matched := make([]*Overlay, 0)
+	for _, overlay := range set.Overlays {
+		for _, tag := range tags {
+			if overlay.Matches(tag) {
+				matched = append(matched, overlay)
+				break
+			}
+		}
+	}
+	sortOverlays(matched)
+	if set.Single && len(matched) > 1 {
+		matched = matched[len(matched)-1:]
+	}
+	return matched
+}
+
+// Sorts overlays in drawing order: by priority, then by file name.
+func sortOverlays(overlays []*Overlay) {
+	sort.Slice(overlays, func(i, j int) bool {
+		if overlays[i].Priority != overlays[j].Priority {
+			return overlays[i].Priority < overlays[j].Priority
+		}
+		return overlays[i].File < overlays[j].File
+	})
 }
 
 // Applies an overlay to the game image, depending on the category. The
-// resulting image is saved over the original.
-func ApplyOverlay(game *Game, overlays map[string]image.Image) (applied bool, err error) {
-	if game.ImagePath == "" || game.ImageBytes == nil || len(game.Tags) == 0 {
+// resulting image is saved over the original. Overlays with higher priority
+// are drawn last, on top of the others, and the game badges go on top of
+// everything, if the asset type has badges. Games in an excluded category
+// are left untouched.
+func ApplyOverlay(game *Game, overlays *OverlaySet) (applied bool, err error) {
+	tags := game.AllTags()
+	if overlays.IsExcluded(tags) {
+		logf(LogVerbose, "%v is excluded from the %v overlays", game.Id, overlays.Asset.Name)
+		return false, nil
+	}
+	desaturate := !game.Installed && overlays.UninstalledSaturation < 1
+	var badges []Badge
+	if overlays.Asset.Badges {
+		badges = game.Badges
+	}
+	if game.ImagePath == "" || !game.HasImage() || (len(tags) == 0 && len(badges) == 0 && !desaturate) {
 		return false, nil
 	}
+	// Only now that it's drawn on is the image read into memory.
+	if err := game.LoadImageBytes(); err != nil {
+		return false, err
+	}
 
-	gameImage, _, err := image.Decode(bytes.NewBuffer(game.ImageBytes))
+	gameImage, format, err := image.Decode(bytes.NewBuffer(game.ImageBytes))
 	if err != nil {
 		return false, err
 	}
 
-	for _, tag := range game.Tags {
-		// Normalize tag name by lower-casing it and remove trailing "s" from
-		// plurals. Also, <, > and / are replaced with - because you can't have
-		// them in Windows paths.
-		tagName := strings.TrimRight(strings.ToLower(tag), "s")
-		tagName = strings.Replace(tagName, "<", "-", -1)
-		tagName = strings.Replace(tagName, ">", "-", -1)
-		tagName = strings.Replace(tagName, "/", "-", -1)
+	if desaturate {
+		gameImage = desaturateImage(gameImage, overlays.UninstalledSaturation)
+		applied = true
+	}
 
-		overlayImage, ok := overlays[tagName]
-		if !ok {
-			continue
-		}
+	matched := overlays.Match(tags)
 
-		result := image.NewRGBA(gameImage.Bounds().Union(overlayImage.Bounds()))
-		draw.Draw(result, result.Bounds(), gameImage, image.ZP, draw.Src)
-		draw.Draw(result, result.Bounds(), overlayImage, image.Point{0, 0}, draw.Over)
+	for _, overlay := range matched {
+		logf(LogVerbose, "Drawing %v on %v", overlay.File, game.Id)
+		overlayImage, overlayRect := overlay.Placement(gameImage.Bounds(), overlays.Asset)
+		result := image.NewRGBA(gameImage.Bounds())
+		draw.Draw(result, result.Bounds(), gameImage, result.Bounds().Min, draw.Src)
+		draw.Draw(result, overlayRect, overlayImage, overlayImage.Bounds().Min, draw.Over)
+		gameImage = result
+		applied = true
+	}
+
+	if len(badges) > 0 {
+		result := image.NewRGBA(gameImage.Bounds())
+		draw.Draw(result, result.Bounds(), gameImage, result.Bounds().Min, draw.Src)
+		drawBadges(result, badges)
 		gameImage = result
 		applied = true
 	}
@@ -99,15 +417,81 @@ func ApplyOverlay(game *Game, overlays map[string]image.Image) (applied bool, er
 		return false, nil
 	}
 
+	return true, setEncodedImage(game, gameImage, format)
+}
+
+// Returns a copy of the image with the colors moved towards gray, where
+// saturation 0 is grayscale and 1 keeps the original colors.
+func desaturateImage(img image.Image, saturation float64) *image.RGBA {
+	result := image.NewRGBA(img.Bounds())
+	draw.Draw(result, result.Bounds(), img, img.Bounds().Min, draw.Src)
+	for i := 0; i+3 < len(result.Pix); i += 4 {
+		r, g, b := float64(result.Pix[i]), float64(result.Pix[i+1]), float64(result.Pix[i+2])
+		gray := 0.299*r + 0.587*g + 0.114*b
+		result.Pix[i] = uint8(gray + (r-gray)*saturation + 0.5)
+		result.Pix[i+1] = uint8(gray + (g-gray)*saturation + 0.5)
+		result.Pix[i+2] = uint8(gray + (b-gray)*saturation + 0.5)
+	}
+	return result
+}
+
+// Encodes the image in the same format it was decoded from, so PNGs keep
+// their transparency, and updates the game image and its path to match.
+// Steam only reads JPEG and PNG, so everything else becomes PNG.
+func setEncodedImage(game *Game, img image.Image, format string) error {
 	buf := new(bytes.Buffer)
-	if strings.HasSuffix(game.ImagePath, "jpg") {
-		err = jpeg.Encode(buf, gameImage, &jpeg.Options{90})
-	} else if strings.HasSuffix(game.ImagePath, "png") {
-		err = png.Encode(buf, gameImage)
+	var err error
+	if format == "jpeg" {
+		err = encodeJpeg(buf, img, getImageAsset(game.ImagePath))
+	} else {
+		format = "png"
+		err = png.Encode(buf, img)
 	}
 	if err != nil {
-		return false, err
+		return err
 	}
 	game.ImageBytes = buf.Bytes()
-	return true, nil
+	setImageExtension(game, format)
+	return nil
+}
+
+// Changes the extension of the grid image path to match the format.
+func setImageExtension(game *Game, format string) {
+	ext := ".png"
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	game.ImagePath = strings.TrimSuffix(game.ImagePath, filepath.Ext(game.ImagePath)) + ext
+}
+
+// Makes sure the game image is in a format Steam reads, with a file
+// extension that matches, whatever the source served. Images that are
+// already JPEG or PNG are kept byte for byte.
+func FixImageFormat(game *Game) error {
+	if !game.HasImage() {
+		return nil
+	}
+	reader, err := game.OpenImage()
+	if err != nil {
+		return err
+	}
+	_, format, err := image.DecodeConfig(reader)
+	reader.Close()
+	if err != nil {
+		// Not something we can decode, leave it to Steam.
+		return nil
+	}
+	if format == "jpeg" || format == "png" {
+		setImageExtension(game, format)
+		return nil
+	}
+
+	if err := game.LoadImageBytes(); err != nil {
+		return err
+	}
+	img, format, err := image.Decode(bytes.NewBuffer(game.ImageBytes))
+	if err != nil {
+		return err
+	}
+	return setEncodedImage(game, img, format)
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Loads the per-game image overrides from a file with lines like
+// "228980 = ./art/redist.png" or "440 = https://example.com/tf2.jpg".
+// Other asset types are overridden by adding their name after the id, like
+// "228980 portrait = ./art/redist-portrait.png". Relative paths are relative
+// to the file itself. Returns a map of key to path or URL.
+func LoadOverrides(path string) (map[string]string, error) {
+	entries, err := LoadIni(path)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := make(map[string]string)
+	for _, entry := range entries {
+		source := entry.Value
+		if !isUrl(source) && !filepath.IsAbs(source) {
+			source = filepath.Join(filepath.Dir(path), source)
+		}
+		overrides[strings.ToLower(strings.Join(strings.Fields(entry.Key), " "))] = source
+	}
+	return overrides, nil
+}
+
+func isUrl(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// Loads the image pinned for the game in the overrides, if any, skipping
+// every other source. The override is read again on every run, so later
+// runs never replace it with something else. Returns false if the game has
+// no override for this asset type.
+func ApplyOverride(game *Game, asset *AssetType, overrides map[string]string) (bool, error) {
+	key := game.Id
+	if asset != bannerAsset {
+		key += " " + asset.Name
+	}
+	source, ok := overrides[key]
+	if !ok {
+		return false, nil
+	}
+
+	game.ImageUrl = source
+	// Local files are only read when needed, like downloads.
+	imagePath := source
+	if isUrl(source) {
+		response, err := tryDownload(source)
+		if err != nil {
+			return false, err
+		}
+		if response == nil {
+			return false, errors.New("Override image for " + game.Id + " not found: " + source)
+		}
+		imagePath, err = readDownload(source, response)
+		if err != nil {
+			return false, err
+		}
+	} else if _, err := os.Stat(source); err != nil {
+		return false, err
+	}
+
+	// Keep the grid file extension matching the override format.
+	ext := strings.ToLower(filepath.Ext(source))
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	if ext == ".jpg" || ext == ".png" {
+		game.ImagePath = strings.TrimSuffix(game.ImagePath, filepath.Ext(game.ImagePath)) + ext
+	}
+
+	game.ImageBytes, game.ImageFile = nil, imagePath
+	game.ImageSource = "override"
+	return true, nil
+}
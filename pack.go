@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Name of the file in a grid pack that lists its images.
+const packManifestName = "manifest.json"
+
+// Contents of a grid pack, the zip made by the export command.
+type PackManifest struct {
+	// Format of the pack, in case it ever changes.
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Images  []PackedImage `json:"images"`
+}
+
+// Image of a game in a grid pack.
+type PackedImage struct {
+	AppId string `json:"app_id"`
+	// Name of the game, to find it when the app id is different, like for
+	// non-Steam games.
+	Name  string `json:"name,omitempty"`
+	Asset string `json:"asset"`
+	// Path of the image inside the zip.
+	File string `json:"file"`
+	// True if it's the original, without our overlays.
+	Original bool `json:"original"`
+}
+
+// True to export the originals instead of the images with overlays, given
+// with --originals.
+var exportOriginals = new(bool)
+
+// Zips the current grid images of the given games and asset types, with a
+// manifest, or their originals if originals is true and they have a
+// backup. Returns the number of images exported.
+func ExportPack(user User, games map[string]*Game, assets []*AssetType, path string, originals bool) (int, error) {
+	manifest := PackManifest{Version: 1, Created: time.Now(), Images: make([]PackedImage, 0)}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+
+	for _, game := range SortGames(games, false) {
+		for _, asset := range assets {
+			imagePath, imageBytes := loadCurrentGridImage(user, game, asset)
+			isOriginal := false
+			if backupPath := findBackup(user, game.Id, asset); originals && backupPath != "" {
+				if backupBytes, err := ioutil.ReadFile(backupPath); err == nil {
+					imagePath, imageBytes, isOriginal = backupPath, backupBytes, true
+				}
+			}
+			if imageBytes == nil {
+				continue
+			}
+			name := game.Id + asset.Suffix + filepath.Ext(imagePath)
+			writer, err := archive.Create(name)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := writer.Write(imageBytes); err != nil {
+				return 0, err
+			}
+			manifest.Images = append(manifest.Images, PackedImage{game.Id, game.Name, asset.Name, name, isOriginal})
+		}
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	writer, err := archive.Create(packManifestName)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := writer.Write(manifestBytes); err != nil {
+		return 0, err
+	}
+	if err := archive.Close(); err != nil {
+		return 0, err
+	}
+	return len(manifest.Images), file.Close()
+}
+
+// Returns the flag set of the export command.
+func newExportFlags() *flag.FlagSet {
+	flags := newCommandFlags("export")
+	addAssetFlags(flags)
+	assetsFlag := flags.Lookup("assets")
+	assetsFlag.Value.Set("all")
+	assetsFlag.DefValue = "all"
+	addUserFlags(flags)
+	addGameFlags(flags)
+	flags.BoolVar(exportOriginals, "originals", false, "Export the original images, without the overlays, where there's a backup.")
+	return flags
+}
+
+// Saves the grid images of a user to a zip that the import command can
+// install, to back up or share a library look.
+func runExport(args []string) {
+	flags := newExportFlags()
+	parseCommandFlags(flags, args)
+	if flags.NArg() < 1 {
+		errorAndExit(errors.New(tr("Expected the file to save, like: steamgrid export pack.zip")))
+	}
+	assets, err := GetAssetTypes(*assetNames)
+	if err != nil {
+		errorAndExit(err)
+	}
+
+	_, users := loadUsers(flags.Args()[1:])
+	if len(users) != 1 {
+		errorAndExit(errors.New(tr("A pack has the images of a single user, choose one with --user.")))
+	}
+	user := users[0]
+	games, err := GetGames(user)
+	if err != nil {
+		fmt.Print(tr("Failed to load the public profile of %v, only games found locally are exported: %v\n", user.Name, err.Error()))
+	}
+	FilterGames(games, *gameFilter)
+	nExported, err := ExportPack(user, games, assets, flags.Arg(0), *exportOriginals)
+	if err != nil {
+		errorAndExit(err)
+	}
+	fmt.Print(tr("%v images of %v exported to %v.\n", nExported, user.Name, flags.Arg(0)))
+}
+
+// True to install the images of a pack as they are, given with
+// --no-overlays.
+var importWithoutOverlays = new(bool)
+
+// Opens a grid pack and reads its manifest.
+func OpenPack(path string) (*zip.ReadCloser, *PackManifest, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifestBytes, err := readPackFile(archive, packManifestName)
+	if err != nil {
+		archive.Close()
+		return nil, nil, errors.New(path + " is not a grid pack: " + err.Error())
+	}
+	manifest := &PackManifest{}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		archive.Close()
+		return nil, nil, errors.New("Invalid manifest in " + path + ": " + err.Error())
+	}
+	return archive, manifest, nil
+}
+
+// Returns the contents of a file in a zip.
+func readPackFile(archive *zip.ReadCloser, name string) ([]byte, error) {
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	}
+	return nil, errors.New(name + " not found")
+}
+
+// Returns the game a packed image is for: the one with the same app id, or
+// else the one with the same name, since non-Steam games get a different id
+// on each computer. Returns nil if the user doesn't have it.
+func findPackedGame(games map[string]*Game, packed PackedImage) *Game {
+	if game, ok := games[packed.AppId]; ok {
+		return game
+	}
+	for _, game := range games {
+		if packed.Name != "" && game.Name == packed.Name {
+			return game
+		}
+	}
+	return nil
+}
+
+// Returns the flag set of the import command.
+func newImportFlags() *flag.FlagSet {
+	flags := newCommandFlags("import")
+	addUserFlags(flags)
+	addGameFlags(flags)
+	addProtectedFlags(flags)
+	addOverlayFlags(flags)
+	addVersionFlags(flags)
+	flags.StringVar(overlaysPath, "overlays", filepath.Join(filepath.Dir(os.Args[0]), "overlays by category"), "Folder with the overlays and their overlays.ini.")
+	flags.BoolVar(importWithoutOverlays, "no-overlays", false, "Install the images exactly as they are in the pack, without drawing the overlays on them.")
+	return flags
+}
+
+// Installs the images of a grid pack for every user that has their games,
+// like the set command: each becomes the original of its game and gets the
+// overlays. Given a folder instead, or nothing for the import folder next
+// to the program, it installs the images in it, matched to their games by
+// name.
+func runImport(args []string) {
+	flags := newImportFlags()
+	parseCommandFlags(flags, args)
+	if flags.NArg() < 1 {
+		runImportFolder(filepath.Join(filepath.Dir(os.Args[0]), importFolderName), nil)
+		return
+	}
+	if info, err := os.Stat(flags.Arg(0)); err == nil && info.IsDir() {
+		runImportFolder(flags.Arg(0), flags.Args()[1:])
+		return
+	}
+	archive, manifest, err := OpenPack(flags.Arg(0))
+	if err != nil {
+		errorAndExit(err)
+	}
+	defer archive.Close()
+
+	installationDir, users := loadUsers(flags.Args()[1:])
+	installed := GetInstalledGames(installationDir)
+	overlaySets := make(map[*AssetType]*OverlaySet)
+	if !*importWithoutOverlays {
+		overlaySets = loadOverlaySets(assetTypes)
+	}
+	startJournal(strings.Join(os.Args[1:], " "))
+
+	for _, user := range users {
+		// Without the profile we still have the games found locally.
+		games, _ := GetGames(user)
+		FilterGames(games, *gameFilter)
+		nImported, nMissing := 0, 0
+		for _, packed := range manifest.Images {
+			game := findPackedGame(games, packed)
+			assets, err := GetAssetTypes(packed.Asset)
+			if err != nil || len(assets) != 1 || game == nil {
+				nMissing++
+				continue
+			}
+			asset := assets[0]
+			if isProtected(game) {
+				fmt.Print(tr("Skipped %v, it's protected.\n", game.Name))
+				continue
+			}
+			imageBytes, err := readPackFile(archive, packed.File)
+			if err != nil {
+				errorAndExit(err)
+			}
+
+			game.Installed = game.Installed || installed[game.Id]
+			if !game.Installed && !containsString(game.VirtualTags, "not installed") {
+				game.VirtualTags = append(game.VirtualTags, "not installed")
+			}
+			if _, err := setGridImageBytes(user, game, asset, imageBytes, overlaySets[asset]); err != nil {
+				fmt.Print(tr("Failed to import the %v of %v: %v\n", asset.Name, game.Name, err.Error()))
+				continue
+			}
+			nImported++
+		}
+		fmt.Print(tr("%v images imported for %v, %v are for games they don't have.\n", nImported, user.Name, nMissing))
+	}
+	fmt.Println(tr("Run 'steamgrid undo' to put back the images replaced by the import."))
+}
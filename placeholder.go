@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Size of the grid banners Steam shows.
+const bannerWidth, bannerHeight = 460, 215
+
+// Converts a hue in [0, 360) with fixed saturation and value to a color.
+func hueToColor(hue float64, saturation, value float64) color.RGBA {
+	c := value * saturation
+	h := hue / 60
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := value - c
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
+}
+
+// Fills the image with a vertical gradient between two colors.
+func drawGradient(dst *image.RGBA, top, bottom color.RGBA) {
+	bounds := dst.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		f := float64(y-bounds.Min.Y) / float64(bounds.Dy())
+		c := color.RGBA{
+			uint8(float64(top.R)*(1-f) + float64(bottom.R)*f),
+			uint8(float64(top.G)*(1-f) + float64(bottom.G)*f),
+			uint8(float64(top.B)*(1-f) + float64(bottom.B)*f),
+			255,
+		}
+		draw.Draw(dst, image.Rect(bounds.Min.X, y, bounds.Max.X, y+1), image.NewUniform(c), image.ZP, draw.Src)
+	}
+}
+
+// Draws the game name centered on the image, using the largest font size
+// that fits.
+func drawCenteredName(dst *image.RGBA, name string) {
+	bounds := dst.Bounds()
+	margin := bounds.Dx() / 12
+	maxWidth, maxHeight := bounds.Dx()-2*margin, bounds.Dy()-2*margin
+
+	var lines []string
+	scale := 6
+	for ; scale > 1; scale-- {
+		lines = wrapText(name, maxWidth, scale)
+		fits := len(lines)*(glyphHeight+3)*scale <= maxHeight
+		for _, line := range lines {
+			if w, _ := textSize(line, scale); w > maxWidth {
+				fits = false
+			}
+		}
+		if fits {
+			break
+		}
+	}
+	if scale == 1 {
+		lines = wrapText(name, maxWidth, scale)
+	}
+
+	lineHeight := (glyphHeight + 3) * scale
+	y := bounds.Min.Y + (bounds.Dy()-len(lines)*lineHeight)/2 + scale
+	shadow := color.RGBA{0, 0, 0, 160}
+	for _, line := range lines {
+		w, _ := textSize(line, scale)
+		x := bounds.Min.X + (bounds.Dx()-w)/2
+		drawText(dst, x+scale/2+1, y+scale/2+1, line, scale, shadow)
+		drawText(dst, x, y, line, scale, color.White)
+		y += lineHeight
+	}
+}
+
+// Small capsule image that exists for most store games, even when the
+// banner is missing.
+const capsuleUrlFormat = `https://steamcdn-a.akamaihd.net/steam/apps/%v/capsule_sm_120.jpg`
+
+// Returns the most common color of the image, ignoring near black, near white
+// and gray pixels, which are usually text and borders. Colors are grouped in
+// buckets of 4 bits per channel. Returns false if no pixel qualifies.
+func getDominantColor(img image.Image) (color.RGBA, bool) {
+	counts := make(map[uint16]int)
+	sums := make(map[uint16][3]int)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			high := maxByte(c.R, maxByte(c.G, c.B))
+			low := minByte(c.R, minByte(c.G, c.B))
+			if high < 40 || low > 215 || high-low < 30 {
+				continue
+			}
+			bucket := uint16(c.R>>4)<<8 | uint16(c.G>>4)<<4 | uint16(c.B>>4)
+			counts[bucket]++
+			sum := sums[bucket]
+			sums[bucket] = [3]int{sum[0] + int(c.R), sum[1] + int(c.G), sum[2] + int(c.B)}
+		}
+	}
+
+	best, bestCount := uint16(0), 0
+	for bucket, count := range counts {
+		if count > bestCount || (count == bestCount && bucket < best) {
+			best, bestCount = bucket, count
+		}
+	}
+	if bestCount == 0 {
+		return color.RGBA{}, false
+	}
+	sum := sums[best]
+	return color.RGBA{uint8(sum[0] / bestCount), uint8(sum[1] / bestCount), uint8(sum[2] / bestCount), 255}, true
+}
+
+func maxByte(a, b uint8) uint8 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minByte(a, b uint8) uint8 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Multiplies the color channels, to darken the gradient so white text is
+// readable.
+func scaleColor(c color.RGBA, factor float64) color.RGBA {
+	return color.RGBA{uint8(float64(c.R) * factor), uint8(float64(c.G) * factor), uint8(float64(c.B) * factor), 255}
+}
+
+// Returns gradient colors based on the game's capsule image, so the
+// placeholder matches how the game looks elsewhere in Steam.
+func getThemeColors(game *Game) (top, bottom color.RGBA, ok bool) {
+	if isNonSteamGame(game) {
+		return
+	}
+	capsule, err := downloadDecodedImage(fmt.Sprintf(capsuleUrlFormat, game.Id))
+	if err != nil || capsule == nil {
+		return
+	}
+	dominant, ok := getDominantColor(capsule)
+	if !ok {
+		return
+	}
+	// Keep the brightest channel around the same level as the default
+	// gradient, regardless of how bright the capsule is.
+	factor := 140 / float64(maxByte(dominant.R, maxByte(dominant.G, dominant.B)))
+	return scaleColor(dominant, factor), scaleColor(dominant, factor*0.35), true
+}
+
+// Returns the name of a game as the built-in font can draw it: without
+// trademark signs and accents. Names in other scripts, like Japanese,
+// become the app id.
+func getPlaceholderName(game *Game) string {
+	name := foldToAscii(getSearchName(game.Name))
+	if !canDrawText(name) {
+		return "App " + game.Id
+	}
+	return name
+}
+
+// Returns true if placeholders are generated for the asset type: banners
+// and portraits, which show the name of the game in the library.
+func hasPlaceholders(asset *AssetType) bool {
+	return asset == bannerAsset || asset.Suffix == "p"
+}
+
+// Renders a banner or portrait with the game name, for games without
+// images anywhere. The background is the given template, scaled to fit, or
+// a gradient with the dominant color of the game's capsule image. If there's
+// no capsule either, the color is picked from the name, so each game gets a
+// different but stable one.
+func GeneratePlaceholder(game *Game, asset *AssetType, template image.Image) error {
+	if game.Name == "" {
+		return nil
+	}
+
+	placeholder := image.NewRGBA(image.Rect(0, 0, asset.Width, asset.Height))
+	if template != nil {
+		draw.Draw(placeholder, placeholder.Bounds(), resizeImage(template, asset.Width, asset.Height), image.ZP, draw.Src)
+	} else if top, bottom, ok := getThemeColors(game); ok {
+		drawGradient(placeholder, top, bottom)
+	} else {
+		hue := float64(crc32.ChecksumIEEE([]byte(game.Name)) % 360)
+		drawGradient(placeholder, hueToColor(hue, 0.6, 0.55), hueToColor(hue, 0.7, 0.2))
+	}
+	drawCenteredName(placeholder, getPlaceholderName(game))
+
+	if err := setEncodedImage(game, placeholder, "jpeg"); err != nil {
+		return err
+	}
+	game.ImageSource = "generated"
+	game.ImageUrl = ""
+	return nil
+}
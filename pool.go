@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+)
+
+// Number of games processed at the same time, given with --concurrency.
+var concurrency = new(int)
+
+// Stage of a pipeline: work done for each index, in a number of goroutines
+// at a time.
+type pipelineStage struct {
+	workers int
+	work    func(i int)
+}
+
+// Runs the stages of a pipeline for each index from 0 to n-1: an index goes
+// through the stages in order, while other indexes are in the other
+// stages, like downloading a game while another one gets its overlays. Then
+// calls done for each index in order, in the calling goroutine, once it and
+// every one before it went through every stage. Work only gets a little
+// ahead of done, so a slow one doesn't pile up the results of the rest.
+// Once done returns false no more work is started, but the work already
+// started is still handed to done.
+func runPipeline(n int, stages []pipelineStage, done func(i int) bool) {
+	stop := make(chan bool)
+	// One token per index started and not yet done.
+	nWorkers := 0
+	for _, stage := range stages {
+		nWorkers += stage.workers
+	}
+	pending := make(chan bool, 2*nWorkers)
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := 0; i < n; i++ {
+			select {
+			case pending <- true:
+			case <-stop:
+				return
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			select {
+			case indexes <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// Each stage takes the indexes out of the previous one.
+	input := indexes
+	for _, stage := range stages {
+		output := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < stage.workers; w++ {
+			wg.Add(1)
+			go func(work func(i int), input chan int) {
+				defer wg.Done()
+				for i := range input {
+					work(i)
+					output <- i
+				}
+			}(stage.work, input)
+		}
+		go func() {
+			wg.Wait()
+			close(output)
+		}()
+		input = output
+	}
+
+	// Work finishes in any order, but is handed to done in the order it
+	// started, which is also the order of the indexes.
+	ready := make(map[int]bool)
+	next, stopped := 0, false
+	for i := range input {
+		ready[i] = true
+		for ready[next] {
+			delete(ready, next)
+			if !done(next) && !stopped {
+				close(stop)
+				stopped = true
+			}
+			<-pending
+			next++
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns the sample image the overlays of an asset type are previewed on:
+// "preview banner.png" (or .jpg, "preview portrait.png", ...) in the given
+// dir if there's one, otherwise a gray gradient with the asset name. The
+// sample is scaled to the official size of the asset.
+func loadPreviewSample(dir string, asset *AssetType) image.Image {
+	sample := image.NewRGBA(image.Rect(0, 0, asset.Width, asset.Height))
+	for _, ext := range []string{".png", ".jpg", ".jpeg"} {
+		img, err := loadImage(filepath.Join(dir, "preview "+asset.Name+ext))
+		if err == nil {
+			draw.Draw(sample, sample.Bounds(), fillImage(img, asset.Width, asset.Height), image.ZP, draw.Src)
+			return sample
+		}
+	}
+	drawGradient(sample, color.RGBA{110, 110, 120, 255}, color.RGBA{30, 30, 35, 255})
+	drawCenteredName(sample, "Sample "+asset.Name)
+	return sample
+}
+
+// Writes a PNG image to the given path.
+func savePng(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(file, img)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Draws each overlay of the set on the sample image, and all of them stacked
+// in drawing order, saving the results in outDir as "banner - heart.png",
+// "banner - all overlays.png" and so on. Nothing in the Steam folder is
+// touched, so overlays can be tweaked and previewed again as often as
+// needed. Returns the number of previews written.
+func PreviewOverlays(set *OverlaySet, sample image.Image, outDir string) (int, error) {
+	if len(set.Overlays) == 0 {
+		return 0, nil
+	}
+	if err := os.MkdirAll(outDir, 0777); err != nil {
+		return 0, err
+	}
+
+	overlays := make([]*Overlay, len(set.Overlays))
+	copy(overlays, set.Overlays)
+	sortOverlays(overlays)
+
+	all := image.NewRGBA(sample.Bounds())
+	draw.Draw(all, all.Bounds(), sample, sample.Bounds().Min, draw.Src)
+
+	nWritten := 0
+	for _, overlay := range overlays {
+		overlayImage, overlayRect := overlay.Placement(sample.Bounds(), set.Asset)
+
+		result := image.NewRGBA(sample.Bounds())
+		draw.Draw(result, result.Bounds(), sample, sample.Bounds().Min, draw.Src)
+		draw.Draw(result, overlayRect, overlayImage, overlayImage.Bounds().Min, draw.Over)
+		draw.Draw(all, overlayRect, overlayImage, overlayImage.Bounds().Min, draw.Over)
+
+		name := strings.TrimSuffix(overlay.File, filepath.Ext(overlay.File))
+		err := savePng(filepath.Join(outDir, set.Asset.Name+" - "+name+".png"), result)
+		if err != nil {
+			return nWritten, err
+		}
+		nWritten++
+	}
+
+	err := savePng(filepath.Join(outDir, set.Asset.Name+" - all overlays.png"), all)
+	if err != nil {
+		return nWritten, err
+	}
+	return nWritten + 1, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// How long the progress of an unfinished run is kept for --resume, and the
+// failed images for the retry command.
+const (
+	savedProgressMaxAge = 30 * 24 * time.Hour
+	savedFailuresMaxAge = 90 * 24 * time.Hour
+)
+
+// How often the console prints the overall progress, in games and time.
+const (
+	consoleStatsGames    = 25
+	consoleStatsInterval = time.Minute
+)
+
+// Overall progress of a run, across every user and asset type.
+type RunStats struct {
+	Started time.Time
+	// Zero while the run is going on.
+	Finished    time.Time
+	TotalGames  int
+	DoneGames   int
+	TotalImages int
+	DoneImages  int
+	// Bytes of the images downloaded, not counting the cached ones.
+	Bytes int64
+}
+
+// Returns how long the run took so far, or in total once finished.
+func (stats RunStats) Elapsed() time.Duration {
+	if !stats.Finished.IsZero() {
+		return stats.Finished.Sub(stats.Started)
+	}
+	return time.Since(stats.Started)
+}
+
+// Returns the number of games done per minute so far.
+func (stats RunStats) GamesPerMinute() float64 {
+	minutes := stats.Elapsed().Minutes()
+	if minutes <= 0 {
+		return 0
+	}
+	return float64(stats.DoneGames) / minutes
+}
+
+// Estimates the time left from the images done so far, since games with
+// more asset types take longer. Returns false until there's something to
+// go by.
+func (stats RunStats) Remaining() (time.Duration, bool) {
+	if stats.DoneImages == 0 || stats.TotalImages == 0 || !stats.Finished.IsZero() {
+		return 0, false
+	}
+	left := stats.TotalImages - stats.DoneImages
+	return stats.Elapsed() * time.Duration(left) / time.Duration(stats.DoneImages), true
+}
+
+// Describes the progress, like "12 of 340 games (3%), 5.1 games/minute,
+// 3.2 MB downloaded, about 4m left".
+func (stats RunStats) String() string {
+	percent := 0
+	if stats.TotalImages > 0 {
+		percent = 100 * stats.DoneImages / stats.TotalImages
+	}
+	parts := []string{
+		tr("%v of %v games (%v%%)", stats.DoneGames, stats.TotalGames, percent),
+		tr("%.1f games/minute", stats.GamesPerMinute()),
+		tr("%v downloaded", formatBytes(stats.Bytes)),
+	}
+	if remaining, ok := stats.Remaining(); ok && stats.DoneImages < stats.TotalImages {
+		parts = append(parts, tr("about %v left", formatDuration(remaining)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Formats a size in bytes with the most readable unit.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%v bytes", n)
+}
+
+// Formats a duration to the second, or to the minute past an hour.
+func formatDuration(d time.Duration) string {
+	if d >= time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// Progress of runs without a UI, in the console or in the background: the
+// overall progress is printed every few games, the first Ctrl+C, or the stop
+// signal of a service manager, stops the run after the current game, and a
+// second Ctrl+C quits at once.
+type consoleProgress struct {
+	stopped chan bool
+
+	lastGames int
+	lastPrint time.Time
+}
+
+// Starts listening for the signals to stop.
+func newConsoleProgress() *consoleProgress {
+	progress := &consoleProgress{stopped: make(chan bool)}
+	shutdown := notifyShutdown()
+	go func() {
+		<-shutdown
+		fmt.Println(tr("\nStopping after the current game, press Ctrl+C again to quit now."))
+		close(progress.stopped)
+		<-shutdown
+		os.Exit(exitCancelled)
+	}()
+	return progress
+}
+
+// Does nothing, the output is enough.
+func (progress *consoleProgress) StartUser(name string, nGames int) {}
+
+// Does nothing, the output is enough.
+func (progress *consoleProgress) SetState(game *Game, asset *AssetType, state string) {}
+
+// Prints the overall progress every few games or minutes, when a game is
+// done.
+func (progress *consoleProgress) SetStats(stats RunStats) {
+	if stats.DoneGames == 0 {
+		progress.lastGames, progress.lastPrint = 0, stats.Started
+		return
+	}
+	if stats.DoneGames == progress.lastGames || stats.DoneGames == stats.TotalGames {
+		return
+	}
+	if stats.DoneGames-progress.lastGames >= consoleStatsGames || time.Since(progress.lastPrint) >= consoleStatsInterval {
+		fmt.Println(tr("Progress: %v", stats))
+		progress.lastGames, progress.lastPrint = stats.DoneGames, time.Now()
+	}
+}
+
+// Returns false once asked to stop.
+func (progress *consoleProgress) FinishGame() bool {
+	select {
+	case <-progress.stopped:
+		return false
+	default:
+		return true
+	}
+}
+
+// Does nothing, the output is enough.
+func (progress *consoleProgress) Stop() {}
+
+// Games already done by an unfinished run of a user, saved after each game
+// so a later run can skip them with --resume, even if the program was
+// killed.
+type SavedProgress struct {
+	Done  []string
+	Total int
+}
+
+// Loads the progress of the last run of a user, or nil if it finished.
+func loadSavedProgress(user User) *SavedProgress {
+	progress := &SavedProgress{}
+	if !readCache("progress", user.SteamId32, savedProgressMaxAge, progress) {
+		return nil
+	}
+	return progress
+}
+
+// Saves the progress of the current run of a user.
+func saveProgress(user User, progress *SavedProgress) error {
+	return writeCache("progress", user.SteamId32, progress)
+}
+
+// Forgets the progress of a user, once the run is done with every game.
+func clearSavedProgress(user User) error {
+	return removeCache("progress", user.SteamId32)
+}
+
+// Images of a user that failed or were not found, by game id, with the
+// names of their asset types. Updated after each game, so the retry
+// command can do only those.
+type SavedFailures struct {
+	Images map[string][]string
+}
+
+// Loads the failed images of a user, empty if there are none.
+func loadSavedFailures(user User) *SavedFailures {
+	failures := &SavedFailures{}
+	if !readCache("failures", user.SteamId32, savedFailuresMaxAge, failures) || failures.Images == nil {
+		failures.Images = make(map[string][]string)
+	}
+	return failures
+}
+
+// Replaces the failures of a game with the assets that failed now, keeping
+// the ones of asset types that were not processed.
+func (failures *SavedFailures) Update(gameId string, processed []*AssetType, failed []string) {
+	kept := make([]string, 0)
+	for _, name := range failures.Images[gameId] {
+		wasProcessed := false
+		for _, asset := range processed {
+			wasProcessed = wasProcessed || asset.Name == name
+		}
+		if !wasProcessed {
+			kept = append(kept, name)
+		}
+	}
+	kept = append(kept, failed...)
+	if len(kept) == 0 {
+		delete(failures.Images, gameId)
+	} else {
+		failures.Images[gameId] = kept
+	}
+}
+
+// Returns true if the image of the game and asset type failed.
+func (failures *SavedFailures) Has(gameId string, asset *AssetType) bool {
+	return containsString(failures.Images[gameId], asset.Name)
+}
+
+// Saves the failed images of a user.
+func saveFailures(user User, failures *SavedFailures) error {
+	return writeCache("failures", user.SteamId32, failures)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+)
+
+// Comma separated app ids or name globs of the games steamgrid must never
+// touch, given with --protected, usually in the config file.
+var protectedGames = new(string)
+
+// Registers the option listing the protected games.
+func addProtectedFlags(flags *flag.FlagSet) {
+	flags.StringVar(protectedGames, "protected", "", "Comma separated app ids or name globs of games whose images are curated by hand, which are never downloaded, overlaid, backed up, restored or cleaned.")
+}
+
+// Returns true if the game is in the protected list.
+func isProtected(game *Game) bool {
+	return matchesAnyIdOrName(game, *protectedGames)
+}
+
+// Returns the ids of the protected games among the given ones, plus the app
+// ids in the list, so games that left the library stay protected too.
+func getProtectedIds(games map[string]*Game) map[string]bool {
+	protected := make(map[string]bool)
+	for _, pattern := range splitList(*protectedGames) {
+		if appIdPattern.MatchString(pattern) {
+			protected[pattern] = true
+		}
+	}
+	for id, game := range games {
+		if isProtected(game) {
+			protected[id] = true
+		}
+	}
+	return protected
+}
+
+// Removes the protected games, so nothing is done with them. Returns how
+// many were removed.
+func RemoveProtectedGames(games map[string]*Game) int {
+	nRemoved := 0
+	for id, game := range games {
+		if isProtected(game) {
+			delete(games, id)
+			nRemoved++
+		}
+	}
+	return nRemoved
+}
+
+// Returns an error if the game is protected, for commands that change a
+// single game.
+func checkNotProtected(game *Game) error {
+	if isProtected(game) {
+		return errors.New(tr("%v (id %v) is protected, remove it from --protected to change its images.", game.Name, game.Id))
+	}
+	return nil
+}
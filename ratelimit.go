@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How many times a rate limited request is tried, and the longest wait
+// between tries, whatever the server asks.
+const (
+	rateLimitAttempts = 5
+	rateLimitMaxWait  = 5 * time.Minute
+)
+
+// Most connections open to the same host at a time, also kept open between
+// requests to reuse them. Image CDNs block addresses that open many more,
+// so extra games processed at the same time wait for a free connection.
+const maxConnsPerHost = 6
+
+// How much of an unread response body is read to reuse its connection,
+// instead of closing it and opening a new one.
+const maxDrainedBytes = 64 << 10
+
+// Sets up the shared HTTP transport: keep-alive with a few connections per
+// host, and a timeout for servers that never answer.
+func configureHttpTransport() {
+	transport := http.DefaultTransport.(*http.Transport)
+	transport.ResponseHeaderTimeout = 10 * time.Second
+	transport.MaxConnsPerHost = maxConnsPerHost
+	transport.MaxIdleConnsPerHost = maxConnsPerHost
+	transport.IdleConnTimeout = 90 * time.Second
+}
+
+// Closes a response, reading what's left of a short body first so its
+// connection goes back to the pool.
+func closeResponse(response *http.Response) {
+	io.CopyN(ioutil.Discard, response.Body, maxDrainedBytes)
+	response.Body.Close()
+}
+
+// Hosts that answered 429 Too Many Requests, and until when they asked us
+// to wait. Every request to them waits, not only the one that was limited.
+var rateLimits = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: make(map[string]time.Time)}
+
+// Returns how long the server asked to wait with the Retry-After header, in
+// seconds or as a date, or else a wait that doubles with each attempt.
+func getRetryAfter(response *http.Response, attempt int) time.Duration {
+	wait := time.Duration(10<<uint(attempt)) * time.Second
+	if header := response.Header.Get("Retry-After"); header != "" {
+		if seconds, err := strconv.Atoi(header); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(header); err == nil {
+			wait = time.Until(date)
+		}
+	}
+	if wait < time.Second {
+		wait = time.Second
+	} else if wait > rateLimitMaxWait {
+		wait = rateLimitMaxWait
+	}
+	return wait
+}
+
+// Waits until the host of a request may be asked again.
+func waitForRateLimit(host string) {
+	rateLimits.Lock()
+	until := rateLimits.until[host]
+	rateLimits.Unlock()
+	if wait := time.Until(until); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Sends a request, and if the server answers 429 Too Many Requests, or 503
+// with a Retry-After, waits as long as it asks and sends it again, a few
+// times. Requests with a body are sent again with a fresh copy of it, so it
+// must be made by http.NewRequest from a bytes.Buffer, bytes.Reader or
+// strings.Reader. Returns the last response.
+func doRequest(request *http.Request) (*http.Response, error) {
+	host := request.URL.Host
+	for attempt := 0; ; attempt++ {
+		waitForRateLimit(host)
+		if attempt > 0 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request.Body = body
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		limited := response.StatusCode == http.StatusTooManyRequests ||
+			(response.StatusCode == http.StatusServiceUnavailable && response.Header.Get("Retry-After") != "")
+		if !limited || attempt+1 >= rateLimitAttempts {
+			return response, nil
+		}
+		closeResponse(response)
+
+		wait := getRetryAfter(response, attempt)
+		rateLimits.Lock()
+		if until := time.Now().Add(wait); until.After(rateLimits.until[host]) {
+			rateLimits.until[host] = until
+		}
+		rateLimits.Unlock()
+		logEvent(LogWarning, "rate_limited", LogFields{"host": host, "wait": wait.Seconds()}, "Rate limited by %v, waiting %v", host, wait)
+		// Games are processed at the same time with their output buffered, or
+		// on the terminal UI, so this can't go straight to the console.
+		logf(LogVerbose, "%v asked to slow down, waiting %v", host, wait.Round(time.Second))
+	}
+}
+
+// Fetches a URL like http.Get, waiting and trying again while the server
+// is rate limiting us.
+func httpGet(url string) (*http.Response, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return doRequest(request)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File to write the report of the run to, given with --report.
+var reportPath = new(string)
+
+// CSV file to write the images not found to, given with --missing-csv.
+var missingCsvPath = new(string)
+
+// What happened to each image of a run.
+const (
+	ImageInstalled     = "installed"
+	ImageNotFound      = "not found"
+	ImageOverlayFailed = "overlay failed"
+	ImageWriteFailed   = "write failed"
+	// Left alone because it was changed by hand since the last run.
+	ImageChangedByHand = "changed by hand"
+	// Skipped because nothing that goes into it changed since the last run.
+	ImageUnchanged = "unchanged"
+	// Any other error, like a download that timed out, which skipped the
+	// image but not the rest of the run.
+	ImageFailed = "failed"
+)
+
+// Result of one image of a game in a run.
+type ImageResult struct {
+	User   string `json:"user"`
+	GameId string `json:"game_id"`
+	Game   string `json:"game"`
+	Asset  string `json:"asset"`
+	Status string `json:"status"`
+	// Where the image came from, like "download", "search" or "backup".
+	Source   string `json:"source,omitempty"`
+	Overlaid bool   `json:"overlaid"`
+	// Why it failed. Installed images can have one too, when only the
+	// conversion to the right format failed.
+	Error string `json:"error,omitempty"`
+	// Where images not found were looked for, like "steam" and "search".
+	Tried []string `json:"tried,omitempty"`
+	// URL or file the image came from.
+	Url string `json:"url,omitempty"`
+}
+
+// Error of an image of a game, with the game, asset type and the URL or
+// file of the image, so the message says what failed.
+type GameError struct {
+	// What was being done, like "download" or "overlay".
+	Step   string
+	GameId string
+	Game   string
+	Asset  string
+	Url    string
+	Err    error
+}
+
+// Wraps an error of an image with what it was about. Errors already
+// wrapped are returned as they are.
+func newGameError(step string, game *Game, asset *AssetType, err error) error {
+	if _, ok := err.(*GameError); ok || err == nil {
+		return err
+	}
+	return &GameError{step, game.Id, game.Name, asset.Name, game.ImageUrl, err}
+}
+
+func (e *GameError) Error() string {
+	name := e.Game
+	if name == "" {
+		name = "the game"
+	}
+	message := fmt.Sprintf("failed to %v the %v of %v (id %v)", e.Step, e.Asset, name, e.GameId)
+	if e.Url != "" {
+		message += " from " + e.Url
+	}
+	return message + ": " + e.Err.Error()
+}
+
+func (e *GameError) Unwrap() error {
+	return e.Err
+}
+
+// Results of a run, printed at the end and saved with --report.
+type RunReport struct {
+	Summary         string    `json:"summary"`
+	Started         time.Time `json:"started"`
+	Finished        time.Time `json:"finished"`
+	Cancelled       bool      `json:"cancelled"`
+	DryRun          bool      `json:"dry_run"`
+	Downloaded      int       `json:"downloaded"`
+	OverlaysApplied int       `json:"overlays_applied"`
+	Collages        int       `json:"collages"`
+	Generated       int       `json:"generated"`
+	Bytes           int64     `json:"bytes_downloaded"`
+	// Users whose profile couldn't be loaded, with the error.
+	ProfileErrors []string      `json:"profile_errors"`
+	Images        []ImageResult `json:"images"`
+}
+
+// Adds the result of an image.
+func (report *RunReport) Add(user User, game *Game, asset *AssetType, status string, overlaid bool, err error) {
+	result := ImageResult{User: user.Name, GameId: game.Id, Game: game.Name, Asset: asset.Name, Status: status, Overlaid: overlaid}
+	if status != ImageNotFound {
+		result.Source, result.Url = game.ImageSource, game.ImageUrl
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	report.Images = append(report.Images, result)
+}
+
+// Adds an image that wasn't found, and where it was looked for.
+func (report *RunReport) AddNotFound(user User, game *Game, asset *AssetType, tried []string) {
+	report.Add(user, game, asset, ImageNotFound, false, nil)
+	report.Images[len(report.Images)-1].Tried = tried
+}
+
+// Adds the results and counts of another report, like the one of a single
+// game.
+func (report *RunReport) Merge(other *RunReport) {
+	report.Downloaded += other.Downloaded
+	report.OverlaysApplied += other.OverlaysApplied
+	report.Collages += other.Collages
+	report.Generated += other.Generated
+	report.Bytes += other.Bytes
+	report.Images = append(report.Images, other.Images...)
+}
+
+// Returns the results with the given status.
+func (report *RunReport) WithStatus(status string) []ImageResult {
+	results := make([]ImageResult, 0)
+	for _, result := range report.Images {
+		if result.Status == status {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Returns the images that had errors, of any kind.
+func (report *RunReport) Failed() []ImageResult {
+	results := make([]ImageResult, 0)
+	for _, result := range report.Images {
+		switch result.Status {
+		case ImageOverlayFailed, ImageWriteFailed, ImageFailed:
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Returns the installed images that were found with a search.
+func (report *RunReport) Searched() []ImageResult {
+	results := make([]ImageResult, 0)
+	for _, result := range report.WithStatus(ImageInstalled) {
+		if result.Source == "search" {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Returns the exit code for the results: a cancelled run or unreachable
+// profiles first, since they explain missing images, then images that
+// failed with errors, then missing images.
+func (report *RunReport) ExitCode() int {
+	switch {
+	case len(report.ProfileErrors) > 0:
+		return exitProfileUnreachable
+	case report.Cancelled:
+		return exitCancelled
+	case len(report.Failed()) > 0:
+		return exitImageErrors
+	case len(report.WithStatus(ImageNotFound)) > 0:
+		return exitMissingImages
+	}
+	return exitOk
+}
+
+// Describes the results for people: the totals and the images that need a
+// look.
+func (report *RunReport) HumanSummary() string {
+	var summary strings.Builder
+	if report.Cancelled {
+		summary.WriteString(tr("Cancelled, the remaining games were left as they were. Run again with --resume to continue from here.\n\n"))
+	}
+	summary.WriteString(tr("%v images downloaded and %v overlays applied.\n", report.Downloaded, report.OverlaysApplied))
+	if unchanged := len(report.WithStatus(ImageUnchanged)); unchanged > 0 {
+		summary.WriteString(tr("%v images didn't change since the last run and were skipped, add --full to do them again.\n", unchanged))
+	}
+	if len(report.ProfileErrors) > 0 {
+		summary.WriteString(tr("\nThese profiles could not be loaded, so only the games found locally were done:\n"))
+		for _, message := range report.ProfileErrors {
+			fmt.Fprintf(&summary, "- %v\n", message)
+		}
+	}
+	if report.Collages >= 1 {
+		summary.WriteString(tr("\n%v games had no images and got a collage of their screenshots.\n", report.Collages))
+	}
+	if report.Generated >= 1 {
+		summary.WriteString(tr("\n%v games had no images anywhere and got a generated banner with their name.\n", report.Generated))
+	}
+
+	if searched := report.Searched(); len(searched) >= 1 {
+		summary.WriteString(tr("\n%v images were found with a Google search and may not be accurate:\n", len(searched)))
+		for _, result := range searched {
+			summary.WriteString(tr("* %v (steam id %v)\n", result.Game, result.GameId))
+		}
+	}
+
+	if notFound := report.WithStatus(ImageNotFound); len(notFound) >= 1 {
+		summary.WriteString(tr("\n%v images could not be found anywhere:\n", len(notFound)))
+		for _, result := range notFound {
+			if result.Asset == bannerAsset.Name {
+				fmt.Fprintf(&summary, "- %v (id %v)\n", result.Game, result.GameId)
+			} else {
+				fmt.Fprintf(&summary, "- %v (id %v, %v)\n", result.Game, result.GameId, result.Asset)
+			}
+		}
+	}
+
+	if changed := report.WithStatus(ImageChangedByHand); len(changed) >= 1 {
+		summary.WriteString(tr("\n%v images were changed by hand since the last run, so they were left alone (--custom-images adopt draws the overlays on them):\n", len(changed)))
+		for _, result := range changed {
+			fmt.Fprintf(&summary, "- %v (id %v, %v)\n", result.Game, result.GameId, result.Asset)
+		}
+	}
+
+	if failed := report.WithStatus(ImageOverlayFailed); len(failed) >= 1 {
+		summary.WriteString(tr("\n%v images were found but had errors and could not be overlaid:\n", len(failed)))
+		for _, result := range failed {
+			fmt.Fprintf(&summary, "- %v (id %v) (%v)\n", result.Game, result.GameId, result.Error)
+		}
+	}
+
+	if failed := report.WithStatus(ImageWriteFailed); len(failed) >= 1 {
+		summary.WriteString(tr("\n%v images could not be saved:\n", len(failed)))
+		for _, result := range failed {
+			fmt.Fprintf(&summary, "- %v (id %v, %v) (%v)\n", result.Game, result.GameId, result.Asset, result.Error)
+		}
+	}
+
+	if failed := report.WithStatus(ImageFailed); len(failed) >= 1 {
+		summary.WriteString(tr("\n%v images failed with errors and were skipped, run 'steamgrid retry' to try them again:\n", len(failed)))
+		for _, result := range failed {
+			fmt.Fprintf(&summary, "- %v (id %v, %v) (%v)\n", result.Game, result.GameId, result.Asset, result.Error)
+		}
+	}
+	return summary.String()
+}
+
+// Saves the report as CSV if the path ends in .csv, with one line per
+// image, or as JSON with the summary and the totals otherwise.
+func (report *RunReport) Write(path string) error {
+	report.Summary = report.HumanSummary()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		writer := csv.NewWriter(file)
+		writer.Write([]string{"user", "game_id", "game", "asset", "status", "source", "overlaid", "error", "url"})
+		for _, result := range report.Images {
+			writer.Write([]string{result.User, result.GameId, result.Game, result.Asset, result.Status, result.Source, strconv.FormatBool(result.Overlaid), result.Error, result.Url})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, reportBytes, 0666)
+}
+
+// Saves the images not found as CSV, with the sources already tried, to
+// look for them by hand or ask for them in the community databases.
+func (report *RunReport) WriteMissing(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"user", "app_id", "name", "asset", "sources_tried"})
+	for _, result := range report.WithStatus(ImageNotFound) {
+		writer.Write([]string{result.User, result.GameId, result.Game, result.Asset, strings.Join(result.Tried, ", ")})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Returns a copy of the image scaled to the given size, with bilinear
+// filtering.
+func resizeImage(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width == 0 || height == 0 || bounds.Empty() {
+		return dst
+	}
+
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	xRatio := float64(srcWidth) / float64(width)
+	yRatio := float64(srcHeight) / float64(height)
+	for y := 0; y < height; y++ {
+		// Sample at pixel centers so both edges are treated the same.
+		sy := (float64(y)+0.5)*yRatio - 0.5
+		y0, fy := clampSample(sy, srcHeight)
+		y1 := y0 + 1
+		if y1 >= srcHeight {
+			y1 = srcHeight - 1
+		}
+		for x := 0; x < width; x++ {
+			sx := (float64(x)+0.5)*xRatio - 0.5
+			x0, fx := clampSample(sx, srcWidth)
+			x1 := x0 + 1
+			if x1 >= srcWidth {
+				x1 = srcWidth - 1
+			}
+
+			i00 := src.PixOffset(x0, y0)
+			i10 := src.PixOffset(x1, y0)
+			i01 := src.PixOffset(x0, y1)
+			i11 := src.PixOffset(x1, y1)
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				top := float64(src.Pix[i00+c])*(1-fx) + float64(src.Pix[i10+c])*fx
+				bottom := float64(src.Pix[i01+c])*(1-fx) + float64(src.Pix[i11+c])*fx
+				dst.Pix[o+c] = uint8(top*(1-fy) + bottom*fy + 0.5)
+			}
+		}
+	}
+	return dst
+}
+
+// Splits a sample coordinate into a pixel index inside [0, size) and the
+// fraction towards the next pixel.
+func clampSample(s float64, size int) (int, float64) {
+	if s <= 0 {
+		return 0, 0
+	}
+	i := int(s)
+	if i >= size-1 {
+		return size - 1, 0
+	}
+	return i, s - float64(i)
+}
+
+// Scales the image to cover the given size, keeping the aspect ratio, and
+// crops whatever sticks out equally on both sides.
+func fillImage(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	scaledWidth, scaledHeight := width, height
+	if bounds.Dx()*height > bounds.Dy()*width {
+		scaledWidth = bounds.Dx() * height / bounds.Dy()
+	} else {
+		scaledHeight = bounds.Dy() * width / bounds.Dx()
+	}
+	scaled := resizeImage(img, scaledWidth, scaledHeight)
+
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	offset := image.Pt((scaledWidth-width)/2, (scaledHeight-height)/2)
+	draw.Draw(result, result.Bounds(), scaled, offset, draw.Src)
+	return result
+}
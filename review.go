@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"strings"
+)
+
+// Shared reader for answers typed in the console.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// Asks a question in the console until the answer is one of the choices, or
+// its first letter, in English or translated. An empty answer picks the
+// first choice.
+func askChoice(question string, choices ...string) string {
+	options := make([]string, len(choices))
+	for i, choice := range choices {
+		label := []rune(tr(choice))
+		options[i] = "[" + string(label[:1]) + "]" + string(label[1:])
+	}
+	for {
+		fmt.Printf("%v %v: ", question, strings.Join(options, "/"))
+		answer, err := stdinReader.ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer == "" || err != nil {
+			return choices[0]
+		}
+		for _, choice := range choices {
+			for _, word := range []string{strings.ToLower(tr(choice)), choice} {
+				if answer == word || answer == string([]rune(word)[:1]) {
+					return choice
+				}
+			}
+		}
+	}
+}
+
+// Returns true if the image just downloaded for a game should be reviewed
+// before using it.
+func shouldReview(game *Game) bool {
+	return *reviewAll || (*reviewSearch && game.ImageSource == "search")
+}
+
+// Shows the image found for a game, in terminals that can, and where it came
+// from.
+func showReviewImage(game *Game, candidates *ImageCandidates) {
+	fmt.Println()
+	if protocol := detectImageProtocol(); protocol != "" {
+		err := game.LoadImageBytes()
+		var img image.Image
+		if err == nil {
+			img, _, err = image.Decode(bytes.NewBuffer(game.ImageBytes))
+		}
+		if err == nil {
+			err = showTerminalImage(os.Stdout, img, protocol)
+		}
+		if err != nil {
+			fmt.Print(tr("(failed to show the image: %v)\n", err.Error()))
+		}
+	} else {
+		fmt.Println(tr("(this terminal can't show images, try --image-protocol sixel)"))
+	}
+	if game.ImageSource == "cache" {
+		fmt.Println(tr("Found in the wishlist cache"))
+	} else {
+		fmt.Print(tr("Found from %v: %v\n", game.ImageSource, candidates.Url))
+	}
+}
+
+// Asks whether to use the image found for a game, downloading the next
+// candidate for as long as the user asks for it. Rejected images leave the
+// game without image, like games where nothing was found.
+func ReviewImage(game *Game, asset *AssetType, candidates *ImageCandidates) error {
+	for game.HasImage() {
+		showReviewImage(game, candidates)
+		answer := askChoice(tr("Use this %v for %v?", asset.Name, game.Name), "accept", "reject", "next")
+		if answer == "accept" {
+			return nil
+		}
+
+		game.ClearImage()
+		game.ImageSource = ""
+		if answer == "next" {
+			found, err := candidates.Download()
+			if err != nil {
+				return err
+			}
+			if !found {
+				fmt.Println(tr("No more images to choose from."))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+)
+
+// Review summary of a game, without any of the reviews themselves.
+const reviewsUrlFormat = `https://store.steampowered.com/appreviews/%v?json=1&language=all&purchase_type=all&num_per_page=0`
+
+// Summary of the user reviews of a game in the store.
+type ReviewSummary struct {
+	// Like "Very Positive" or "Mixed".
+	Description string
+	Positive    int
+	Total       int
+}
+
+// Returns the review summary of the game, cached like the store metadata.
+func GetReviewSummary(game *Game) (*ReviewSummary, error) {
+	summary := &ReviewSummary{}
+	if isNonSteamGame(game) {
+		return summary, nil
+	}
+	if readCache("reviews", game.Id, storeCacheDuration, summary) {
+		return summary, nil
+	}
+
+	var reviews struct {
+		QuerySummary struct {
+			ReviewScoreDesc string `json:"review_score_desc"`
+			TotalPositive   int    `json:"total_positive"`
+			TotalReviews    int    `json:"total_reviews"`
+		} `json:"query_summary"`
+	}
+	if _, err := getJson(fmt.Sprintf(reviewsUrlFormat, game.Id), &reviews); err != nil {
+		return nil, err
+	}
+	summary.Description = reviews.QuerySummary.ReviewScoreDesc
+	summary.Positive = reviews.QuerySummary.TotalPositive
+	summary.Total = reviews.QuerySummary.TotalReviews
+	return summary, writeCache("reviews", game.Id, summary)
+}
+
+// Returns a badge with the percentage of positive reviews, colored like the
+// store does, or false if the game has no reviews.
+func reviewBadge(summary *ReviewSummary) (Badge, bool) {
+	if summary.Total == 0 {
+		return Badge{}, false
+	}
+	percentage := summary.Positive * 100 / summary.Total
+	badge := Badge{Text: strconv.Itoa(percentage) + "%", Color: color.RGBA{40, 110, 160, 220}, Anchor: "top-right"}
+	if percentage < 40 {
+		badge.Color = color.RGBA{150, 50, 40, 220}
+	} else if percentage < 70 {
+		badge.Color = color.RGBA{150, 120, 40, 220}
+	}
+	return badge, true
+}
@@ -0,0 +1,901 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of output lines kept for the log on the dashboard.
+const webLogLines = 200
+
+// Address the web UI listens on, given with --listen.
+var listenAddress = new(string)
+
+// Local web server with a dashboard of the library, an artwork picker for
+// each game and buttons to start, pause and cancel download runs. Runs report their
+// progress to it, like to the terminal UI.
+type WebServer struct {
+	mutex     sync.Mutex
+	steamArgs []string
+	stdout    *os.File
+	templates *template.Template
+	// Options of the command, changed live by the settings page.
+	flags *flag.FlagSet
+	// Closed to stop the server, from the quit button of the gui command.
+	quit     chan bool
+	quitOnce sync.Once
+	isApp    bool
+
+	// Library shown on the dashboard, loaded at start and after each run.
+	users       []User
+	games       map[string]map[string]*Game
+	overlaySets map[*AssetType]*OverlaySet
+	// Images found by the last search for each game, which can be picked
+	// too.
+	searched map[string][]string
+
+	running   bool
+	cancelled bool
+	runUser   string
+	total     int
+	done      int
+	states    map[string]string
+	stats     RunStats
+	log       []string
+
+	// A paused run waits on resumed before starting the next game.
+	paused  bool
+	resumed *sync.Cond
+}
+
+// Grid image requested by the thumbnails: the game id and asset suffix,
+// without the extension.
+var webGridNamePattern = regexp.MustCompile(`^\d+(p|_hero|_logo)?$`)
+
+// Returns the flag set of the serve command.
+func newServeFlags() *flag.FlagSet {
+	flags := newCommandFlags("serve")
+	addDownloadFlags(flags)
+	flags.StringVar(listenAddress, "listen", "127.0.0.1:8080", "Address to serve the web UI on. Use ':8080' to reach it from other devices, since there's no password.")
+	addScheduleFlags(flags)
+	return flags
+}
+
+// Starts the web UI and serves it until the program is closed.
+func runServe(args []string) {
+	flags := newServeFlags()
+	parseCommandFlags(flags, args)
+	// Nobody is at the console to press enter or review images.
+	*headless = true
+	*terminalUI = false
+	checkDownloadFlags()
+	checkForUpdates()
+
+	scheduled, err := startSchedule()
+	if err != nil {
+		errorAndExit(err)
+	}
+	listener, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		errorAndExit(err)
+	}
+
+	server := newWebServer(flags)
+	go func() {
+		for range scheduled {
+			server.startRun("")
+		}
+	}()
+	fmt.Print(tr("Serving the web UI at http://%v\n", *listenAddress))
+	server.serve(listener)
+}
+
+// Returns a web server for the library of the Steam folder in the
+// arguments, capturing the output for its log.
+func newWebServer(flags *flag.FlagSet) *WebServer {
+	server := &WebServer{steamArgs: flags.Args(), flags: flags, states: make(map[string]string), searched: make(map[string][]string), quit: make(chan bool)}
+	server.resumed = sync.NewCond(&server.mutex)
+	server.templates = template.Must(template.New("web").Funcs(template.FuncMap{
+		"isApp": func() bool { return server.isApp },
+	}).Parse(webTemplates))
+	server.captureOutput()
+	server.loadLibrary()
+	return server
+}
+
+// Serves the web UI until the program is stopped or the quit button is
+// pressed.
+func (server *WebServer) serve(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", server.handleDashboard)
+	mux.HandleFunc("/grid/", server.handleGridImage)
+	mux.HandleFunc("/game/", server.handleGame)
+	mux.HandleFunc("/run", server.handleRun)
+	mux.HandleFunc("/pause", server.handlePause)
+	mux.HandleFunc("/cancel", server.handleCancel)
+	mux.HandleFunc("/preview/", server.handlePreview)
+	mux.HandleFunc("/settings", server.handleSettings)
+	mux.HandleFunc("/quit", server.handleQuit)
+	mux.HandleFunc("/api/games", server.handleApiGames)
+	mux.HandleFunc("/api/status", server.handleApiStatus)
+	mux.HandleFunc("/api/refresh", server.handleApiRefresh)
+	mux.HandleFunc("/api/pause", server.handleApiPause)
+	mux.HandleFunc("/api/resume", server.handleApiResume)
+	mux.HandleFunc("/api/cancel", server.handleApiCancel)
+
+	// Stopping from the console or the service manager lets a run finish
+	// the game it's processing.
+	httpServer := &http.Server{Handler: guardWebRequests(mux)}
+	shutdown := notifyShutdown()
+	go func() {
+		select {
+		case <-shutdown:
+		case <-server.quit:
+		}
+		fmt.Println(tr("Stopping..."))
+		server.cancel()
+		for server.getStatus().Running {
+			time.Sleep(100 * time.Millisecond)
+		}
+		httpServer.Shutdown(context.Background())
+	}()
+
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		errorAndExit(err)
+	}
+}
+
+// Returns true if a web page may be reached with the host of a request:
+// an IP address, localhost, the name of this computer, or the address
+// given with --listen. Other names can only come from a DNS rebinding page
+// that points its own name to us, to read the library through it.
+func isAllowedWebHost(host string, listenHost string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if net.ParseIP(host) != nil || host == "localhost" || host == strings.ToLower(listenHost) {
+		return true
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		hostname = strings.ToLower(hostname)
+		return host == hostname || host == hostname+".local"
+	}
+	return false
+}
+
+// Returns true if a request comes from another site, like a form of any page
+// the user visits posting to us. Browsers say so in Sec-Fetch-Site, or
+// older ones with the Origin. Scripts send neither, and are let through.
+func isCrossSiteRequest(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	return err != nil || !strings.EqualFold(parsed.Host, r.Host)
+}
+
+// Rejects the requests that don't come from our own pages or from scripts
+// on this computer or the network: the ones with an unknown host name, and
+// the ones from other sites, except following a link to the dashboard.
+// Otherwise any page could post a form that installs its own image, or
+// have us download anything through the previews.
+func guardWebRequests(next http.Handler) http.Handler {
+	listenHost, _, _ := net.SplitHostPort(*listenAddress)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAllowedWebHost(r.Host, listenHost) {
+			logf(LogWarning, "Rejected a request to %v for the host %v", r.URL.Path, r.Host)
+			http.Error(w, "Unknown host", http.StatusForbidden)
+			return
+		}
+		isLink := r.Method == "GET" && r.URL.Path == "/" && r.Header.Get("Sec-Fetch-Mode") == "navigate"
+		if !isLink && isCrossSiteRequest(r) {
+			logf(LogWarning, "Rejected a %v to %v from %v", r.Method, r.URL.Path, r.Header.Get("Origin"))
+			http.Error(w, "Cross-site requests are not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Sends everything printed to the log on the dashboard, and still to the
+// console.
+func (server *WebServer) captureOutput() {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		errorAndExit(err)
+	}
+	server.stdout = os.Stdout
+	os.Stdout = writer
+	go func() {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			fmt.Fprintln(server.stdout, scanner.Text())
+			server.mutex.Lock()
+			server.log = append(server.log, scanner.Text())
+			if len(server.log) > webLogLines {
+				server.log = server.log[len(server.log)-webLogLines:]
+			}
+			server.mutex.Unlock()
+		}
+	}()
+}
+
+// Loads the users, their games and the overlays.
+func (server *WebServer) loadLibrary() {
+	installationDir, users := loadUsers(server.steamArgs)
+	installed := GetInstalledGames(installationDir)
+	allGames := make(map[string]map[string]*Game)
+	for _, user := range users {
+		// Without the profile we still have the games found locally.
+		games, _ := GetGames(user)
+		for id, game := range games {
+			if game.Hidden && !*includeHidden {
+				delete(games, id)
+				continue
+			}
+			game.Installed = game.Installed || installed[id]
+			if !game.Installed {
+				game.VirtualTags = append(game.VirtualTags, "not installed")
+			}
+		}
+		allGames[user.SteamId32] = games
+	}
+	overlaySets := loadOverlaySets(assetTypes)
+
+	server.mutex.Lock()
+	server.users, server.games, server.overlaySets = users, allGames, overlaySets
+	server.mutex.Unlock()
+}
+
+// Starts the list of games of a new user in the current run.
+func (server *WebServer) StartUser(name string, nGames int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.runUser, server.total, server.done = name, nGames, 0
+	server.states = make(map[string]string)
+}
+
+// Shows the state of an image on its card.
+func (server *WebServer) SetState(game *Game, asset *AssetType, state string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.states[game.Id+asset.Suffix] = state
+}
+
+// Keeps the overall progress for the dashboard and the API.
+func (server *WebServer) SetStats(stats RunStats) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.stats = stats
+}
+
+// Counts a game as done, waits while the run is paused, and returns false
+// once it was cancelled.
+func (server *WebServer) FinishGame() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.done++
+	for server.paused && !server.cancelled {
+		server.resumed.Wait()
+	}
+	return !server.cancelled
+}
+
+// Does nothing, the dashboard stays up after the run.
+func (server *WebServer) Stop() {}
+
+// Runs a download in the background, unless one is running already. The
+// games are filtered like with --games, or by the option given to serve if
+// empty. Errors that stop a run from the console stop the server too.
+func (server *WebServer) startRun(games string) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.running {
+		return false
+	}
+	server.running, server.cancelled, server.paused = true, false, false
+	defaultFilter := *gameFilter
+	if games != "" {
+		*gameFilter = games
+	}
+	go func() {
+		runDownload(server.steamArgs, server)
+		*gameFilter = defaultFilter
+		server.loadLibrary()
+		server.mutex.Lock()
+		server.running = false
+		server.mutex.Unlock()
+	}()
+	return true
+}
+
+// Asks the current run to stop after the game being processed.
+func (server *WebServer) cancel() {
+	server.mutex.Lock()
+	server.cancelled = true
+	server.resumed.Broadcast()
+	server.mutex.Unlock()
+}
+
+// Pauses the current run after the game being processed, or resumes it.
+func (server *WebServer) setPaused(paused bool) {
+	server.mutex.Lock()
+	server.paused = paused && server.running
+	server.resumed.Broadcast()
+	server.mutex.Unlock()
+}
+
+// Returns the user and game named in a path like /game/USER/GAME.
+func (server *WebServer) findGame(path string) (*User, *Game) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 {
+		return nil, nil
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for i, user := range server.users {
+		if user.SteamId32 == parts[1] {
+			return &server.users[i], server.games[user.SteamId32][parts[2]]
+		}
+	}
+	return nil, nil
+}
+
+// Returns the asset type given in the "asset" parameter, banners by default.
+func getRequestAsset(r *http.Request) *AssetType {
+	for _, asset := range assetTypes {
+		if asset.Name == r.FormValue("asset") {
+			return asset
+		}
+	}
+	return bannerAsset
+}
+
+// Card of a game on the dashboard.
+type webGame struct {
+	Id    string
+	Name  string
+	State string
+}
+
+// Shows the run status, the log and every game with its current image.
+func (server *WebServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	asset := getRequestAsset(r)
+
+	type webUser struct {
+		Name      string
+		SteamId32 string
+		Games     []webGame
+	}
+	data := struct {
+		Assets    []*AssetType
+		Asset     *AssetType
+		Running   bool
+		Cancelled bool
+		Paused    bool
+		RunUser   string
+		Done      int
+		Total     int
+		Stats     RunStats
+		Log       string
+		Users     []webUser
+	}{Assets: assetTypes, Asset: asset}
+
+	server.mutex.Lock()
+	data.Running, data.Cancelled, data.Paused = server.running, server.cancelled, server.paused
+	data.RunUser, data.Done, data.Total, data.Stats = server.runUser, server.done, server.total, server.stats
+	data.Log = strings.Join(server.log, "\n")
+	for _, user := range server.users {
+		webUser := webUser{Name: user.Name, SteamId32: user.SteamId32}
+		for _, game := range SortGames(server.games[user.SteamId32], false) {
+			name := game.Name
+			if name == "" {
+				name = "Unknown game with id " + game.Id
+			}
+			state := ""
+			if server.runUser == user.Name {
+				state = server.states[game.Id+asset.Suffix]
+			}
+			webUser.Games = append(webUser.Games, webGame{game.Id, name, state})
+		}
+		data.Users = append(data.Users, webUser)
+	}
+	server.mutex.Unlock()
+
+	if err := server.templates.ExecuteTemplate(w, "dashboard", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Serves the current grid image of a game, or its original with
+// ?original=1, from a path like /grid/USER/620p.
+func (server *WebServer) handleGridImage(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || !webGridNamePattern.MatchString(parts[2]) {
+		http.NotFound(w, r)
+		return
+	}
+	var found *User
+	server.mutex.Lock()
+	for _, user := range server.users {
+		if user.SteamId32 == parts[1] {
+			user := user
+			found = &user
+		}
+	}
+	server.mutex.Unlock()
+	groups := gridFilePattern.FindStringSubmatch(parts[2] + ".jpg")
+	if found == nil || groups == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	paths := make([]string, 0)
+	if r.FormValue("original") != "" {
+		paths = append(paths, findBackup(*found, groups[1], getAssetTypeBySuffix(groups[2])))
+	} else {
+		for _, ext := range gridImageExts {
+			paths = append(paths, filepath.Join(getGridDir(*found), parts[2]+ext))
+		}
+	}
+	for _, path := range paths {
+		if path != "" && fileExists(path) {
+			w.Header().Set("Cache-Control", "no-cache")
+			http.ServeFile(w, r, path)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// Image that can be picked for a game, and where it's from.
+type webCandidate struct {
+	Url    string
+	Source string
+}
+
+// Shows the artwork picker of a game, and installs the chosen image.
+func (server *WebServer) handleGame(w http.ResponseWriter, r *http.Request) {
+	user, game := server.findGame(r.URL.Path)
+	if game == nil {
+		http.NotFound(w, r)
+		return
+	}
+	asset := getRequestAsset(r)
+
+	message := ""
+	if r.Method == "POST" {
+		err := server.setImage(r, *user, game, asset)
+		if err != nil {
+			message = "Failed to set the image: " + err.Error()
+		} else {
+			message = "Image set."
+		}
+	}
+
+	candidates := make([]webCandidate, 0)
+	for _, url := range NewImageCandidates(game, asset).urls {
+		candidates = append(candidates, webCandidate{url, "official"})
+	}
+	if asset == bannerAsset && !*noSearch && r.FormValue("search") != "" {
+		urls, err := getGoogleImages(game.Name)
+		if err != nil {
+			message = "Failed to search: " + err.Error()
+		}
+		server.mutex.Lock()
+		server.searched[game.Id] = urls
+		server.mutex.Unlock()
+		for _, url := range urls {
+			candidates = append(candidates, webCandidate{url, "search"})
+		}
+	}
+
+	name := game.Name
+	if name == "" {
+		name = "Unknown game with id " + game.Id
+	}
+	data := struct {
+		User       *User
+		Game       webGame
+		Assets     []*AssetType
+		Asset      *AssetType
+		Message    string
+		Candidates []webCandidate
+		CanSearch  bool
+	}{user, webGame{game.Id, name, ""}, assetTypes, asset, message, candidates, asset == bannerAsset && !*noSearch}
+
+	if err := server.templates.ExecuteTemplate(w, "game", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Installs the image uploaded or picked by URL in the form, with the
+// overlays, like the set command.
+func (server *WebServer) setImage(r *http.Request, user User, game *Game, asset *AssetType) error {
+	server.mutex.Lock()
+	running, overlays := server.running, server.overlaySets[asset]
+	server.mutex.Unlock()
+	if running {
+		return errors.New("Wait for the run to finish first.")
+	}
+
+	var imageBytes []byte
+	if file, _, err := r.FormFile("file"); err == nil {
+		imageBytes, err = ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	} else if url := r.FormValue("url"); url != "" {
+		imagePath, err := server.downloadWebImage(game, asset, url)
+		if err != nil {
+			return err
+		}
+		if imageBytes, err = ioutil.ReadFile(imagePath); err != nil {
+			return err
+		}
+	} else {
+		return errors.New("No image given.")
+	}
+
+	_, err := setGridImageBytes(user, game, asset, imageBytes, overlays)
+	return err
+}
+
+// Downloads an image picked in the web UI, or finds it in the download
+// cache, and returns the path of the file. Only the images the picker
+// offers for the game can be downloaded, so the server doesn't fetch
+// whatever URL it's given.
+func (server *WebServer) downloadWebImage(game *Game, asset *AssetType, url string) (string, error) {
+	candidates := NewImageCandidates(game, asset).urls
+	if asset == bannerAsset {
+		server.mutex.Lock()
+		candidates = append(candidates, server.searched[game.Id]...)
+		server.mutex.Unlock()
+	}
+	if !containsString(candidates, url) {
+		return "", errors.New("Not one of the images found for the game.")
+	}
+	response, err := tryDownload(url)
+	if err != nil {
+		return "", err
+	}
+	if response == nil {
+		return "", errors.New("Image not found at " + url)
+	}
+	return readDownload(url, response)
+}
+
+// Serves a candidate image of a game with the overlays drawn on it, without
+// installing anything, so the picker shows how it would look:
+// /preview/USER/GAME?asset=portrait&url=...
+func (server *WebServer) handlePreview(w http.ResponseWriter, r *http.Request) {
+	user, game := server.findGame(r.URL.Path)
+	if game == nil {
+		http.NotFound(w, r)
+		return
+	}
+	asset := getRequestAsset(r)
+	imagePath, err := server.downloadWebImage(game, asset, r.FormValue("url"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	server.mutex.Lock()
+	overlays := server.overlaySets[asset]
+	server.mutex.Unlock()
+
+	// A copy, so the game in the library keeps its own image.
+	preview := *game
+	preview.ImagePath = filepath.Join(getGridDir(*user), game.Id+asset.Suffix+".jpg")
+	preview.ImageBytes, preview.ImageFile = nil, imagePath
+	err = FixImageFormat(&preview)
+	if err == nil && overlays != nil {
+		_, err = ApplyOverlay(&preview, overlays)
+	}
+	if err == nil {
+		err = preview.LoadImageBytes()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(preview.ImageBytes))
+	w.Write(preview.ImageBytes)
+}
+
+// Option on the settings page.
+type webSetting struct {
+	Name  string
+	Usage string
+	Value string
+	Bool  bool
+	// Set if it has a value that isn't shown, like an API key.
+	Hidden bool
+}
+
+// Options whose values are never sent to the page, which can only be
+// replaced.
+var hiddenWebSettings = map[string]bool{"api-key": true}
+
+// Shows the main options, like the settings command, and saves the changes
+// to the config file. They also apply right away, to the next run.
+func (server *WebServer) handleSettings(w http.ResponseWriter, r *http.Request) {
+	path := server.flags.Lookup("config").Value.String()
+	messages := make([]string, 0)
+	if r.Method == "POST" && r.ParseForm() == nil {
+		messages = server.saveSettings(r, path)
+	}
+
+	type webGroup struct {
+		Title    string
+		Settings []webSetting
+	}
+	data := struct {
+		Path     string
+		Messages []string
+		Groups   []webGroup
+	}{Path: path, Messages: messages}
+	for _, group := range settingGroups {
+		webGroup := webGroup{Title: group.Title}
+		for _, name := range group.Options {
+			if option := server.flags.Lookup(name); option != nil {
+				setting := webSetting{name, option.Usage, option.Value.String(), isBoolFlag(option), false}
+				if hiddenWebSettings[name] {
+					setting.Value, setting.Hidden = "", setting.Value != ""
+				}
+				webGroup.Settings = append(webGroup.Settings, setting)
+			}
+		}
+		data.Groups = append(data.Groups, webGroup)
+	}
+	if err := server.templates.ExecuteTemplate(w, "settings", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Applies the options changed in the settings form and saves them to the
+// config file. Returns what happened, for the page.
+func (server *WebServer) saveSettings(r *http.Request, path string) []string {
+	server.mutex.Lock()
+	running := server.running
+	server.mutex.Unlock()
+	if running {
+		return []string{"Wait for the run to finish first."}
+	}
+	if path == "" {
+		return []string{"No config folder found for steamgrid.ini, give a file with --config."}
+	}
+
+	messages := make([]string, 0)
+	changes := make(map[string]string)
+	for _, group := range settingGroups {
+		for _, name := range group.Options {
+			// Checkboxes come with a hidden empty value, so options missing
+			// from the form are left alone.
+			option, values := server.flags.Lookup(name), r.PostForm[name]
+			if option == nil || len(values) == 0 {
+				continue
+			}
+			value := strings.TrimSpace(values[len(values)-1])
+			if hiddenWebSettings[name] && value == "" {
+				// Left empty, since the page doesn't have it.
+				continue
+			}
+			if isBoolFlag(option) {
+				value = fmt.Sprint(containsString(values, "1"))
+			}
+			previous := option.Value.String()
+			if value == previous {
+				continue
+			}
+			err := server.flags.Set(name, value)
+			if err == nil {
+				err = validateSetting(name, value)
+			}
+			if err != nil {
+				server.flags.Set(name, previous)
+				messages = append(messages, "Invalid "+name+": "+err.Error())
+				continue
+			}
+			changes[name] = option.Value.String()
+		}
+	}
+	if len(changes) == 0 {
+		return append(messages, "Nothing changed.")
+	}
+	if err := saveIniValues(path, changes); err != nil {
+		return append(messages, "Failed to save the settings: "+err.Error())
+	}
+	// The overlays may be others now.
+	server.loadLibrary()
+	return append(messages, fmt.Sprintf("%v settings saved to %v.", len(changes), path))
+}
+
+// Starts a download run from the dashboard.
+func (server *WebServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	server.startRun("")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Pauses the current run after the game being processed, or resumes it if
+// the form says so.
+func (server *WebServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	server.setPaused(r.FormValue("resume") == "")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Cancels the current run after the game being processed.
+func (server *WebServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	server.cancel()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Pages of the web UI.
+const webTemplates = `
+{{define "header"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>SteamGrid</title>
+<style>
+body { font-family: sans-serif; background: #1b2838; color: #c7d5e0; margin: 1em; }
+a { color: #66c0f4; }
+.games { display: flex; flex-wrap: wrap; gap: 8px; }
+.game { width: 230px; text-decoration: none; color: inherit; }
+.game img { width: 230px; background: #2a475e; display: block; min-height: 40px; }
+.game .name { font-size: 0.85em; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+.state { color: #a3cf06; font-size: 0.8em; }
+pre { background: #0e141b; padding: 0.5em; max-height: 15em; overflow: auto; }
+.candidates img { max-width: 300px; max-height: 300px; display: block; }
+.candidates form, .candidates figure { display: inline-block; margin: 4px; vertical-align: top; }
+.candidates .pair img { display: inline-block; max-width: 240px; }
+.source, figcaption { font-size: 0.8em; color: #8f98a0; }
+small { color: #8f98a0; }
+.nav form { display: inline; }
+.drop { border: 2px dashed #4b6a82; padding: 1em; margin: 8px 0; }
+.dragover { outline: 3px solid #66c0f4; }
+</style>
+<script>
+// An image file dropped on a game card or the picker is uploaded like the
+// upload form of the game does.
+function dropTarget(event) {
+	return event.target.closest ? event.target.closest('[data-drop]') : null;
+}
+document.addEventListener('dragover', function (event) {
+	var target = dropTarget(event);
+	if (target && event.dataTransfer.types.indexOf('Files') >= 0) {
+		event.preventDefault();
+		event.dataTransfer.dropEffect = 'copy';
+		target.classList.add('dragover');
+	}
+});
+document.addEventListener('dragleave', function (event) {
+	var target = dropTarget(event);
+	if (target) {
+		target.classList.remove('dragover');
+	}
+});
+document.addEventListener('drop', function (event) {
+	var target = dropTarget(event);
+	if (!target || event.dataTransfer.files.length == 0) {
+		return;
+	}
+	event.preventDefault();
+	var form = document.createElement('form');
+	form.method = 'post';
+	form.action = target.dataset.drop;
+	form.enctype = 'multipart/form-data';
+	form.style.display = 'none';
+	var input = document.createElement('input');
+	input.type = 'file';
+	input.name = 'file';
+	input.files = event.dataTransfer.files;
+	form.appendChild(input);
+	document.body.appendChild(form);
+	form.submit();
+});
+</script></head></head><body>
+<p class="nav"><a href="/">Library</a> · <a href="/settings">Settings</a>{{if isApp}} · <form method="post" action="/quit"><button>Quit</button></form>{{end}}</p>
+{{end}}
+
+{{define "assets"}}<p>{{$current := .Asset}}{{range .Assets}}{{if eq . $current}}<b>{{.Name}}</b>{{else}}<a href="?asset={{.Name}}">{{.Name}}</a>{{end}} {{end}}</p>{{end}}
+
+{{define "dashboard"}}{{template "header"}}
+{{if .Running}}<meta http-equiv="refresh" content="2">{{end}}
+<h1>SteamGrid</h1>
+{{if .Running}}
+<p>{{if .Cancelled}}Cancelling{{else if .Paused}}Paused{{else}}Running{{end}}: {{.RunUser}}, {{.Done}} of {{.Total}} games.</p>
+{{if not .Cancelled}}<form method="post" action="/pause">{{if .Paused}}<input type="hidden" name="resume" value="1"><button>Resume</button>{{else}}<button>Pause</button>{{end}}</form>{{end}}
+<p>Overall: {{.Stats}}.</p>
+<form method="post" action="/cancel"><button>Cancel</button></form>
+{{else}}
+<form method="post" action="/run"><button>Download and apply overlays</button></form>
+{{end}}
+{{if .Log}}<pre>{{.Log}}</pre>{{end}}
+{{template "assets" .}}
+<p><small>Drop an image file on a game to install it as its {{.Asset.Name}}.</small></p>
+{{$asset := .Asset}}
+{{range .Users}}{{$user := .}}
+<h2>{{.Name}} ({{len .Games}} games)</h2>
+<div class="games">
+{{range .Games}}<a class="game" href="/game/{{$user.SteamId32}}/{{.Id}}?asset={{$asset.Name}}" data-drop="/game/{{$user.SteamId32}}/{{.Id}}?asset={{$asset.Name}}">
+<img loading="lazy" src="/grid/{{$user.SteamId32}}/{{.Id}}{{$asset.Suffix}}" alt="">
+<div class="name">{{.Name}}</div>{{if .State}}<div class="state">{{.State}}</div>{{end}}</a>
+{{end}}</div>
+{{end}}
+</body></html>{{end}}
+
+{{define "game"}}{{template "header"}}
+<p><a href="/?asset={{.Asset.Name}}">Back to the library</a></p>
+<h1>{{.Game.Name}}</h1>
+{{template "assets" .}}
+{{if .Message}}<p><b>{{.Message}}</b></p>{{end}}
+<h2>Current {{.Asset.Name}}</h2>
+<div class="candidates">
+<figure><img src="/grid/{{.User.SteamId32}}/{{.Game.Id}}{{.Asset.Suffix}}?original=1" alt="none"><figcaption>Before: the original</figcaption></figure>
+<figure><img src="/grid/{{.User.SteamId32}}/{{.Game.Id}}{{.Asset.Suffix}}" alt="none"><figcaption>After: installed, with the overlays</figcaption></figure>
+</div>
+<h2>Pick a new one</h2>
+<p>It becomes the original of the game, with the overlays drawn on top. Each one is shown as found and with the overlays.</p>
+<div class="candidates">
+{{$page := .}}{{range .Candidates}}<form method="post">
+<input type="hidden" name="asset" value="{{$page.Asset.Name}}"><input type="hidden" name="url" value="{{.Url}}">
+<div class="source">{{.Source}}</div>
+<div class="pair"><img src="{{.Url}}" alt="" onerror="this.closest('form').style.display='none'">
+<img loading="lazy" src="/preview/{{$page.User.SteamId32}}/{{$page.Game.Id}}?asset={{$page.Asset.Name}}&url={{.Url}}" alt=""></div>
+<button>Use this one</button></form>
+{{end}}</div>
+{{if .CanSearch}}<form method="get"><input type="hidden" name="asset" value="{{.Asset.Name}}"><input type="hidden" name="search" value="1"><button>Search Google for more</button></form>{{end}}
+<form method="post" enctype="multipart/form-data"><input type="hidden" name="asset" value="{{.Asset.Name}}">Upload: <input type="file" name="file" accept="image/png,image/jpeg"> <button>Use</button></form>
+<div class="drop" data-drop="/game/{{.User.SteamId32}}/{{.Game.Id}}?asset={{.Asset.Name}}">Or drop an image file here.</div>
+</body></html>{{end}}
+
+{{define "settings"}}{{template "header"}}
+<h1>Settings</h1>
+<p>Saved to {{.Path}}, and used from the next run on.</p>
+{{range .Messages}}<p><b>{{.}}</b></p>{{end}}
+<form method="post">
+{{range .Groups}}<h2>{{.Title}}</h2>
+{{range .Settings}}<p><label>{{if .Bool}}<input type="hidden" name="{{.Name}}" value=""><input type="checkbox" name="{{.Name}}" value="1"{{if eq .Value "true"}} checked{{end}}> {{.Name}}{{else}}{{.Name}}: <input {{if .Hidden}}type="password" placeholder="saved, type a new one to change it" {{end}}name="{{.Name}}" value="{{.Value}}" size="40">{{end}}</label><br><small>{{.Usage}}</small></p>
+{{end}}{{end}}
+<button>Save</button></form>
+</body></html>{{end}}
+`
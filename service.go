@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// Name of the systemd unit and of the scheduled task on Windows.
+const serviceName = "steamgrid"
+
+// Returns a channel that receives Ctrl+C and the signal sent by service
+// managers to stop, so long running commands can finish what they're doing.
+func notifyShutdown() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	return signals
+}
+
+// Returns the flag set of the service command, whose usage also explains
+// its arguments.
+func newServiceFlags() *flag.FlagSet {
+	flags := newCommandFlags("service")
+	flags.Usage = func() {
+		printUsage(flags)
+		fmt.Fprintln(os.Stderr, "\nUsage: steamgrid service install [watch|serve|tray] [options of the command]\n       steamgrid service uninstall")
+	}
+	return flags
+}
+
+// Installs or removes the watch, serve or tray command as a service that starts
+// with the session: "service install [watch|serve|tray] [options]" and
+// "service uninstall".
+func runService(args []string) {
+	flags := newServiceFlags()
+	parseCommandFlags(flags, args)
+
+	var err error
+	switch flags.Arg(0) {
+	case "install":
+		command := "watch"
+		rest := flags.Args()[1:]
+		if len(rest) > 0 && (rest[0] == "watch" || rest[0] == "serve" || rest[0] == "tray") {
+			command, rest = rest[0], rest[1:]
+		}
+		err = installService(append([]string{command, "--headless"}, rest...))
+	case "uninstall":
+		err = uninstallService()
+	default:
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		errorAndExit(err)
+	}
+}
+
+// Returns the path of this program, to be started by the service manager.
+func getExecutablePath() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// Runs a command of the service manager, showing its output.
+func runServiceManager(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stdout
+	if err := cmd.Run(); err != nil {
+		return errors.New(tr("Failed to run %v %v: %v", name, strings.Join(args, " "), err.Error()))
+	}
+	return nil
+}
+
+// Returns the path of the systemd user unit.
+func getSystemdUnitPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "systemd", "user", serviceName+".service"), nil
+}
+
+// Quotes an argument for the command lines of systemd units.
+func quoteSystemdArg(arg string) string {
+	arg = strings.Replace(arg, `\`, `\\`, -1)
+	arg = strings.Replace(arg, `"`, `\"`, -1)
+	arg = strings.Replace(arg, "%", "%%", -1)
+	return `"` + arg + `"`
+}
+
+// Quotes an argument for Windows command lines, when it needs it.
+func quoteWindowsArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	return `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+}
+
+// Registers the program with the given arguments to start with the session
+// and starts it now: as a systemd user unit on Linux, restarted if it fails,
+// and as a task that runs at logon on Windows, since Steam keeps its files
+// per user anyway. A task isn't a Windows service, so it's stopped by
+// killing it rather than as cleanly as the systemd unit.
+func installService(args []string) error {
+	exePath, err := getExecutablePath()
+	if err != nil {
+		return err
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		unitPath, err := getSystemdUnitPath()
+		if err != nil {
+			return err
+		}
+		commandLine := []string{quoteSystemdArg(exePath)}
+		for _, arg := range args {
+			commandLine = append(commandLine, quoteSystemdArg(arg))
+		}
+		// The tray icon needs the desktop, so it starts and stops with it.
+		dependencies, wantedBy := "After=network-online.target\n", "default.target"
+		if args[0] == "tray" {
+			dependencies = "After=network-online.target graphical-session.target\nPartOf=graphical-session.target\n"
+			wantedBy = "graphical-session.target"
+		}
+		unit := "[Unit]\n" +
+			"Description=SteamGrid " + args[0] + "\n" +
+			dependencies + "\n" +
+			"[Service]\n" +
+			"ExecStart=" + strings.Join(commandLine, " ") + "\n" +
+			"Restart=on-failure\n" +
+			"RestartSec=30\n\n" +
+			"[Install]\n" +
+			"WantedBy=" + wantedBy + "\n"
+		if err := os.MkdirAll(filepath.Dir(unitPath), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(unitPath, []byte(unit), 0666); err != nil {
+			return err
+		}
+		fmt.Println(tr("Wrote %v", unitPath))
+		if err := runServiceManager("systemctl", "--user", "daemon-reload"); err != nil {
+			return err
+		}
+		if err := runServiceManager("systemctl", "--user", "enable", "--now", serviceName+".service"); err != nil {
+			return err
+		}
+		fmt.Println(tr("Installed and started. See its output with: journalctl --user -u %v", serviceName))
+
+	case "windows":
+		commandLine := []string{quoteWindowsArg(exePath)}
+		for _, arg := range args {
+			commandLine = append(commandLine, quoteWindowsArg(arg))
+		}
+		if err := runServiceManager("schtasks", "/Create", "/F", "/TN", serviceName, "/SC", "ONLOGON", "/RL", "LIMITED", "/TR", strings.Join(commandLine, " ")); err != nil {
+			return err
+		}
+		if err := runServiceManager("schtasks", "/Run", "/TN", serviceName); err != nil {
+			return err
+		}
+		fmt.Println(tr("Installed and started, it will start again at every logon."))
+
+	default:
+		return errors.New(tr("Services can only be installed on Linux, with systemd, and on Windows."))
+	}
+	return nil
+}
+
+// Stops the service and removes what installService added.
+func uninstallService() error {
+	switch runtime.GOOS {
+	case "linux":
+		unitPath, err := getSystemdUnitPath()
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(unitPath); err != nil {
+			return errors.New(tr("The service is not installed, there's no %v", unitPath))
+		}
+		if err := runServiceManager("systemctl", "--user", "disable", "--now", serviceName+".service"); err != nil {
+			return err
+		}
+		if err := os.Remove(unitPath); err != nil {
+			return err
+		}
+		if err := runServiceManager("systemctl", "--user", "daemon-reload"); err != nil {
+			return err
+		}
+
+	case "windows":
+		// Ending the task kills the program, there's no stop signal to
+		// handle like with systemd. Fails if it's not running, which is
+		// fine.
+		exec.Command("schtasks", "/End", "/TN", serviceName).Run()
+		if err := runServiceManager("schtasks", "/Delete", "/F", "/TN", serviceName); err != nil {
+			return err
+		}
+
+	default:
+		return errors.New(tr("Services can only be installed on Linux, with systemd, and on Windows."))
+	}
+	fmt.Println(tr("Uninstalled."))
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options shown by the settings command, grouped by what they change.
+var settingGroups = []struct {
+	Title   string
+	Options []string
+}{
+	{"Image sources", []string{"source-order", "no-search", "review-search", "collages", "placeholders"}},
+	{"API keys", []string{"api-key"}},
+	{"Overlays", []string{"overlays", "single-overlay", "uninstalled-saturation", "jpeg-quality", "jpeg-subsampling", "jpeg-encoder"}},
+	{"Badges", []string{"protondb-badges", "review-badges", "playtime-badges", "year-badges", "controller-badges", "vr-badges", "multiplayer-badges", "achievement-badges", "howlongtobeat-badges"}},
+	{"Asset types", []string{"assets"}},
+	{"Performance", []string{"concurrency"}},
+	{"Language", []string{"language"}},
+	{"Logging", []string{"verbose", "log-file"}},
+}
+
+// Returns true if the flag is an on/off option.
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// Checks the values the download command would reject, even if they parse.
+func validateSetting(name string, value string) error {
+	switch name {
+	case "jpeg-quality", "jpeg-subsampling":
+		return checkJpegOptions()
+	case "jpeg-encoder":
+		return checkJpegEncoder()
+	case "concurrency":
+		if *concurrency < 1 {
+			return errors.New(tr("The concurrency must be at least 1."))
+		}
+	case "uninstalled-saturation":
+		if *uninstalledSaturation > 1 {
+			return errors.New(tr("The saturation of uninstalled games must be between 0 and 1."))
+		}
+	case "assets":
+		_, err := GetAssetTypes(value)
+		return err
+	case "source-order":
+		return checkSourceOrder()
+	case "multiplayer-badges":
+		for _, kind := range splitList(value) {
+			if !containsString(multiplayerBadgeNames(), kind) {
+				return errors.New(tr("Unknown multiplayer badge '%v', expected some of: %v", kind, strings.Join(multiplayerBadgeNames(), ", ")))
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the flag set of the settings command, which only has the common
+// options.
+func newSettingsFlags() *flag.FlagSet {
+	return newCommandFlags("settings")
+}
+
+// Walks through the main options in the console, showing the current value
+// of each, and saves the changes to the config file, so nobody has to edit
+// it by hand.
+func runSettings(args []string) {
+	flags := newSettingsFlags()
+	parseCommandFlags(flags, args)
+	path := getConfigPath(args)
+	if path == "" {
+		errorAndExit(errors.New(tr("No config folder found for steamgrid.ini, give a file with --config.")))
+	}
+	if isHeadless() {
+		errorAndExit(errors.New(tr("The settings need an interactive terminal. You can edit %v instead.", path)))
+	}
+
+	// The download command has every option, with the saved values applied.
+	current := newDownloadFlags()
+	if err := applyConfig(current, path); err != nil {
+		errorAndExit(err)
+	}
+
+	fmt.Println(tr("Settings are saved to %v", path))
+	fmt.Println(tr("Press enter to keep the current value, or type a new one."))
+	changes := make(map[string]string)
+	for _, group := range settingGroups {
+		fmt.Printf("\n%v\n", tr(group.Title))
+		for _, name := range group.Options {
+			option := current.Lookup(name)
+			for {
+				value := option.Value.String()
+				if isBoolFlag(option) {
+					value = map[string]string{"true": "yes", "false": "no"}[value]
+				}
+				fmt.Printf("  %v\n  %v [%v]: ", option.Usage, name, value)
+				answer, err := stdinReader.ReadString('\n')
+				answer = strings.TrimSpace(answer)
+				if answer == "" || err != nil {
+					break
+				}
+				if isBoolFlag(option) {
+					switch strings.ToLower(answer) {
+					case "y", "yes":
+						answer = "true"
+					case "n", "no":
+						answer = "false"
+					}
+				}
+				previous := option.Value.String()
+				err = current.Set(name, answer)
+				if err == nil {
+					err = validateSetting(name, answer)
+				}
+				if err != nil {
+					current.Set(name, previous)
+					fmt.Print(tr("  Invalid value: %v\n", err.Error()))
+					continue
+				}
+				changes[name] = option.Value.String()
+				break
+			}
+		}
+	}
+
+	if len(changes) == 0 {
+		fmt.Println(tr("\nNothing changed."))
+		return
+	}
+	if err := saveIniValues(path, changes); err != nil {
+		errorAndExit(err)
+	}
+	fmt.Print(tr("\n%v settings saved to %v\n", len(changes), path))
+}
+
+// Sets options at the top of an INI file, before any [section], keeping
+// everything else in the file as it is. Keys are matched like the config
+// file does, ignoring case and with spaces or dashes. New keys go after the
+// existing top options.
+func saveIniValues(path string, values map[string]string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := make([]string, 0)
+	if len(contents) > 0 {
+		lines = strings.Split(strings.TrimRight(string(contents), "\n"), "\n")
+	}
+
+	formatLine := func(key string, value string) string {
+		if value == "" || strings.TrimSpace(value) != value {
+			value = `"` + value + `"`
+		}
+		return key + " = " + value
+	}
+
+	saved := make(map[string]bool)
+	topEnd := len(lines)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			topEnd = i
+			break
+		}
+		separator := strings.Index(trimmed, "=")
+		if separator == -1 || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		key := strings.TrimSpace(trimmed[:separator])
+		name := strings.Replace(strings.ToLower(key), " ", "-", -1)
+		if value, ok := values[name]; ok {
+			lines[i] = formatLine(key, value)
+			saved[name] = true
+		}
+	}
+
+	// Before the blank lines that separate the top from the first section.
+	insertAt := topEnd
+	for insertAt > 0 && strings.TrimSpace(lines[insertAt-1]) == "" {
+		insertAt--
+	}
+	added := make([]string, 0)
+	for _, group := range settingGroups {
+		for _, name := range group.Options {
+			if value, ok := values[name]; ok && !saved[name] {
+				added = append(added, formatLine(name, value))
+				saved[name] = true
+			}
+		}
+	}
+	for name, value := range values {
+		if !saved[name] {
+			added = append(added, formatLine(name, value))
+		}
+	}
+	lines = append(lines[:insertAt], append(added, lines[insertAt:]...)...)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
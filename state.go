@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// True to process every game, even the ones that didn't change since the
+// last run, given with --full.
+var fullRun = new(bool)
+
+// How long an image is trusted to be up to date. Badges come from outside
+// data, like reviews and achievements, so they are redrawn at least this
+// often.
+const imageStateMaxAge = 7 * 24 * time.Hour
+
+// How long the state is kept without runs. The images expire on their own,
+// but the synced hashes are needed to tell sides apart much later.
+const runStateMaxAge = 365 * 24 * time.Hour
+
+// What the last run did with an image, to skip it in the next runs while
+// nothing that goes into it changes.
+type ImageState struct {
+	Source string
+	// SHA-256 of the image installed, or "" if none was found.
+	Hash string
+	// Hash of everything the image was made from: the categories of the
+	// game, the overlays and the options, from imageInputs.
+	Inputs string
+	Time   time.Time
+	// Where the image was looked for, if none was found.
+	Tried []string `json:",omitempty"`
+}
+
+// State of the images of a user after the last runs, by game id and asset
+// type.
+type RunState struct {
+	Images map[string]ImageState
+	// Hashes of the images as they were after the last sync, by sync folder
+	// and then like Images, to tell which side changed since.
+	Synced map[string]map[string]string
+
+	// Games are processed at the same time and saved after each one.
+	mutex sync.Mutex
+}
+
+// Loads the state of the images of a user, empty if there's none.
+func loadRunState(user User) *RunState {
+	state := &RunState{}
+	if !readCache("state", user.SteamId32, runStateMaxAge, state) || state.Images == nil {
+		state.Images = make(map[string]ImageState)
+	}
+	if state.Synced == nil {
+		state.Synced = make(map[string]map[string]string)
+	}
+	return state
+}
+
+// Saves the state of the images of a user.
+func saveRunState(user User, state *RunState) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return writeCache("state", user.SteamId32, state)
+}
+
+// Returns the key of an image in the state.
+func imageStateKey(game *Game, asset *AssetType) string {
+	return game.Id + " " + asset.Name
+}
+
+// Returns the state of the image if it's still what the last run left: the
+// same inputs, recent enough, and the same file in the grid folder, or
+// still no file if none was found. Returns false if it has to be done again.
+func (state *RunState) Unchanged(user User, game *Game, asset *AssetType, inputs string) (ImageState, bool) {
+	state.mutex.Lock()
+	entry, ok := state.Images[imageStateKey(game, asset)]
+	state.mutex.Unlock()
+	if !ok || entry.Inputs != inputs || time.Since(entry.Time) > imageStateMaxAge {
+		return entry, false
+	}
+	_, current := loadCurrentGridImage(user, game, asset)
+	if current == nil {
+		return entry, entry.Hash == ""
+	}
+	sum := sha256.Sum256(current)
+	return entry, entry.Hash == hex.EncodeToString(sum[:])
+}
+
+// Records the image installed for a game, by its hash.
+func (state *RunState) Record(game *Game, asset *AssetType, inputs string, hash string) {
+	entry := ImageState{Source: game.ImageSource, Inputs: inputs, Time: time.Now(), Hash: hash}
+	state.mutex.Lock()
+	state.Images[imageStateKey(game, asset)] = entry
+	state.mutex.Unlock()
+}
+
+// Records that no image was found for a game, and where it was looked for.
+func (state *RunState) RecordNotFound(game *Game, asset *AssetType, inputs string, tried []string) {
+	entry := ImageState{Source: game.ImageSource, Inputs: inputs, Time: time.Now(), Tried: tried}
+	state.mutex.Lock()
+	state.Images[imageStateKey(game, asset)] = entry
+	state.mutex.Unlock()
+}
+
+// Describes the options that change how images look, and the overlay files
+// by name, size and time, so changing any of them does every game again.
+func getRunSettings() string {
+	settings := fmt.Sprint(*overlaysPath, *singleOverlay, *uninstalledSaturation, *jpegQuality, *jpegSubsampling, *jpegEncoder, *noSearch, *collages, *placeholders, *sourceOrder, *genres, *compat,
+		*protonDbBadges, *reviewBadges, *playtimeBadges, *yearBadges, *controllerBadges, *vrBadges, *multiplayerBadgeList, *achievementBadges, *howLongBadges)
+	filepath.Walk(*overlaysPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			settings += fmt.Sprintf("\n%v %v %v", path, info.Size(), info.ModTime().Unix())
+		}
+		return nil
+	})
+	return settings
+}
+
+// Returns a hash of everything that goes into the image of a game: the run
+// settings, its categories, whether it's installed or a favorite, and its
+// override.
+func imageInputs(settings string, game *Game, asset *AssetType, overrides map[string]string) string {
+	tags := game.AllTags()
+	sort.Strings(tags)
+	key := game.Id
+	if asset != bannerAsset {
+		key += " " + asset.Name
+	}
+	inputs := fmt.Sprint(settings, "\n", strings.Join(tags, ","), game.Installed, game.Favorite, overrides[key])
+	if *playtimeBadges {
+		inputs += formatPlaytime(game.Playtime)
+	}
+	sum := sha256.Sum256([]byte(inputs))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What to do when Steam is running during a run, given with
+// --steam-running: "ask", "warn", "close", "restart" or "ignore".
+var steamRunning = new(string)
+
+// How long Steam gets to save its state and exit when closed.
+const steamShutdownTimeout = 30 * time.Second
+
+// Returns true if the Steam client is running for this user session.
+func isSteamRunning() bool {
+	switch runtime.GOOS {
+	case "windows":
+		out, err := exec.Command("tasklist", "/FI", "IMAGENAME eq steam.exe", "/NH").Output()
+		return err == nil && strings.Contains(strings.ToLower(string(out)), "steam.exe")
+	case "darwin":
+		return exec.Command("pgrep", "-x", "steam_osx").Run() == nil
+	default:
+		// pgrep exits with 1 when nothing matches.
+		return exec.Command("pgrep", "-x", "steam").Run() == nil
+	}
+}
+
+// Returns the command that runs the Steam client with the given arguments.
+func steamCommand(installationDir string, args ...string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command(filepath.Join(installationDir, "steam.exe"), args...)
+	case "darwin":
+		return exec.Command("open", append([]string{"-a", "Steam", "--args"}, args...)...)
+	default:
+		return exec.Command("steam", args...)
+	}
+}
+
+// Asks Steam to save its state and exit, and waits until it's gone.
+func closeSteam(installationDir string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// "open" would only bring the running client to the front.
+		cmd = exec.Command("osascript", "-e", `quit app "Steam"`)
+	} else {
+		cmd = steamCommand(installationDir, "-shutdown")
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	deadline := time.Now().Add(steamShutdownTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		if !isSteamRunning() {
+			return nil
+		}
+	}
+	return errors.New("Steam is still running after " + steamShutdownTimeout.String() + ", close it by hand.")
+}
+
+// Starts the Steam client again, without waiting for it.
+func startSteam(installationDir string) error {
+	cmd := steamCommand(installationDir)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// Checks if Steam is running before anything is written, since it may
+// ignore the new images and overwrite the categories until it's restarted.
+// Depending on --steam-running it warns, asks, or closes Steam. Returns true
+// if Steam should be started again after the run.
+func prepareSteamClient(installationDir string) bool {
+	action := *steamRunning
+	if action == "ignore" || !isSteamRunning() {
+		return false
+	}
+	if action == "ask" {
+		// Nobody can answer, or the terminal UI owns the screen.
+		if isHeadless() || *terminalUI {
+			action = "warn"
+		} else {
+			fmt.Println(tr("Steam is running, and it may ignore or undo the changes until it's restarted."))
+			action = askChoice(tr("Close Steam now, and start it again after the run?"), "no", "restart", "close")
+			if action == "no" {
+				return false
+			}
+		}
+	}
+	if action == "warn" {
+		logEvent(LogWarning, "steam_running", nil, "Steam is running")
+		fmt.Println(tr("Steam is running, restart it after the run to see the new images. Add --steam-running restart to do it automatically."))
+		return false
+	}
+
+	fmt.Println(tr("Closing Steam..."))
+	if err := closeSteam(installationDir); err != nil {
+		fmt.Print(tr("Failed to close Steam, continuing anyway: %v\n", err.Error()))
+		return false
+	}
+	return action == "restart"
+}
+
+// What to do when Steam Cloud may undo the changes, given with
+// --steam-cloud: "ask", "warn", "wait" or "ignore".
+var steamCloud = new(string)
+
+// How recent a sync must be to assume Steam is still at it, and how long to
+// wait for the sync before giving up.
+const (
+	steamCloudSettleTime = time.Minute
+	steamCloudMaxWait    = 30 * time.Minute
+)
+
+// Returns the reasons Steam Cloud may undo our changes to the files of a
+// user that it syncs, like the categories in sharedconfig.vdf, from the
+// state Steam keeps in 7/remotecache.vdf. Empty if they look in sync.
+func getSteamCloudConflicts(user User, steamIsRunning bool) []string {
+	remoteCacheFile := filepath.Join(user.Dir, "7", "remotecache.vdf")
+	info, err := os.Stat(remoteCacheFile)
+	if err != nil {
+		// Steam Cloud is off, or never synced here.
+		return nil
+	}
+	conflicts := make([]string, 0)
+	if steamIsRunning && time.Since(info.ModTime()) < steamCloudSettleTime {
+		conflicts = append(conflicts, tr("Steam Cloud synced the settings of %v less than a minute ago, and may still be at it.", user.Name))
+	}
+
+	remoteCacheBytes, err := ioutil.ReadFile(remoteCacheFile)
+	if err != nil {
+		return conflicts
+	}
+	root, err := ParseVdf(remoteCacheBytes)
+	if err != nil {
+		logf(LogVerbose, "Failed to read %v: %v", remoteCacheFile, err.Error())
+		return conflicts
+	}
+	for _, file := range root.Child("7").Children {
+		if !file.IsBlock {
+			continue
+		}
+		// Seconds since 1970, of the copy here and of the one in the cloud.
+		localTime, _ := strconv.ParseInt(file.Get("localtime"), 10, 64)
+		remoteTime, _ := strconv.ParseInt(file.Get("remotetime"), 10, 64)
+		if remoteTime > localTime {
+			conflicts = append(conflicts, tr("Steam Cloud has a newer %v of %v, from another computer, which replaces the one here when Steam syncs.", file.Key, user.Name))
+		}
+	}
+	return conflicts
+}
+
+// Returns the Steam Cloud conflicts of all the users.
+func getAllSteamCloudConflicts(users []User, steamIsRunning bool) []string {
+	conflicts := make([]string, 0)
+	for _, user := range users {
+		conflicts = append(conflicts, getSteamCloudConflicts(user, steamIsRunning)...)
+	}
+	return conflicts
+}
+
+// Checks if Steam Cloud is likely to undo the changes of the run, because
+// it's syncing or has newer files from another computer. Depending on
+// --steam-cloud it warns, asks, or waits until Steam is closed and synced.
+func prepareSteamCloud(users []User) {
+	action := *steamCloud
+	if action == "ignore" {
+		return
+	}
+	conflicts := getAllSteamCloudConflicts(users, isSteamRunning())
+	if len(conflicts) == 0 {
+		return
+	}
+	for _, conflict := range conflicts {
+		fmt.Println(conflict)
+	}
+	if action == "ask" {
+		// Nobody can answer, or the terminal UI owns the screen.
+		if isHeadless() || *terminalUI {
+			action = "warn"
+		} else {
+			action = askChoice(tr("Wait until Steam is closed and synced?"), "no", "wait")
+			if action == "no" {
+				return
+			}
+		}
+	}
+	if action == "warn" {
+		logEvent(LogWarning, "steam_cloud", LogFields{"conflicts": conflicts}, "Steam Cloud may undo the changes: %v", strings.Join(conflicts, " "))
+		fmt.Println(tr("Steam Cloud may undo some of the changes. Add --steam-cloud wait to wait until it's in sync."))
+		return
+	}
+
+	fmt.Println(tr("Waiting for Steam to sync and close. If it's closed, start it, let it sync and close it again. Press Ctrl+C to stop."))
+	deadline := time.Now().Add(steamCloudMaxWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		if !isSteamRunning() && len(getAllSteamCloudConflicts(users, false)) == 0 {
+			fmt.Println(tr("Steam Cloud is in sync."))
+			return
+		}
+	}
+	fmt.Print(tr("Steam Cloud is still not in sync after %v, continuing anyway.\n", steamCloudMaxWait))
+}
@@ -3,150 +3,1126 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"image"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Command line options, registered in the flag sets of the commands that use
+// them.
+var (
+	headless              = new(bool)
+	dryRun                = new(bool)
+	userFilter            = new(string)
+	gameFilter            = new(string)
+	noSearch              = new(bool)
+	terminalUI            = new(bool)
+	reviewAll             = new(bool)
+	notify                = new(bool)
+	resume                = new(bool)
+	reviewSearch          = new(bool)
+	imageProtocol         = new(string)
+	assetNames            = new(string)
+	overlaysPath          = new(string)
+	categorize            = new(bool)
+	genres                = new(bool)
+	jpegQuality           = new(string)
+	jpegSubsampling       = new(string)
+	apiKey                = new(string)
+	achievementBadges     = new(bool)
+	howLongBadges         = new(bool)
+	controllerBadges      = new(bool)
+	vrBadges              = new(bool)
+	multiplayerBadgeList  = new(string)
+	yearBadges            = new(bool)
+	protonDbBadges        = new(bool)
+	reviewBadges          = new(bool)
+	playtimeBadges        = new(bool)
+	uninstalledSaturation = new(float64)
+	singleOverlay         = new(bool)
+	includeHidden         = new(bool)
+	favoritesFirst        = new(bool)
+	collages              = new(bool)
+	placeholders          = new(bool)
+	prefetchWishlist      = new(bool)
+	retryFailed           = new(bool)
+	compat                = new(bool)
+)
+
+// Registers the options selecting the asset types to process and where
+// their overlays are.
+func addAssetFlags(flags *flag.FlagSet) {
+	flags.StringVar(overlaysPath, "overlays", filepath.Join(filepath.Dir(os.Args[0]), "overlays by category"), "Folder with the overlays and their overlays.ini.")
+	flags.StringVar(assetNames, "assets", "banner", "Comma separated asset types to process: banner, portrait, hero, logo, or all. Each has its own overlays, in a subfolder of 'overlays by category' named after it, except banners.")
+}
+
+// Registers the option selecting the Steam users to process.
+func addUserFlags(flags *flag.FlagSet) {
+	flags.StringVar(userFilter, "user", "", "Comma separated persona names or Steam ids of the users to process, instead of everyone in this computer.")
+	flags.StringVar(gridPermissions, "grid-permissions", "auto", "How to fix the permissions of the grid folders: auto to add only the ones steamgrid is missing, off to never change them, or an octal mode like 755.")
+}
+
+// Registers the option selecting the games to process.
+func addGameFlags(flags *flag.FlagSet) {
+	flags.StringVar(gameFilter, "games", "", "Comma separated app ids or name globs, like '620,440' or 'Half-Life*', of the only games to process.")
+}
+
+// Registers the options that change how overlays are applied.
+func addOverlayFlags(flags *flag.FlagSet) {
+	flags.BoolVar(singleOverlay, "single-overlay", false, "Apply at most one overlay per game, the matching one with the highest priority.")
+	flags.Float64Var(uninstalledSaturation, "uninstalled-saturation", -1, "Saturation, from 0 (grayscale) to 1 (unchanged), of the images of games that are not installed.")
+}
+
+// Registers the options of the download command.
+func addDownloadFlags(flags *flag.FlagSet) {
+	addAssetFlags(flags)
+	addUserFlags(flags)
+	addGameFlags(flags)
+	addProtectedFlags(flags)
+	addOverlayFlags(flags)
+	flags.BoolVar(terminalUI, "tui", false, "Show a full screen list of the games and their state, where p pauses and q cancels the run. Not on Windows.")
+	flags.BoolVar(reviewAll, "review", false, "Show each image downloaded and ask to accept it, reject it or try the next one found, before anything is written.")
+	flags.BoolVar(reviewSearch, "review-search", false, "Show each image found by a Google search, inline in kitty, iTerm2 and sixel terminals, and ask before using it, like --review for search results only.")
+	flags.StringVar(imageProtocol, "image-protocol", "", "Protocol to show images in the terminal: kitty, iterm, sixel or none. Detected from the terminal by default, except sixel.")
+	flags.StringVar(reportPath, "report", "", "Save what happened to each image to this file, as CSV if it ends in .csv and as JSON otherwise.")
+	flags.StringVar(missingCsvPath, "missing-csv", "", "Save the images not found to this CSV file, with the app id, name, asset type and sources tried, to look for them by hand.")
+	flags.BoolVar(resume, "resume", false, "Skip the games done by the last run, if it was cancelled or killed before the end.")
+	flags.StringVar(forceRefresh, "force-refresh", "", "Download again the images we installed before, to pick up better art: all, or comma separated asset types and sources (download, search, collage, generated), like 'portrait,search'. Images you had before steamgrid are kept.")
+	flags.BoolVar(fullRun, "full", false, "Do every game again, even the ones whose images, categories and options didn't change since the last run.")
+	flags.BoolVar(notify, "notify", false, "Show a desktop notification with the results when the run is done, handy with watch and service.")
+	flags.BoolVar(noSearch, "no-search", false, "Never fall back to a Google search, so only official images are used and the rest are reported as not found.")
+	flags.BoolVar(dryRun, "dry-run", false, "Find and prepare every image, but only print the files that would be written in the Steam folder.")
+	flags.BoolVar(categorize, "categorize", false, "Assign Steam categories from the rules in 'categories.ini' and save them to sharedconfig.vdf.")
+	flags.BoolVar(genres, "genres", false, "Fetch game genres from the Steam store and use them as extra overlay names.")
+	flags.StringVar(jpegQuality, "jpeg-quality", "90", "Quality from 1 to 100 of the JPEG images we encode, when applying overlays or generating banners. Set it per asset type with a list like '90,hero:85' or 'banner:95,portrait:90'.")
+	flags.StringVar(jpegSubsampling, "jpeg-subsampling", "420", "Chroma subsampling of the JPEG images we encode: 420, 422, 444 to keep colored text sharp, or gray. Set it per asset type like --jpeg-quality, e.g. '420,portrait:444'.")
+	flags.StringVar(jpegEncoder, "jpeg-encoder", "std", "JPEG encoder: std, or turbo for libjpeg-turbo, faster and with smaller files, when steamgrid is built with '-tags turbojpeg'.")
+	flags.StringVar(apiKey, "api-key", "", "Steam Web API key, from https://steamcommunity.com/dev/apikey, needed by some badges.")
+	flags.BoolVar(achievementBadges, "achievement-badges", false, "Draw the percentage of achievements unlocked on each game. Needs --api-key.")
+	flags.BoolVar(howLongBadges, "howlongtobeat-badges", false, "Draw the hours to beat the main story, from HowLongToBeat, on each game.")
+	flags.BoolVar(controllerBadges, "controller-badges", false, "Draw a gamepad on games with full (green) or partial (yellow) controller support.")
+	flags.BoolVar(vrBadges, "vr-badges", false, "Draw a badge on games that support or require VR.")
+	flags.StringVar(multiplayerBadgeList, "multiplayer-badges", "", "Comma separated kinds of multiplayer badges to draw: coop, local, pvp.")
+	flags.BoolVar(yearBadges, "year-badges", false, "Draw the release year on each game.")
+	flags.BoolVar(protonDbBadges, "protondb-badges", runtime.GOOS == "linux", "Draw the ProtonDB tier, like 'GOLD', on each game. On by default on Linux.")
+	flags.BoolVar(reviewBadges, "review-badges", false, "Draw the percentage of positive store reviews on each game.")
+	flags.BoolVar(playtimeBadges, "playtime-badges", false, "Draw the time played, like '120h', on each game.")
+	flags.BoolVar(includeHidden, "include-hidden", false, "Also process games hidden in Steam.")
+	flags.BoolVar(favoritesFirst, "favorites-first", false, "Process favorite games before the others.")
+	flags.IntVar(concurrency, "concurrency", 4, "How many games to download at the same time. Their overlays are drawn on every CPU core. Reviewing images does one game at a time.")
+	flags.BoolVar(collages, "collages", false, "Build banners from store screenshots for games without images.")
+	flags.BoolVar(placeholders, "placeholders", false, "Generate a banner and a portrait with the game name for games without images anywhere.")
+	flags.StringVar(sourceOrder, "source-order", "search,collage,placeholder", "Order of the sources tried for images Steam doesn't have, after the official ones: search, collage and placeholder, comma separated. Collages and placeholders still need --collages and --placeholders.")
+	flags.BoolVar(prefetchWishlist, "prefetch-wishlist", false, "Only download the images of wishlisted games, so they are ready offline when bought.")
+	flags.BoolVar(compat, "compat", false, "Fetch Steam Deck and ProtonDB ratings and use them as extra overlay names, like 'deck verified' or 'protondb gold'.")
+	flags.StringVar(steamRunning, "steam-running", "ask", "What to do when Steam is running, since it may ignore the new images until restarted: ask, warn, close, restart (close it and start it again after the run) or ignore. Without a console, ask only warns.")
+	flags.StringVar(steamCloud, "steam-cloud", "ask", "What to do when Steam Cloud may undo the changes, because it's syncing or has newer settings from another computer: ask, warn, wait (until Steam is closed and synced) or ignore. Without a console, ask only warns.")
+	addVersionFlags(flags)
+	flags.StringVar(customImages, "custom-images", "skip", "What to do with images changed by hand, or with Steam's Set Custom Artwork, since the last run: skip them, adopt them as the new originals and draw the overlays on them, or overwrite them.")
+	flags.BoolVar(noBackup, "no-backup", false, "Never back up the images replaced, nor keep their versions; what's overwritten is only logged, and the run can't be undone. The next run draws the overlays on top of the previous ones, so only for your own backup strategy.")
+	flags.BoolVar(noUpdateCheck, "no-update-check", false, "Don't check once a day for a new release and for image sources that stopped working.")
+}
+
+// Adds the badges enabled by the command line options to the game. Failing
+// to load a badge is not fatal, the game just goes without it, with a note
+// in the output of the game.
+func loadBadges(user User, game *Game, output io.Writer) {
+	var details *StoreDetails
+	if *controllerBadges || *vrBadges || *multiplayerBadgeList != "" || *yearBadges {
+		var err error
+		details, err = loadStoreDetails(game)
+		if err != nil {
+			fmt.Fprint(output, tr(" (failed to load store details: %v)", err.Error()))
+		}
+	}
+
+	if *playtimeBadges {
+		if badge, ok := playtimeBadge(game); ok {
+			game.Badges = append(game.Badges, badge)
+		}
+	}
+
+	if *achievementBadges {
+		progress, err := GetAchievements(*apiKey, user, game)
+		if err != nil {
+			fmt.Fprint(output, tr(" (failed to load achievements: %v)", err.Error()))
+		} else if badge, ok := achievementBadge(progress); ok {
+			game.Badges = append(game.Badges, badge)
+		}
+	}
+
+	if *reviewBadges {
+		summary, err := GetReviewSummary(game)
+		if err != nil {
+			fmt.Fprint(output, tr(" (failed to load reviews: %v)", err.Error()))
+		} else if badge, ok := reviewBadge(summary); ok {
+			game.Badges = append(game.Badges, badge)
+		}
+	}
+
+	if *howLongBadges {
+		seconds, err := GetHowLongToBeat(game)
+		if err != nil {
+			fmt.Fprint(output, tr(" (failed to load HowLongToBeat: %v)", err.Error()))
+		} else if badge, ok := howLongToBeatBadge(seconds); ok {
+			game.Badges = append(game.Badges, badge)
+		}
+	}
+
+	if *controllerBadges {
+		if badge, ok := controllerBadge(details); ok {
+			game.Badges = append(game.Badges, badge)
+		}
+	}
+
+	if *vrBadges {
+		if badge, ok := vrBadge(details); ok {
+			game.Badges = append(game.Badges, badge)
+		}
+	}
+
+	if *multiplayerBadgeList != "" {
+		game.Badges = append(game.Badges, multiplayerBadges(details, splitList(*multiplayerBadgeList))...)
+	}
+
+	if *yearBadges {
+		if badge, ok := yearBadge(details); ok {
+			game.Badges = append(game.Badges, badge)
+		}
+	}
+
+	if *protonDbBadges {
+		tier, err := GetProtonDbTier(game)
+		if err != nil {
+			fmt.Fprint(output, tr(" (failed to load ProtonDB tier: %v)", err.Error()))
+		} else if badge, ok := protonDbBadge(tier); ok {
+			game.Badges = append(game.Badges, badge)
+		}
+	}
+}
+
+// Returns true if nobody is looking at a console window we opened, so we
+// must never wait for a key press: with --headless, when the input is not a
+// terminal (scheduled tasks, pipes), or on Linux and BSD without a graphical
+// session, like over SSH.
+func isHeadless() bool {
+	if *headless {
+		return true
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return true
+	}
+	if runtime.GOOS != "windows" && runtime.GOOS != "darwin" {
+		return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+	}
+	return false
+}
+
+// Keeps the console window open until the user presses enter, so the report
+// can be read when the program was started with a double click.
+func waitForEnter() {
+	if !isHeadless() {
+		stdinReader.ReadBytes('\n')
+	}
+}
+
+// Exit codes, so scripts can tell what happened without reading the
+// output. Wrong options exit with 2, from the flag package.
+const (
+	exitOk                 = 0
+	exitFatal              = 1
+	exitUsage              = 2
+	exitMissingImages      = 3
+	exitProfileUnreachable = 4
+	exitCancelled          = 5
+	exitImageErrors        = 6
+)
+
 // Prints an error and quits.
 func errorAndExit(err error) {
+	activeTerminalUI.Stop()
+	activeTrayIcon.Close()
+	logEvent(LogError, "fatal", LogFields{"error": err.Error()}, "%v", err.Error())
 	fmt.Println(err.Error())
-	bufio.NewReader(os.Stdin).ReadBytes('\n')
-	os.Exit(0)
+	waitForEnter()
+	os.Exit(exitFatal)
 }
 
 func main() {
-	http.DefaultTransport.(*http.Transport).ResponseHeaderTimeout = time.Second * 10
-	startApplication()
+	configureHttpTransport()
+	runCommand(os.Args[1:])
+}
+
+// Loads the overlays for each asset type, with the options given on the
+// command line applied on top of each overlays.ini.
+func loadOverlaySets(assets []*AssetType) map[*AssetType]*OverlaySet {
+	fmt.Println(tr("Loading overlays..."))
+	overlaySets := make(map[*AssetType]*OverlaySet)
+	for _, asset := range assets {
+		overlays, err := LoadOverlays(getAssetOverlaysDir(*overlaysPath, asset), asset)
+		if err != nil {
+			errorAndExit(err)
+		}
+		if *singleOverlay {
+			overlays.Single = true
+		}
+		if *uninstalledSaturation >= 0 {
+			if *uninstalledSaturation > 1 {
+				errorAndExit(errors.New(tr("The saturation of uninstalled games must be between 0 and 1.")))
+			}
+			overlays.UninstalledSaturation = *uninstalledSaturation
+		}
+		overlaySets[asset] = overlays
+	}
+	return overlaySets
 }
 
-func startApplication() {
-	fmt.Println("Loading overlays...")
-	overlays, err := LoadOverlays(filepath.Join(filepath.Dir(os.Args[0]), "overlays by category"))
+// Finds the Steam installation, from the command line arguments or
+// automatically, and its users, keeping only the ones given with --user.
+func loadUsers(args []string) (installationDir string, users []User) {
+	if _, err := parseGridPermissions(); err != nil {
+		errorAndExit(err)
+	}
+	fmt.Println(tr("Looking for Steam directory..."))
+	installationDir, err := GetSteamInstallation(args)
 	if err != nil {
 		errorAndExit(err)
 	}
-	if len(overlays) == 0 {
-		// I'm trying to use a message box here, but for some reason the
-		// message appears twice and there's an error a closed channel.
-		fmt.Println("No category overlays found. You can put overlay images in the folder 'overlays by category', where the filename is the game category.\n\nContinuing without overlays...")
+
+	fmt.Println(tr("Loading users..."))
+	users, err = GetUsers(installationDir)
+	if err != nil {
+		errorAndExit(err)
+	}
+	if len(users) == 0 {
+		errorAndExit(errors.New(tr("No users found at Steam/userdata. Have you used Steam before in this computer?")))
+	}
+	users, err = FilterUsers(users, *userFilter)
+	if err != nil {
+		errorAndExit(err)
+	}
+	for _, user := range users {
+		nMoved, err := MigrateBackups(user)
+		if err != nil {
+			fmt.Print(tr("Failed to move the backups of %v out of the grid folder: %v\n", user.Name, err.Error()))
+		} else if nMoved > 0 {
+			fmt.Print(tr("Moved %v backups of %v out of the grid folder, to %v.\n", nMoved, user.Name, getBackupsDir(user)))
+		}
+	}
+	// Nobody can answer in the terminal UI or without a console.
+	if *userFilter == "" && len(users) > 1 && !isHeadless() && !*terminalUI {
+		users = ChooseUsers(users)
+	}
+	return installationDir, users
+}
+
+// Returns the flag set of the download command.
+func newDownloadFlags() *flag.FlagSet {
+	flags := newCommandFlags("download")
+	addDownloadFlags(flags)
+	return flags
+}
+
+// Downloads, backs up and overlays the images of every game, which is what
+// running without a command does.
+func startApplication(args []string) {
+	runDownloadCommand(newDownloadFlags(), args)
+}
+
+// Returns the flag set of the retry command. Every asset type is retried
+// by default, since only the ones that failed are done anyway.
+func newRetryFlags() *flag.FlagSet {
+	flags := newCommandFlags("retry")
+	addDownloadFlags(flags)
+	assetsFlag := flags.Lookup("assets")
+	assetsFlag.Value.Set("all")
+	assetsFlag.DefValue = "all"
+	return flags
+}
+
+// Does again only the images that failed or were not found in the last
+// runs.
+func runRetry(args []string) {
+	*retryFailed = true
+	runDownloadCommand(newRetryFlags(), args)
+}
+
+// Parses the options of a download-like command and runs it, in the
+// console or the terminal UI, then exits with the code for the results.
+func runDownloadCommand(flags *flag.FlagSet, args []string) {
+	parseCommandFlags(flags, args)
+	checkDownloadFlags()
+	checkForUpdates()
+	startDownloadRun(flags.Args())
+}
+
+// Runs a download with the options already parsed and checked, in the
+// console or the terminal UI, then exits with the code for the results.
+func startDownloadRun(steamArgs []string) {
+	// The terminal UI handles Ctrl+C itself.
+	var ui RunProgress
+	if *terminalUI {
+		terminal, err := StartTerminalUI()
+		if err != nil {
+			errorAndExit(err)
+		}
+		ui = terminal
+	} else {
+		ui = newConsoleProgress()
+	}
+
+	report := runDownload(steamArgs, ui)
+	if !isHeadless() {
+		fmt.Println(tr("\nPress enter to close."))
+	}
+	waitForEnter()
+	os.Exit(report.ExitCode())
+}
+
+// Receives the progress of a download run, to show it and to pause or
+// cancel the run between games.
+type RunProgress interface {
+	// Starts the list of games of a new user.
+	StartUser(name string, nGames int)
+	// Sets the state of an image, like "downloading" or "not found".
+	SetState(game *Game, asset *AssetType, state string)
+	// Shows the overall progress, after each image.
+	SetStats(stats RunStats)
+	// Counts a game as done, and returns false if the run should stop.
+	FinishGame() bool
+	// Called before the report is printed.
+	Stop()
+}
+
+// Stops with an error if the options of the download command don't make
+// sense together.
+func checkDownloadFlags() {
+	if err := checkJpegOptions(); err != nil {
+		errorAndExit(err)
+	}
+	if err := checkJpegEncoder(); err != nil {
+		errorAndExit(err)
+	}
+	if err := checkSourceOrder(); err != nil {
+		errorAndExit(err)
 	}
 
-	fmt.Println("Looking for Steam directory...")
-	installationDir, err := GetSteamInstallation()
+	for _, kind := range splitList(*multiplayerBadgeList) {
+		if !containsString(multiplayerBadgeNames(), kind) {
+			errorAndExit(errors.New(tr("Unknown multiplayer badge '%v', expected some of: %v", kind, strings.Join(multiplayerBadgeNames(), ", "))))
+		}
+	}
+	if *achievementBadges && *apiKey == "" {
+		errorAndExit(errors.New(tr("Achievement badges need a Steam Web API key, given with --api-key. You can get one at https://steamcommunity.com/dev/apikey")))
+	}
+	switch *imageProtocol {
+	case "", "kitty", "iterm", "sixel", "none":
+	default:
+		errorAndExit(errors.New(tr("Unknown image protocol '%v', expected kitty, iterm, sixel or none.", *imageProtocol)))
+	}
+	switch *customImages {
+	case "skip", "adopt", "overwrite":
+	default:
+		errorAndExit(errors.New(tr("Unknown --custom-images '%v', expected skip, adopt or overwrite.", *customImages)))
+	}
+	for _, item := range splitList(*forceRefresh) {
+		if item != "all" && !containsString(refreshSources, item) && !containsString(getAssetTypeNames(), item) {
+			errorAndExit(errors.New(tr("Unknown --force-refresh '%v', expected all, asset types or sources: %v", item, strings.Join(refreshSources, ", "))))
+		}
+	}
+	switch *steamRunning {
+	case "ask", "warn", "close", "restart", "ignore":
+	default:
+		errorAndExit(errors.New(tr("Unknown --steam-running '%v', expected ask, warn, close, restart or ignore.", *steamRunning)))
+	}
+	switch *steamCloud {
+	case "ask", "warn", "wait", "ignore":
+	default:
+		errorAndExit(errors.New(tr("Unknown --steam-cloud '%v', expected ask, warn, wait or ignore.", *steamCloud)))
+	}
+	if (*reviewAll || *reviewSearch) && *terminalUI {
+		errorAndExit(errors.New(tr("The terminal UI can't ask to review images, use either --tui or the review.")))
+	}
+	if (*reviewAll || *reviewSearch) && isHeadless() {
+		fmt.Println(tr("Nobody to review the images, they are used as they are."))
+		*reviewAll, *reviewSearch = false, false
+	}
+	if *concurrency < 1 {
+		errorAndExit(errors.New(tr("The concurrency must be at least 1.")))
+	}
+	// The questions of the review can't be asked for several games at once.
+	if *reviewAll || *reviewSearch {
+		*concurrency = 1
+	}
+}
+
+// Image of a game found by the first stage of a run, kept aside while the
+// next asset types are looked for, since the game holds one at a time.
+type foundImage struct {
+	asset      *AssetType
+	inputs     string
+	overridden bool
+	// Bytes downloaded for it, for the stats.
+	nBytes int64
+
+	path   string
+	bytes  []byte
+	file   string
+	source string
+	url    string
+}
+
+// Takes the image found for an asset type out of the game.
+func saveFoundImage(game *Game, asset *AssetType, inputs string, overridden bool, nBytes int64) *foundImage {
+	found := &foundImage{asset, inputs, overridden, nBytes, game.ImagePath, game.ImageBytes, game.ImageFile, game.ImageSource, game.ImageUrl}
+	game.ClearImage()
+	return found
+}
+
+// Puts the image back in the game, to draw the overlays and write it.
+func (found *foundImage) restore(game *Game) {
+	game.ImagePath, game.ImageBytes, game.ImageFile, game.ImageSource, game.ImageUrl = found.path, found.bytes, found.file, found.source, found.url
+	found.bytes = nil
+}
+
+// Downloads, backs up and overlays the images of every game and prints a
+// report, with the options already parsed and checked.
+func runDownload(steamArgs []string, ui RunProgress) *RunReport {
+	assets, err := GetAssetTypes(*assetNames)
 	if err != nil {
 		errorAndExit(err)
 	}
 
-	fmt.Println("Loading users...")
-	users, err := GetUsers(installationDir)
+	overlaySets := loadOverlaySets(assets)
+	nOverlays := 0
+	for _, overlays := range overlaySets {
+		nOverlays += len(overlays.Overlays)
+	}
+	if nOverlays == 0 {
+		// I'm trying to use a message box here, but for some reason the
+		// message appears twice and there's an error a closed channel.
+		fmt.Println(tr("No category overlays found. You can put overlay images in the folder 'overlays by category', where the filename is the game category.\n\nContinuing without overlays..."))
+	}
+
+	// The templates are optional, without them we draw a gradient:
+	// "placeholder.png" for banners, "placeholder portrait.png" for portraits.
+	placeholderTemplates := make(map[*AssetType]image.Image)
+	for _, asset := range assetTypes {
+		if !*placeholders || !hasPlaceholders(asset) {
+			continue
+		}
+		name := "placeholder " + asset.Name + ".png"
+		if asset == bannerAsset {
+			name = "placeholder.png"
+		}
+		if template, err := loadImage(filepath.Join(filepath.Dir(os.Args[0]), name)); err == nil {
+			placeholderTemplates[asset] = template
+		}
+	}
+
+	overrides, err := LoadOverrides(filepath.Join(filepath.Dir(os.Args[0]), "overrides.ini"))
 	if err != nil {
 		errorAndExit(err)
 	}
-	if len(users) == 0 {
-		errorAndExit(errors.New("No users found at Steam/userdata. Have you used Steam before in this computer?"))
+
+	// Every user shares the images downloaded, but with --force-refresh
+	// not the ones of earlier runs.
+	pruneDownloadCache()
+	if *forceRefresh != "" {
+		downloadCacheSince = time.Now()
 	}
 
-	nOverlaysApplied := 0
-	nDownloaded := 0
-	notFounds := make([]*Game, 0)
-	searchFounds := make([]*Game, 0)
-	errors := make([]*Game, 0)
-	errorMessages := make([]string, 0)
+	var categoryRules []CategoryRule
+	if *categorize {
+		categoryRules, err = LoadCategoryRules(filepath.Join(filepath.Dir(os.Args[0]), "categories.ini"))
+		if err != nil {
+			errorAndExit(err)
+		}
+	}
 
+	installationDir, users := loadUsers(steamArgs)
+	installed := GetInstalledGames(installationDir)
+
+	if *prefetchWishlist {
+		for _, user := range users {
+			fmt.Println(tr("Pre-fetching wishlist images for %v", user.Name))
+			nFetched, err := PrefetchWishlist(user)
+			if err != nil {
+				errorAndExit(err)
+			}
+			fmt.Print(tr("%v new images cached.\n", nFetched))
+		}
+		return &RunReport{}
+	}
+
+	restartSteam := false
+	if !*dryRun {
+		restartSteam = prepareSteamClient(installationDir)
+		prepareSteamCloud(users)
+	}
+	startJournal(strings.Join(os.Args[1:], " "))
+
+	report := &RunReport{DryRun: *dryRun, ProfileErrors: make([]string, 0), Images: make([]ImageResult, 0)}
+	cancelled := false
+
+	// Every user is loaded first, so the progress covers the whole run.
+	type userRun struct {
+		user     User
+		games    map[string]*Game
+		progress *SavedProgress
+		failures *SavedFailures
+		state    *RunState
+	}
+	runs := make([]userRun, 0, len(users))
+	stats := RunStats{}
 	for _, user := range users {
-		fmt.Println("Loading games for " + user.Name)
+		fmt.Println(tr("Loading games for %v", user.Name))
+
+		// Without the profile we still have the games found locally.
+		games, err := GetGames(user)
+		if err != nil {
+			report.ProfileErrors = append(report.ProfileErrors, user.Name+": "+err.Error())
+			logEvent(LogWarning, "profile_error", LogFields{"user": user.Name, "error": err.Error()}, "Failed to load the profile of %v: %v", user.Name, err.Error())
+			fmt.Print(tr("Failed to load the profile of %v, continuing with the games found locally: %v\n", user.Name, err.Error()))
+		}
+		FilterGames(games, *gameFilter)
+		if nProtected := RemoveProtectedGames(games); nProtected > 0 {
+			fmt.Print(tr("%v protected games are left alone.\n", nProtected))
+		}
+		for id, game := range games {
+			if game.Hidden && !*includeHidden {
+				delete(games, id)
+				continue
+			}
+			game.Installed = game.Installed || installed[id]
+			if !game.Installed {
+				// Lets users have a "not installed" ribbon overlay.
+				game.VirtualTags = append(game.VirtualTags, "not installed")
+			}
+		}
 
-		games := GetGames(user)
+		if *genres {
+			fmt.Println(tr("Loading store genres..."))
+			for _, game := range games {
+				details, err := GetStoreDetails(game)
+				if err != nil {
+					fmt.Print(tr("Failed to load store genres for %v: %v\n", game.Id, err.Error()))
+					continue
+				}
+				game.Store = details
+				if details != nil {
+					game.VirtualTags = append(game.VirtualTags, details.Genres...)
+				}
+			}
+		}
 
-		i := 0
-		for _, game := range games {
-			i += 1
+		if *compat {
+			fmt.Println(tr("Loading compatibility ratings..."))
+			for _, game := range games {
+				compatibility, err := GetCompatibility(game)
+				if err != nil {
+					fmt.Print(tr("Failed to load compatibility for %v: %v\n", game.Id, err.Error()))
+					continue
+				}
+				game.VirtualTags = append(game.VirtualTags, compatibility.Tags()...)
+			}
+		}
 
-			var name string
-			if game.Name != "" {
-				name = game.Name
+		if *categorize {
+			nCategorized, err := AutoCategorize(user, games, categoryRules)
+			if err != nil {
+				errorAndExit(err)
+			}
+			fmt.Print(tr("%v games received new categories.\n", nCategorized))
+		}
+
+		// Progress is saved after each game, unless nothing is written.
+		progress := &SavedProgress{Done: []string{}}
+		if saved := loadSavedProgress(user); saved != nil {
+			if *resume {
+				for _, id := range saved.Done {
+					delete(games, id)
+				}
+				progress.Done = saved.Done
+				fmt.Print(tr("Resuming the last run, %v of %v games were done already.\n", len(saved.Done), saved.Total))
 			} else {
-				name = "unknown game with id " + game.Id
+				fmt.Print(tr("The last run stopped after %v of %v games, add --resume to skip them.\n", len(saved.Done), saved.Total))
 			}
-			fmt.Printf("Processing %v (%v/%v)", name, i, len(games))
+		}
+		progress.Total = len(progress.Done) + len(games)
 
-			if game.ImageBytes == nil {
-				err := DownloadImage(game)
-				if err != nil {
-					errorAndExit(err)
+		failures := loadSavedFailures(user)
+		nImages := len(games) * len(assets)
+		if *retryFailed {
+			nImages = 0
+			for id := range games {
+				for _, asset := range assets {
+					if failures.Has(id, asset) {
+						nImages++
+					}
+				}
+				if _, ok := failures.Images[id]; !ok {
+					delete(games, id)
+				}
+			}
+			fmt.Print(tr("Retrying %v images of %v games that failed before.\n", nImages, len(games)))
+		}
+
+		runs = append(runs, userRun{user, games, progress, failures, loadRunState(user)})
+		stats.TotalGames += len(games)
+		stats.TotalImages += nImages
+	}
+
+	// Stats and the UI are shared by the games processed at the same time.
+	var mutex sync.Mutex
+	setState := func(game *Game, asset *AssetType, state string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		ui.SetState(game, asset, state)
+	}
+	// Counts an image as done, with its last state and the bytes downloaded
+	// for it.
+	finishImage := func(game *Game, asset *AssetType, state string, nBytes int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if state != "" {
+			ui.SetState(game, asset, state)
+		}
+		stats.DoneImages++
+		stats.Bytes += nBytes
+		ui.SetStats(stats)
+	}
+
+	// A game being processed, with its results and output kept apart until
+	// the games before it are done, so the report and the console follow the
+	// order of the games whatever finishes first.
+	type gameJob struct {
+		run    userRun
+		game   *Game
+		report *RunReport
+		output io.Writer
+		buffer *bytes.Buffer
+		// Images found, waiting for the overlays.
+		found []*foundImage
+	}
+	// Games are fetched and composited in separate stages, so the review
+	// questions would show up in the middle of the output of other games.
+	reviewing := *reviewAll || *reviewSearch
+	newGameJob := func(run userRun, game *Game) *gameJob {
+		job := &gameJob{run: run, game: game, report: &RunReport{Images: make([]ImageResult, 0)}, output: os.Stdout}
+		if !reviewing {
+			job.buffer = new(bytes.Buffer)
+			job.output = job.buffer
+		}
+		// So what's written for the game, like the dry-run lines, goes with
+		// the rest of its output.
+		game.Output = job.output
+		return job
+	}
+
+	// Records an image that failed with an error and moves on, so one bad
+	// download doesn't stop the whole run.
+	skipImage := func(job *gameJob, asset *AssetType, step string, err error) {
+		user, game := job.run.user, job.game
+		err = newGameError(step, game, asset, err)
+		job.report.Add(user, game, asset, ImageFailed, false, err)
+		logEvent(LogWarning, "error", gameLogFields(user, game, asset, LogFields{"step": step, "error": err.Error()}), "%v", err.Error())
+		fmt.Fprint(job.output, tr(" failed: %v\n", err.Error()))
+		finishImage(game, asset, "failed: "+err.Error(), 0)
+	}
+
+	settings := getRunSettings()
+
+	// Tries the sources after the official images, in the --source-order,
+	// until one has an image for the game. Returns the step that failed, if
+	// one did.
+	findOtherImage := func(job *gameJob, asset *AssetType, candidates *ImageCandidates) (string, error) {
+		game, report, output := job.game, job.report, job.output
+		for _, source := range getSourceOrder() {
+			if game.HasImage() {
+				break
+			}
+			switch source {
+			case "search":
+				if _, err := candidates.Search(); err != nil {
+					return "download", err
+				}
+				if game.HasImage() && shouldReview(game) {
+					if err := ReviewImage(game, asset, candidates); err != nil {
+						return "review", err
+					}
+				}
+			case "collage":
+				// Collages are drawn at banner size, placeholders also as
+				// portraits.
+				if *collages && asset == bannerAsset {
+					if err := GenerateCollage(game); err != nil {
+						fmt.Fprint(output, tr(" (failed to build collage: %v)", err.Error()))
+					}
+					if game.HasImage() {
+						report.Collages++
+					}
+				}
+			case "placeholder":
+				if *placeholders && hasPlaceholders(asset) {
+					if err := GeneratePlaceholder(game, asset, placeholderTemplates[asset]); err != nil {
+						return "generate", err
+					}
+					if game.HasImage() {
+						report.Generated++
+					}
+				}
+			}
+		}
+		return "", nil
+	}
+
+	// Finds every image of a game, downloading the ones missing, the first
+	// stage of each game. Runs for several games at the same time, so it
+	// only touches the game, its job and what's behind the mutexes.
+	fetchImages := func(job *gameJob, i int, total int) {
+		user, game, failures, state, report, output := job.run.user, job.game, job.run.failures, job.run.state, job.report, job.output
+
+		var name string
+		if game.Name != "" {
+			name = game.Name
+		} else {
+			name = tr("unknown game with id %v", game.Id)
+		}
+
+		for _, asset := range assets {
+			if *retryFailed {
+				mutex.Lock()
+				retry := failures.Has(game.Id, asset)
+				mutex.Unlock()
+				if !retry {
+					continue
 				}
-				if game.ImageBytes != nil {
-					nDownloaded++
+			}
+			// Retries and refreshes are asked for, so they are always done
+			// again.
+			inputs := imageInputs(settings, game, asset, overrides)
+			forced := isForcedRefresh(user, game, asset)
+			if entry, ok := state.Unchanged(user, game, asset, inputs); ok && !*fullRun && !*retryFailed && !forced {
+				game.ImageSource = entry.Source
+				if entry.Hash == "" {
+					report.AddNotFound(user, game, asset, entry.Tried)
 				} else {
-					notFounds = append(notFounds, game)
-					fmt.Printf(" not found\n")
-					// Game has no image, skip it.
+					report.Add(user, game, asset, ImageUnchanged, false, nil)
+				}
+				finishImage(game, asset, "unchanged", 0)
+				continue
+			}
+			if asset == bannerAsset {
+				fmt.Fprint(output, tr("Processing %v (%v/%v)", name, i, total))
+			} else {
+				fmt.Fprint(output, tr("Processing %v %v (%v/%v)", name, asset.Name, i, total))
+			}
+
+			setState(game, asset, "loading")
+			LoadGridImage(user, game, asset)
+			overridden, err := ApplyOverride(game, asset, overrides)
+			if err != nil {
+				skipImage(job, asset, "load the override of", err)
+				continue
+			}
+			if !overridden && game.ImageSource == "backup" && *customImages != "overwrite" && IsChangedByHand(user, game, asset) {
+				if *customImages == "skip" {
+					report.Add(user, game, asset, ImageChangedByHand, false, nil)
+					logEvent(LogInfo, "changed_by_hand", gameLogFields(user, game, asset, nil), "The %v of %v (id %v) was changed by hand, leaving it alone", asset.Name, game.Name, game.Id)
+					fmt.Fprint(output, tr(" changed by hand, left alone\n"))
+					finishImage(game, asset, "changed by hand", 0)
+					continue
+				}
+				// The new image becomes the original.
+				game.ImagePath, game.ImageBytes = loadCurrentGridImage(user, game, asset)
+				game.ImageSource = "manual customization"
+			}
+			refreshed := !overridden && game.ImageSource == "backup" && forced
+			if refreshed {
+				// Falls back to the current one if nothing is found.
+				game.ClearImage()
+				game.ImageSource = ""
+			}
+
+			var nBytes int64
+			if !game.HasImage() {
+				setState(game, asset, "downloading")
+				candidates, err := DownloadImage(game, asset)
+				if err != nil {
+					skipImage(job, asset, "download", err)
 					continue
 				}
-				if game.ImageSource == "search" {
-					searchFounds = append(searchFounds, game)
+				if game.HasImage() && shouldReview(game) {
+					err = ReviewImage(game, asset, candidates)
+					if err != nil {
+						skipImage(job, asset, "review", err)
+						continue
+					}
+				}
+				if step, err := findOtherImage(job, asset, candidates); err != nil {
+					skipImage(job, asset, step, err)
+					continue
+				}
+				if !game.HasImage() && refreshed {
+					LoadGridImage(user, game, asset)
+					fmt.Fprint(output, tr(" (nothing found, keeping the current one)"))
+				} else if game.HasImage() && game.ImageSource != "generated" && game.ImageSource != "collage" {
+					report.Downloaded++
+					if game.ImageSource != "cache" {
+						nBytes = game.ImageSize()
+					}
+				} else if !game.HasImage() {
+					tried := candidates.Tried()
+					if *collages && asset == bannerAsset {
+						tried = append(tried, "collage")
+					}
+					report.AddNotFound(user, game, asset, tried)
+					state.RecordNotFound(game, asset, inputs, tried)
+					logEvent(LogWarning, "not_found", gameLogFields(user, game, asset, nil), "No %v found for %v (id %v)", asset.Name, game.Name, game.Id)
+					fmt.Fprint(output, tr(" not found\n"))
+					finishImage(game, asset, "not found", 0)
+					// Game has no image, skip it.
+					continue
 				}
 			}
 
-			fmt.Printf(" found from %v\n", game.ImageSource)
+			fmt.Fprint(output, tr(" found from %v\n", game.ImageSource))
+			logEvent(LogInfo, "image", gameLogFields(user, game, asset, LogFields{"source": game.ImageSource}), "%v of %v (id %v) found from %v", asset.Name, game.Name, game.Id, game.ImageSource)
+			job.found = append(job.found, saveFoundImage(game, asset, inputs, overridden, nBytes))
+		}
+	}
 
-			err = BackupGame(game)
-			if err != nil {
-				errorAndExit(err)
+	// Backs up, overlays and writes the images found for a game, the second
+	// stage, which is mostly decoding and encoding images, so it runs in as
+	// many goroutines as there are CPUs while others download.
+	compositeImages := func(job *gameJob) {
+		user, game, state, report, output := job.run.user, job.game, job.run.state, job.report, job.output
+		badgesLoaded := false
+		for _, found := range job.found {
+			asset, inputs, overridden, nBytes := found.asset, found.inputs, found.overridden, found.nBytes
+			found.restore(game)
+
+			// Overrides live outside the grid folder, so there's nothing to
+			// back up, and backing them up would replace the real original.
+			if !overridden {
+				if err := BackupGame(user, game, asset); err != nil {
+					// Going on would lose the original.
+					skipImage(job, asset, "back up", err)
+					continue
+				}
+				if err := RecordBackupSource(user, game, asset); err != nil {
+					fmt.Fprint(output, tr(" (failed to remember where the backup came from: %v)", err.Error()))
+				}
+			}
+
+			if !badgesLoaded {
+				loadBadges(user, game, output)
+				badgesLoaded = true
 			}
 
-			applied, err := ApplyOverlay(game, overlays)
+			setState(game, asset, "overlaying")
+			status, uiState := ImageInstalled, ""
+			applied, err := ApplyOverlay(game, overlaySets[asset])
+			err = newGameError("overlay", game, asset, err)
+			imageErr := err
 			if err != nil {
-				print(err.Error(), "\n")
-				errors = append(errors, game)
-				errorMessages = append(errorMessages, err.Error())
+				status, uiState = ImageOverlayFailed, "failed: "+err.Error()
+				logEvent(LogWarning, "error", gameLogFields(user, game, asset, LogFields{"step": "overlay", "error": err.Error()}), "%v", err.Error())
+				fmt.Fprint(output, tr(" failed: %v\n", err.Error()))
 			}
 			if applied {
-				nOverlaysApplied++
+				report.OverlaysApplied++
 			}
 
-			err = ioutil.WriteFile(game.ImagePath, game.ImageBytes, 0666)
+			err = newGameError("convert", game, asset, FixImageFormat(game))
 			if err != nil {
-				fmt.Printf("Failed to write image for %v because: %v\n", game.Name, err.Error())
+				if imageErr == nil {
+					imageErr = err
+				}
+				logEvent(LogWarning, "error", gameLogFields(user, game, asset, LogFields{"step": "convert", "error": err.Error()}), "%v", err.Error())
+				fmt.Fprint(output, tr("Failed to convert image for %v because: %v\n", game.Name, err.Error()))
 			}
+
+			if *noBackup && !*dryRun && fileExists(game.ImagePath) {
+				logEvent(LogInfo, "overwrite", gameLogFields(user, game, asset, LogFields{"path": game.ImagePath}), "Overwriting %v without a backup", game.ImagePath)
+			}
+			err = SaveImageVersion(user, game, asset)
+			if err == nil {
+				err = writeGridImage(game)
+			}
+			if err != nil {
+				err = &GameError{"write", game.Id, game.Name, asset.Name, game.ImagePath, err}
+				status, imageErr, uiState = ImageWriteFailed, err, "failed: "+err.Error()
+				logEvent(LogWarning, "error", gameLogFields(user, game, asset, LogFields{"step": "write", "error": err.Error()}), "%v", err.Error())
+				fmt.Fprint(output, tr("Failed to write image for %v because: %v\n", game.Name, err.Error()))
+			} else {
+				if err := RecordInstalledImage(user, game, asset); err != nil {
+					fmt.Fprint(output, tr("Failed to remember the image installed for %v: %v\n", game.Name, err.Error()))
+				}
+				if hash, err := game.ImageHash(); err == nil && status == ImageInstalled {
+					state.Record(game, asset, inputs, hash)
+					uiState = "done: " + game.ImageSource
+				}
+			}
+			report.Add(user, game, asset, status, applied, imageErr)
+			finishImage(game, asset, uiState, nBytes)
 		}
+		// The game stays in the list of the user until the end of the run.
+		game.ClearImage()
+		job.found = nil
 	}
 
-	fmt.Printf("\n\n%v images downloaded and %v overlays applied.\n\n", nDownloaded, nOverlaysApplied)
-	if len(searchFounds) >= 1 {
-		fmt.Printf("%v images were found with a Google search and may not be accurate:\n", len(searchFounds))
-		for _, game := range searchFounds {
-			fmt.Printf("* %v (steam id %v)\n", game.Name, game.Id)
+	// Adds a game processed to the report and the saved progress, in the
+	// order of the games, and returns false if the run should stop.
+	finishGame := func(job *gameJob) bool {
+		user, game, progress, failures, state := job.run.user, job.game, job.run.progress, job.run.failures, job.run.state
+		if job.buffer != nil {
+			os.Stdout.Write(job.buffer.Bytes())
 		}
+		game.Output = nil
+		report.Merge(job.report)
 
-		fmt.Printf("\n\n")
-	}
+		mutex.Lock()
+		stats.DoneGames++
+		ui.SetStats(stats)
+		doneStats := stats
+		mutex.Unlock()
+		logEvent(LogInfo, "game", gameLogFields(user, game, nil, LogFields{"done": doneStats.DoneGames, "total": doneStats.TotalGames}), "Done with %v, %v", game.Name, doneStats)
 
-	if len(notFounds) >= 1 {
-		fmt.Printf("%v images could not be found anywhere:\n", len(notFounds))
-		for _, game := range notFounds {
-			fmt.Printf("- %v (id %v)\n", game.Name, game.Id)
+		if !*dryRun {
+			progress.Done = append(progress.Done, game.Id)
+			if err := saveProgress(user, progress); err != nil {
+				fmt.Print(tr("Failed to save the progress: %v\n", err.Error()))
+			}
+			failed := make([]string, 0)
+			for _, result := range job.report.Images {
+				if result.Status != ImageInstalled && result.Status != ImageChangedByHand && result.Status != ImageUnchanged {
+					failed = append(failed, result.Asset)
+				}
+			}
+			mutex.Lock()
+			failures.Update(game.Id, assets, failed)
+			err := saveFailures(user, failures)
+			mutex.Unlock()
+			if err != nil {
+				fmt.Print(tr("Failed to save the failed images: %v\n", err.Error()))
+			}
+			if err := saveRunState(user, state); err != nil {
+				fmt.Print(tr("Failed to save the state of the images: %v\n", err.Error()))
+			}
 		}
-
-		fmt.Printf("\n\n")
+		return ui.FinishGame()
 	}
 
-	if len(errors) >= 1 {
-		fmt.Printf("%v images were found but had errors and could not be overlaid:\n", len(errors))
-		for i, game := range errors {
-			fmt.Printf("- %v (id %v) (%v)\n", game.Name, game.Id, errorMessages[i])
+	stats.Started = time.Now()
+	report.Started = stats.Started
+	ui.SetStats(stats)
+	for _, run := range runs {
+		ui.StartUser(run.user.Name, len(run.games))
+		sorted := SortGames(run.games, *favoritesFirst)
+		jobs := make([]*gameJob, len(sorted))
+		fetch := func(i int) {
+			job := newGameJob(run, sorted[i])
+			fetchImages(job, i+1, len(sorted))
+			jobs[i] = job
+		}
+		composite := func(i int) {
+			compositeImages(jobs[i])
 		}
+		stages := []pipelineStage{{*concurrency, fetch}, {runtime.GOMAXPROCS(0), composite}}
+		if reviewing {
+			stages = []pipelineStage{{1, func(i int) {
+				fetch(i)
+				composite(i)
+			}}}
+		}
+		runPipeline(len(sorted), stages, func(i int) bool {
+			job := jobs[i]
+			// Done games don't need their images anymore.
+			jobs[i] = nil
+			if !finishGame(job) {
+				cancelled = true
+			}
+			return !cancelled
+		})
+		if cancelled {
+			break
+		}
+		if !*dryRun {
+			if err := clearSavedProgress(run.user); err != nil {
+				fmt.Print(tr("Failed to clear the progress: %v\n", err.Error()))
+			}
+		}
+	}
+	stats.Finished = time.Now()
+	ui.SetStats(stats)
+	ui.Stop()
 
-		fmt.Printf("\n\n")
+	report.Finished, report.Cancelled, report.Bytes = stats.Finished, cancelled, stats.Bytes
+	notFound := len(report.WithStatus(ImageNotFound))
+	failed := len(report.Failed())
+	fmt.Print("\n\n" + report.HumanSummary())
+	fmt.Print(tr("\n%v games in %v, %.1f games/minute, %v downloaded.\n\n", stats.DoneGames, formatDuration(stats.Elapsed()), stats.GamesPerMinute(), formatBytes(stats.Bytes)))
+	logEvent(LogInfo, "finished", LogFields{
+		"downloaded":       report.Downloaded,
+		"overlays_applied": report.OverlaysApplied,
+		"not_found":        notFound,
+		"errors":           failed,
+		"games":            stats.DoneGames,
+		"total_games":      stats.TotalGames,
+		"bytes":            stats.Bytes,
+		"seconds":          stats.Elapsed().Seconds(),
+		"cancelled":        cancelled,
+		"exit_code":        report.ExitCode(),
+	}, "Run finished: %v images downloaded, %v overlays applied, %v not found, %v errors, %v", report.Downloaded, report.OverlaysApplied, notFound, failed, stats)
+	if *reportPath != "" {
+		if err := report.Write(*reportPath); err != nil {
+			fmt.Print(tr("Failed to write the report: %v\n", err.Error()))
+		} else {
+			fmt.Print(tr("Report saved to %v\n\n", *reportPath))
+		}
+	}
+	if *missingCsvPath != "" {
+		if err := report.WriteMissing(*missingCsvPath); err != nil {
+			fmt.Print(tr("Failed to write the list of missing images: %v\n", err.Error()))
+		} else {
+			fmt.Print(tr("%v missing images listed in %v\n\n", notFound, *missingCsvPath))
+		}
 	}
 
-	fmt.Println("Open Steam in grid view to see the results!\n\nPress enter to close.")
+	if restartSteam {
+		fmt.Println(tr("Starting Steam again..."))
+		if err := startSteam(installationDir); err != nil {
+			fmt.Print(tr("Failed to start Steam: %v\n", err.Error()))
+		}
+	}
+	if *dryRun {
+		fmt.Println(tr("This was a dry run, nothing was written in the Steam folder."))
+	} else {
+		fmt.Println(tr("Open Steam in grid view to see the results!"))
+	}
 
-	bufio.NewReader(os.Stdin).ReadBytes('\n')
+	if *notify {
+		message := tr("%v images downloaded and %v overlays applied.", report.Downloaded, report.OverlaysApplied)
+		if notFound > 0 {
+			message += " " + tr("%v images not found.", notFound)
+		}
+		if cancelled {
+			message = tr("Cancelled.") + " " + message
+		}
+		if err := sendNotification("SteamGrid", message); err != nil {
+			fmt.Print(tr("Failed to show the notification: %v\n", err.Error()))
+		}
+	}
+	return report
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Store API with the metadata for a single app. Forcing English keeps genre
+// names stable, since they are used to match overlay file names.
+const storeDetailsUrlFormat = `https://store.steampowered.com/api/appdetails?l=english&appids=%v`
+
+// How long cached store metadata is trusted before being fetched again.
+const storeCacheDuration = time.Hour * 24 * 7
+
+// Bumped whenever StoreDetails gets new fields, so older cached entries are
+// downloaded again instead of silently missing data.
+const storeDetailsVersion = 2
+
+// Subset of the store metadata we care about.
+type StoreDetails struct {
+	Version    int
+	Genres     []string
+	Categories []string
+	// URLs of the screenshot thumbnails.
+	Screenshots []string
+	// Zero if unknown or not released yet.
+	ReleaseYear int
+}
+
+// Format of the store API response, which is keyed by app id.
+type storeResponse map[string]struct {
+	Success bool
+	Data    struct {
+		Genres []struct {
+			Description string
+		}
+		Categories []struct {
+			Description string
+		}
+		Screenshots []struct {
+			PathThumbnail string `json:"path_thumbnail"`
+		}
+		ReleaseDate struct {
+			ComingSoon bool `json:"coming_soon"`
+			// Free text, like "18 Apr, 2011" or "Q3 2025".
+			Date string
+		} `json:"release_date"`
+	}
+}
+
+var yearPattern = regexp.MustCompile(`\b(19|20)\d\d\b`)
+
+// Fetches a URL and decodes the JSON response into v. Returns false if the
+// server answered 404.
+func getJson(url string, v interface{}) (bool, error) {
+	response, err := httpGet(url)
+	if err != nil {
+		logf(LogDebug, "Request failed: %v", err.Error())
+		return false, err
+	}
+	defer closeResponse(response)
+	logEvent(LogDebug, "request", LogFields{"url": url, "status": response.StatusCode}, "GET %v: %v", url, response.Status)
+
+	if response.StatusCode == 404 {
+		return false, nil
+	} else if response.StatusCode >= 400 {
+		return false, errors.New("Failed to get " + url + ": " + response.Status)
+	}
+	return true, json.NewDecoder(response.Body).Decode(v)
+}
+
+// Downloads the store metadata of a game, or nil if the store doesn't know
+// about it (removed or non-Steam games).
+func downloadStoreDetails(appId string) (*StoreDetails, error) {
+	var parsed storeResponse
+	found, err := getJson(fmt.Sprintf(storeDetailsUrlFormat, appId), &parsed)
+	if err != nil || !found {
+		return nil, err
+	}
+	app, ok := parsed[appId]
+	if !ok || !app.Success {
+		return nil, nil
+	}
+
+	details := &StoreDetails{Version: storeDetailsVersion}
+	for _, genre := range app.Data.Genres {
+		details.Genres = append(details.Genres, genre.Description)
+	}
+	for _, category := range app.Data.Categories {
+		details.Categories = append(details.Categories, category.Description)
+	}
+	for _, screenshot := range app.Data.Screenshots {
+		details.Screenshots = append(details.Screenshots, screenshot.PathThumbnail)
+	}
+	if !app.Data.ReleaseDate.ComingSoon {
+		year := yearPattern.FindString(app.Data.ReleaseDate.Date)
+		details.ReleaseYear, _ = strconv.Atoi(year)
+	}
+	return details, nil
+}
+
+// Returns the store metadata for the game, from the local cache if it's
+// recent enough. Games unknown to the store are cached as empty details, so
+// we don't ask again on every run.
+func GetStoreDetails(game *Game) (*StoreDetails, error) {
+	if isNonSteamGame(game) {
+		return nil, nil
+	}
+
+	details := &StoreDetails{}
+	if readCache("store", game.Id, storeCacheDuration, details) && details.Version == storeDetailsVersion {
+		return details, nil
+	}
+
+	details, err := downloadStoreDetails(game.Id)
+	if err != nil {
+		return nil, err
+	}
+	if details == nil {
+		details = &StoreDetails{Version: storeDetailsVersion}
+	}
+	return details, writeCache("store", game.Id, details)
+}
+
+// Loads the store metadata into the game, if it wasn't already, and returns
+// it.
+func loadStoreDetails(game *Game) (*StoreDetails, error) {
+	if game.Store != nil {
+		return game.Store, nil
+	}
+	details, err := GetStoreDetails(game)
+	if err != nil {
+		return nil, err
+	}
+	game.Store = details
+	return details, nil
+}
@@ -0,0 +1,897 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Vector image parsed from a subset of SVG, good enough for overlays and
+// badges: paths, basic shapes, groups, transforms, and flat fills and
+// strokes. Gradients, text and filters are ignored. Shapes are kept as
+// polygons, so the image can be rendered crisply at any size.
+type SvgImage struct {
+	// Natural size, from the width and height attributes or the viewBox.
+	Width, Height float64
+	// Area of the user coordinates that is stretched over the rendered image.
+	ViewBox [4]float64
+	Shapes  []svgShape
+}
+
+type svgPoint struct {
+	X, Y float64
+}
+
+// Shape already flattened to polygons in user coordinates, one per subpath.
+type svgShape struct {
+	Subpaths [][]svgPoint
+	// Whether each subpath was closed with Z, which matters for strokes.
+	Closed      []bool
+	Fill        color.NRGBA
+	Stroke      color.NRGBA
+	StrokeWidth float64
+	EvenOdd     bool
+}
+
+// Affine transform as the SVG matrix(a b c d e f).
+type svgMatrix [6]float64
+
+var svgIdentity = svgMatrix{1, 0, 0, 1, 0, 0}
+
+// Returns the transform that applies n first and then m.
+func (m svgMatrix) multiply(n svgMatrix) svgMatrix {
+	return svgMatrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m svgMatrix) apply(p svgPoint) svgPoint {
+	return svgPoint{m[0]*p.X + m[2]*p.Y + m[4], m[1]*p.X + m[3]*p.Y + m[5]}
+}
+
+// Presentation attributes that are inherited by child elements.
+type svgStyle struct {
+	Fill, Stroke                        string
+	FillOpacity, StrokeOpacity, Opacity float64
+	StrokeWidth                         float64
+	FillRule                            string
+	Transform                           svgMatrix
+}
+
+// Number of straight segments each curve is split into.
+const svgCurveSegments = 16
+
+// Loads an SVG file.
+func LoadSvg(path string) (*SvgImage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSvg(data)
+}
+
+// Parses an SVG document.
+func ParseSvg(data []byte) (*SvgImage, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+
+	svg := &SvgImage{}
+	styles := []svgStyle{{Fill: "black", Stroke: "none", FillOpacity: 1, StrokeOpacity: 1, Opacity: 1, StrokeWidth: 1, Transform: svgIdentity}}
+	foundRoot := false
+	// Depth inside elements whose content is never drawn, like <defs>.
+	skipDepth := 0
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			attrs := make(map[string]string)
+			for _, attr := range element.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+			for _, declaration := range strings.Split(attrs["style"], ";") {
+				if parts := strings.SplitN(declaration, ":", 2); len(parts) == 2 {
+					attrs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+				}
+			}
+
+			name := element.Name.Local
+			if name == "defs" || name == "clipPath" || name == "mask" || name == "symbol" || name == "style" {
+				skipDepth = 1
+				continue
+			}
+			if name == "svg" && !foundRoot {
+				foundRoot = true
+				svg.parseSize(attrs)
+			}
+
+			style := styles[len(styles)-1].inherit(attrs)
+			styles = append(styles, style)
+			if subpaths, closed := svgElementPolygons(name, attrs); len(subpaths) > 0 {
+				svg.addShape(subpaths, closed, style)
+			}
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+			} else if len(styles) > 1 {
+				styles = styles[:len(styles)-1]
+			}
+		}
+	}
+
+	if !foundRoot {
+		return nil, errors.New("Not an SVG image")
+	}
+	return svg, nil
+}
+
+// Reads the natural size and viewBox of the root element. Without a
+// viewBox, user coordinates are pixels.
+func (svg *SvgImage) parseSize(attrs map[string]string) {
+	viewBox := parseSvgNumbers(attrs["viewBox"])
+	hasViewBox := len(viewBox) == 4 && viewBox[2] > 0 && viewBox[3] > 0
+	width, errWidth := parseSvgLength(attrs["width"])
+	height, errHeight := parseSvgLength(attrs["height"])
+
+	switch {
+	case errWidth == nil && errHeight == nil:
+		svg.Width, svg.Height = width, height
+	case hasViewBox:
+		svg.Width, svg.Height = viewBox[2], viewBox[3]
+	default:
+		svg.Width, svg.Height = 100, 100
+	}
+	if hasViewBox {
+		copy(svg.ViewBox[:], viewBox)
+	} else {
+		svg.ViewBox = [4]float64{0, 0, svg.Width, svg.Height}
+	}
+}
+
+// Returns the style of an element, inheriting from its parent's.
+func (parent svgStyle) inherit(attrs map[string]string) svgStyle {
+	style := parent
+	if value, ok := attrs["fill"]; ok {
+		style.Fill = value
+	}
+	if value, ok := attrs["stroke"]; ok {
+		style.Stroke = value
+	}
+	if value, ok := attrs["fill-rule"]; ok {
+		style.FillRule = value
+	}
+	if value, err := strconv.ParseFloat(attrs["fill-opacity"], 64); err == nil {
+		style.FillOpacity = value
+	}
+	if value, err := strconv.ParseFloat(attrs["stroke-opacity"], 64); err == nil {
+		style.StrokeOpacity = value
+	}
+	// Group opacity is approximated by applying it to each shape.
+	if value, err := strconv.ParseFloat(attrs["opacity"], 64); err == nil {
+		style.Opacity *= value
+	}
+	if value, err := parseSvgLength(attrs["stroke-width"]); err == nil {
+		style.StrokeWidth = value
+	}
+	if value, ok := attrs["transform"]; ok {
+		style.Transform = parent.Transform.multiply(parseSvgTransform(value))
+	}
+	return style
+}
+
+// Adds the polygons of an element, transformed to user coordinates, with
+// its fill and stroke.
+func (svg *SvgImage) addShape(subpaths [][]svgPoint, closed []bool, style svgStyle) {
+	fill, hasFill := parseSvgColor(style.Fill, style.FillOpacity*style.Opacity)
+	stroke, hasStroke := parseSvgColor(style.Stroke, style.StrokeOpacity*style.Opacity)
+	if !hasFill && !hasStroke {
+		return
+	}
+
+	for _, subpath := range subpaths {
+		for i, point := range subpath {
+			subpath[i] = style.Transform.apply(point)
+		}
+	}
+	m := style.Transform
+	scale := math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+	shape := svgShape{
+		Subpaths:    subpaths,
+		Closed:      closed,
+		Fill:        fill,
+		Stroke:      stroke,
+		StrokeWidth: style.StrokeWidth * scale,
+		EvenOdd:     style.FillRule == "evenodd",
+	}
+	if !hasStroke {
+		shape.StrokeWidth = 0
+	}
+	svg.Shapes = append(svg.Shapes, shape)
+}
+
+// Returns the polygons of a drawing element, in its own coordinates, and
+// whether each is closed. Returns nothing for other elements.
+func svgElementPolygons(name string, attrs map[string]string) ([][]svgPoint, []bool) {
+	number := func(key string) float64 {
+		value, _ := parseSvgLength(attrs[key])
+		return value
+	}
+
+	switch name {
+	case "path":
+		return parseSvgPath(attrs["d"])
+	case "rect":
+		x, y, w, h := number("x"), number("y"), number("width"), number("height")
+		if w <= 0 || h <= 0 {
+			return nil, nil
+		}
+		rx, errX := parseSvgLength(attrs["rx"])
+		ry, errY := parseSvgLength(attrs["ry"])
+		if errX != nil {
+			rx = ry
+		}
+		if errY != nil {
+			ry = rx
+		}
+		rx, ry = math.Min(math.Max(rx, 0), w/2), math.Min(math.Max(ry, 0), h/2)
+		if rx == 0 || ry == 0 {
+			return [][]svgPoint{{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}}, []bool{true}
+		}
+		points := make([]svgPoint, 0)
+		corners := []struct{ cx, cy, start float64 }{
+			{x + w - rx, y + ry, -math.Pi / 2},
+			{x + w - rx, y + h - ry, 0},
+			{x + rx, y + h - ry, math.Pi / 2},
+			{x + rx, y + ry, math.Pi},
+		}
+		for _, corner := range corners {
+			for i := 0; i <= svgCurveSegments/2; i++ {
+				angle := corner.start + float64(i)*math.Pi/float64(svgCurveSegments)
+				points = append(points, svgPoint{corner.cx + rx*math.Cos(angle), corner.cy + ry*math.Sin(angle)})
+			}
+		}
+		return [][]svgPoint{points}, []bool{true}
+	case "circle", "ellipse":
+		rx, ry := number("rx"), number("ry")
+		if name == "circle" {
+			rx, ry = number("r"), number("r")
+		}
+		if rx <= 0 || ry <= 0 {
+			return nil, nil
+		}
+		return [][]svgPoint{svgEllipse(number("cx"), number("cy"), rx, ry)}, []bool{true}
+	case "line":
+		return [][]svgPoint{{{number("x1"), number("y1")}, {number("x2"), number("y2")}}}, []bool{false}
+	case "polyline", "polygon":
+		numbers := parseSvgNumbers(attrs["points"])
+		points := make([]svgPoint, 0, len(numbers)/2)
+		for i := 0; i+1 < len(numbers); i += 2 {
+			points = append(points, svgPoint{numbers[i], numbers[i+1]})
+		}
+		if len(points) < 2 {
+			return nil, nil
+		}
+		return [][]svgPoint{points}, []bool{name == "polygon"}
+	}
+	return nil, nil
+}
+
+// Returns the polygon approximating an ellipse.
+func svgEllipse(cx, cy, rx, ry float64) []svgPoint {
+	points := make([]svgPoint, 0, 2*svgCurveSegments)
+	for i := 0; i < 2*svgCurveSegments; i++ {
+		angle := float64(i) * math.Pi / svgCurveSegments
+		points = append(points, svgPoint{cx + rx*math.Cos(angle), cy + ry*math.Sin(angle)})
+	}
+	return points
+}
+
+// Parses a length like "12", "12.5px" or "1e2". Units other than pixels are
+// not supported.
+func parseSvgLength(value string) (float64, error) {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "px")
+	return strconv.ParseFloat(value, 64)
+}
+
+// Parses a list of numbers separated by spaces and/or commas, where a sign
+// or a second dot also starts a new number, like "10-5.5.5".
+func parseSvgNumbers(value string) []float64 {
+	numbers := make([]float64, 0)
+	scanner := &svgScanner{value, 0}
+	for {
+		number, ok := scanner.number()
+		if !ok {
+			return numbers
+		}
+		numbers = append(numbers, number)
+	}
+}
+
+// Reads path data and number lists.
+type svgScanner struct {
+	data string
+	pos  int
+}
+
+func (scanner *svgScanner) skipSeparators() {
+	for scanner.pos < len(scanner.data) && strings.IndexByte(" \t\r\n,", scanner.data[scanner.pos]) >= 0 {
+		scanner.pos++
+	}
+}
+
+// Returns the next number, or false if the next token isn't one.
+func (scanner *svgScanner) number() (float64, bool) {
+	scanner.skipSeparators()
+	start := scanner.pos
+	data := scanner.data
+	i := start
+	if i < len(data) && (data[i] == '+' || data[i] == '-') {
+		i++
+	}
+	digits, dot := false, false
+	for i < len(data) {
+		c := data[i]
+		if c >= '0' && c <= '9' {
+			digits = true
+		} else if c == '.' && !dot {
+			dot = true
+		} else {
+			break
+		}
+		i++
+	}
+	if !digits {
+		return 0, false
+	}
+	if i < len(data) && (data[i] == 'e' || data[i] == 'E') {
+		j := i + 1
+		if j < len(data) && (data[j] == '+' || data[j] == '-') {
+			j++
+		}
+		if j < len(data) && data[j] >= '0' && data[j] <= '9' {
+			for j < len(data) && data[j] >= '0' && data[j] <= '9' {
+				j++
+			}
+			i = j
+		}
+	}
+	number, err := strconv.ParseFloat(data[start:i], 64)
+	if err != nil {
+		return 0, false
+	}
+	scanner.pos = i
+	return number, true
+}
+
+// Returns the arc flag (a single 0 or 1, which may not be followed by a
+// separator).
+func (scanner *svgScanner) flag() (bool, bool) {
+	scanner.skipSeparators()
+	if scanner.pos < len(scanner.data) && (scanner.data[scanner.pos] == '0' || scanner.data[scanner.pos] == '1') {
+		scanner.pos++
+		return scanner.data[scanner.pos-1] == '1', true
+	}
+	return false, false
+}
+
+// Parses transforms like "translate(10 20) scale(2) rotate(45 5 5)".
+func parseSvgTransform(value string) svgMatrix {
+	result := svgIdentity
+	for _, part := range strings.Split(value, ")") {
+		nameAndArgs := strings.SplitN(part, "(", 2)
+		if len(nameAndArgs) != 2 {
+			continue
+		}
+		args := parseSvgNumbers(nameAndArgs[1])
+		arg := func(i int, fallback float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return fallback
+		}
+
+		var m svgMatrix
+		switch strings.TrimSpace(strings.Trim(nameAndArgs[0], ", \t\r\n")) {
+		case "matrix":
+			if len(args) != 6 {
+				continue
+			}
+			copy(m[:], args)
+		case "translate":
+			m = svgMatrix{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			m = svgMatrix{arg(0, 1), 0, 0, arg(1, arg(0, 1)), 0, 0}
+		case "rotate":
+			angle := arg(0, 0) * math.Pi / 180
+			cos, sin := math.Cos(angle), math.Sin(angle)
+			cx, cy := arg(1, 0), arg(2, 0)
+			m = svgMatrix{1, 0, 0, 1, cx, cy}.multiply(svgMatrix{cos, sin, -sin, cos, 0, 0}).multiply(svgMatrix{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			m = svgMatrix{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			m = svgMatrix{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			continue
+		}
+		result = result.multiply(m)
+	}
+	return result
+}
+
+// Named colors common in icons. Others are drawn black.
+var svgColorNames = map[string]color.RGBA{
+	"black":  {0, 0, 0, 255},
+	"white":  {255, 255, 255, 255},
+	"red":    {255, 0, 0, 255},
+	"green":  {0, 128, 0, 255},
+	"lime":   {0, 255, 0, 255},
+	"blue":   {0, 0, 255, 255},
+	"yellow": {255, 255, 0, 255},
+	"orange": {255, 165, 0, 255},
+	"purple": {128, 0, 128, 255},
+	"gray":   {128, 128, 128, 255},
+	"grey":   {128, 128, 128, 255},
+	"silver": {192, 192, 192, 255},
+	"gold":   {255, 215, 0, 255},
+}
+
+// Parses a paint value like "#f80", "#ff8800", "rgb(255, 136, 0)" or a
+// color name, with the given opacity. Returns false for "none" and for
+// paints we can't draw, like gradients.
+func parseSvgColor(value string, opacity float64) (color.NRGBA, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	alpha := uint8(math.Max(0, math.Min(1, opacity))*255 + 0.5)
+	if value == "" || value == "none" || value == "transparent" || strings.HasPrefix(value, "url(") {
+		return color.NRGBA{}, false
+	}
+
+	if strings.HasPrefix(value, "#") {
+		hex := value[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		if len(hex) != 6 || err != nil {
+			return color.NRGBA{}, false
+		}
+		return color.NRGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), alpha}, true
+	}
+
+	if strings.HasPrefix(value, "rgb(") {
+		parts := strings.Split(strings.TrimSuffix(value[len("rgb("):], ")"), ",")
+		if len(parts) != 3 {
+			return color.NRGBA{}, false
+		}
+		var channels [3]uint8
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			percent := strings.HasSuffix(part, "%")
+			channel, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+			if err != nil {
+				return color.NRGBA{}, false
+			}
+			if percent {
+				channel = channel * 255 / 100
+			}
+			channels[i] = uint8(math.Max(0, math.Min(255, channel)) + 0.5)
+		}
+		return color.NRGBA{channels[0], channels[1], channels[2], alpha}, true
+	}
+
+	named := svgColorNames[value]
+	return color.NRGBA{named.R, named.G, named.B, alpha}, true
+}
+
+// Parses path data ("M 10 10 L 20 20 Z") into polygons, one per subpath,
+// with curves and arcs flattened to line segments.
+func parseSvgPath(data string) ([][]svgPoint, []bool) {
+	subpaths := make([][]svgPoint, 0)
+	closed := make([]bool, 0)
+	var current []svgPoint
+	var pos, start, lastControl svgPoint
+	var lastCommand byte
+
+	finish := func(isClosed bool) {
+		if len(current) >= 2 {
+			subpaths = append(subpaths, current)
+			closed = append(closed, isClosed)
+		}
+		current = nil
+	}
+	lineTo := func(p svgPoint) {
+		if current == nil {
+			current = []svgPoint{pos}
+		}
+		current = append(current, p)
+		pos = p
+	}
+	cubicTo := func(c1, c2, end svgPoint) {
+		from := pos
+		for i := 1; i <= svgCurveSegments; i++ {
+			t := float64(i) / svgCurveSegments
+			u := 1 - t
+			lineTo(svgPoint{
+				u*u*u*from.X + 3*u*u*t*c1.X + 3*u*t*t*c2.X + t*t*t*end.X,
+				u*u*u*from.Y + 3*u*u*t*c1.Y + 3*u*t*t*c2.Y + t*t*t*end.Y,
+			})
+		}
+		lastControl = c2
+	}
+	quadTo := func(c, end svgPoint) {
+		from := pos
+		for i := 1; i <= svgCurveSegments; i++ {
+			t := float64(i) / svgCurveSegments
+			u := 1 - t
+			lineTo(svgPoint{
+				u*u*from.X + 2*u*t*c.X + t*t*end.X,
+				u*u*from.Y + 2*u*t*c.Y + t*t*end.Y,
+			})
+		}
+		lastControl = c
+	}
+
+	scanner := &svgScanner{data, 0}
+	var command byte
+	for {
+		scanner.skipSeparators()
+		if scanner.pos >= len(data) {
+			break
+		}
+		if c := data[scanner.pos]; strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0 {
+			command = c
+			scanner.pos++
+		} else if command == 0 {
+			break
+		}
+
+		relative := command >= 'a'
+		point := func() (svgPoint, bool) {
+			x, okX := scanner.number()
+			y, okY := scanner.number()
+			if relative {
+				x, y = x+pos.X, y+pos.Y
+			}
+			return svgPoint{x, y}, okX && okY
+		}
+		// The reflection of the last control point, for smooth curves.
+		reflected := func(curveCommands string) svgPoint {
+			if strings.IndexByte(curveCommands, lastCommand) >= 0 {
+				return svgPoint{2*pos.X - lastControl.X, 2*pos.Y - lastControl.Y}
+			}
+			return pos
+		}
+
+		ok := true
+		switch command {
+		case 'M', 'm':
+			var p svgPoint
+			if p, ok = point(); ok {
+				finish(false)
+				pos, start = p, p
+				// Further pairs are implicit line-tos.
+				if relative {
+					command = 'l'
+				} else {
+					command = 'L'
+				}
+			}
+		case 'L', 'l':
+			var p svgPoint
+			if p, ok = point(); ok {
+				lineTo(p)
+			}
+		case 'H', 'h':
+			var x float64
+			if x, ok = scanner.number(); ok {
+				if relative {
+					x += pos.X
+				}
+				lineTo(svgPoint{x, pos.Y})
+			}
+		case 'V', 'v':
+			var y float64
+			if y, ok = scanner.number(); ok {
+				if relative {
+					y += pos.Y
+				}
+				lineTo(svgPoint{pos.X, y})
+			}
+		case 'C', 'c':
+			c1, ok1 := point()
+			c2, ok2 := point()
+			end, ok3 := point()
+			if ok = ok1 && ok2 && ok3; ok {
+				cubicTo(c1, c2, end)
+			}
+		case 'S', 's':
+			c1 := reflected("CcSs")
+			c2, ok1 := point()
+			end, ok2 := point()
+			if ok = ok1 && ok2; ok {
+				cubicTo(c1, c2, end)
+			}
+		case 'Q', 'q':
+			c, ok1 := point()
+			end, ok2 := point()
+			if ok = ok1 && ok2; ok {
+				quadTo(c, end)
+			}
+		case 'T', 't':
+			c := reflected("QqTt")
+			var end svgPoint
+			if end, ok = point(); ok {
+				quadTo(c, end)
+			}
+		case 'A', 'a':
+			rx, ok1 := scanner.number()
+			ry, ok2 := scanner.number()
+			rotation, ok3 := scanner.number()
+			large, ok4 := scanner.flag()
+			sweep, ok5 := scanner.flag()
+			end, ok6 := point()
+			if ok = ok1 && ok2 && ok3 && ok4 && ok5 && ok6; ok {
+				for _, p := range svgArc(pos, end, rx, ry, rotation, large, sweep) {
+					lineTo(p)
+				}
+			}
+		case 'Z', 'z':
+			if current != nil {
+				current = append(current, start)
+			}
+			finish(true)
+			pos = start
+		}
+		if !ok {
+			break
+		}
+		lastCommand = command
+	}
+	finish(false)
+	return subpaths, closed
+}
+
+// Returns the points of an elliptical arc from one point to another, as in
+// the SVG "A" command, excluding the start.
+func svgArc(from, to svgPoint, rx, ry, rotation float64, large, sweep bool) []svgPoint {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || (from == to) {
+		return []svgPoint{to}
+	}
+
+	phi := rotation * math.Pi / 180
+	cos, sin := math.Cos(phi), math.Sin(phi)
+	dx, dy := (from.X-to.X)/2, (from.Y-to.Y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+
+	// Scale up radii that are too small to reach the end point.
+	if lambda := x1*x1/(rx*rx) + y1*y1/(ry*ry); lambda > 1 {
+		rx, ry = rx*math.Sqrt(lambda), ry*math.Sqrt(lambda)
+	}
+
+	numerator := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	denominator := rx*rx*y1*y1 + ry*ry*x1*x1
+	factor := math.Sqrt(math.Max(0, numerator/denominator))
+	if large == sweep {
+		factor = -factor
+	}
+	cx1 := factor * rx * y1 / ry
+	cy1 := -factor * ry * x1 / rx
+	cx := cos*cx1 - sin*cy1 + (from.X+to.X)/2
+	cy := sin*cx1 + cos*cy1 + (from.Y+to.Y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	startAngle := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	segments := int(math.Ceil(math.Abs(delta) / (math.Pi / 2) * svgCurveSegments / 2))
+	if segments < 1 {
+		segments = 1
+	}
+	points := make([]svgPoint, 0, segments)
+	for i := 1; i <= segments; i++ {
+		theta := startAngle + delta*float64(i)/float64(segments)
+		x, y := rx*math.Cos(theta), ry*math.Sin(theta)
+		points = append(points, svgPoint{cos*x - sin*y + cx, sin*x + cos*y + cy})
+	}
+	points[len(points)-1] = to
+	return points
+}
+
+// Renders the image stretched to the given size.
+func (svg *SvgImage) Render(width, height int) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width <= 0 || height <= 0 || svg.ViewBox[2] <= 0 || svg.ViewBox[3] <= 0 {
+		return result
+	}
+	scaleX := float64(width) / svg.ViewBox[2]
+	scaleY := float64(height) / svg.ViewBox[3]
+	toPixels := func(p svgPoint) svgPoint {
+		return svgPoint{(p.X - svg.ViewBox[0]) * scaleX, (p.Y - svg.ViewBox[1]) * scaleY}
+	}
+
+	for _, shape := range svg.Shapes {
+		subpaths := make([][]svgPoint, len(shape.Subpaths))
+		for i, subpath := range shape.Subpaths {
+			subpaths[i] = make([]svgPoint, len(subpath))
+			for j, point := range subpath {
+				subpaths[i][j] = toPixels(point)
+			}
+		}
+
+		if shape.Fill.A > 0 {
+			mask := rasterizePolygons(subpaths, width, height, shape.EvenOdd)
+			draw.DrawMask(result, result.Bounds(), image.NewUniform(shape.Fill), image.ZP, mask, image.ZP, draw.Over)
+		}
+		if shape.Stroke.A > 0 && shape.StrokeWidth > 0 {
+			halfWidth := shape.StrokeWidth * math.Sqrt(scaleX*scaleY) / 2
+			outline := strokePolygons(subpaths, shape.Closed, halfWidth)
+			mask := rasterizePolygons(outline, width, height, false)
+			draw.DrawMask(result, result.Bounds(), image.NewUniform(shape.Stroke), image.ZP, mask, image.ZP, draw.Over)
+		}
+	}
+	return result
+}
+
+// Returns polygons covering the stroke of the subpaths: a rectangle per
+// segment and a circle per vertex, for round joins and caps. All of them
+// are wound the same way, so filling them with the non-zero rule gives
+// their union.
+func strokePolygons(subpaths [][]svgPoint, closed []bool, halfWidth float64) [][]svgPoint {
+	polygons := make([][]svgPoint, 0)
+	add := func(polygon []svgPoint) {
+		area := 0.0
+		for i := range polygon {
+			a, b := polygon[i], polygon[(i+1)%len(polygon)]
+			area += a.X*b.Y - b.X*a.Y
+		}
+		if area < 0 {
+			for i, j := 0, len(polygon)-1; i < j; i, j = i+1, j-1 {
+				polygon[i], polygon[j] = polygon[j], polygon[i]
+			}
+		}
+		polygons = append(polygons, polygon)
+	}
+
+	for i, subpath := range subpaths {
+		points := subpath
+		if i < len(closed) && closed[i] && points[0] != points[len(points)-1] {
+			points = append(points, points[0])
+		}
+		for j := 0; j+1 < len(points); j++ {
+			a, b := points[j], points[j+1]
+			length := math.Hypot(b.X-a.X, b.Y-a.Y)
+			if length == 0 {
+				continue
+			}
+			nx, ny := -(b.Y-a.Y)/length*halfWidth, (b.X-a.X)/length*halfWidth
+			add([]svgPoint{{a.X + nx, a.Y + ny}, {b.X + nx, b.Y + ny}, {b.X - nx, b.Y - ny}, {a.X - nx, a.Y - ny}})
+		}
+		for _, point := range points {
+			add(svgEllipse(point.X, point.Y, halfWidth, halfWidth))
+		}
+	}
+	return polygons
+}
+
+// Number of sample rows per pixel when rasterizing. Columns are covered
+// exactly.
+const svgSubsamples = 5
+
+// Returns the antialiased coverage of the polygons, filled with the
+// non-zero or even-odd rule.
+func rasterizePolygons(polygons [][]svgPoint, width, height int, evenOdd bool) *image.Alpha {
+	type edge struct {
+		x0, y0, x1, y1 float64
+		winding        int
+	}
+	edges := make([]edge, 0)
+	for _, polygon := range polygons {
+		for i := range polygon {
+			a, b := polygon[i], polygon[(i+1)%len(polygon)]
+			if a.Y == b.Y {
+				continue
+			}
+			if a.Y < b.Y {
+				edges = append(edges, edge{a.X, a.Y, b.X, b.Y, 1})
+			} else {
+				edges = append(edges, edge{b.X, b.Y, a.X, a.Y, -1})
+			}
+		}
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+	coverage := make([]float64, width+1)
+	type crossing struct {
+		x       float64
+		winding int
+	}
+	crossings := make([]crossing, 0)
+
+	for y := 0; y < height; y++ {
+		for i := range coverage {
+			coverage[i] = 0
+		}
+		for sample := 0; sample < svgSubsamples; sample++ {
+			sampleY := float64(y) + (float64(sample)+0.5)/svgSubsamples
+			crossings = crossings[:0]
+			for _, e := range edges {
+				if sampleY >= e.y0 && sampleY < e.y1 {
+					x := e.x0 + (sampleY-e.y0)*(e.x1-e.x0)/(e.y1-e.y0)
+					crossings = append(crossings, crossing{x, e.winding})
+				}
+			}
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+			winding := 0
+			for i := 0; i+1 < len(crossings); i++ {
+				if evenOdd {
+					winding ^= 1
+				} else {
+					winding += crossings[i].winding
+				}
+				if winding == 0 {
+					continue
+				}
+				addSpanCoverage(coverage, crossings[i].x, crossings[i+1].x, width)
+			}
+		}
+		for x := 0; x < width; x++ {
+			alpha := coverage[x] / svgSubsamples
+			if alpha > 1 {
+				alpha = 1
+			}
+			mask.Pix[y*mask.Stride+x] = uint8(alpha*255 + 0.5)
+		}
+	}
+	return mask
+}
+
+// Adds the area of a horizontal span of one sample row to the pixels it
+// covers, partially covered pixels getting a fraction.
+func addSpanCoverage(coverage []float64, from, to float64, width int) {
+	from = math.Max(0, from)
+	to = math.Min(float64(width), to)
+	if to <= from {
+		return
+	}
+	first, last := int(from), int(to)
+	if first == last {
+		coverage[first] += to - from
+		return
+	}
+	coverage[first] += float64(first+1) - from
+	for x := first + 1; x < last; x++ {
+		coverage[x]++
+	}
+	if last < width {
+		coverage[last] += to - float64(last)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Persona name or Steam id of the account to copy the images from, given
+// with --from.
+var syncFrom = new(string)
+
+// Copies the grid image of a game from one user to another, along with
+// the backup of its original, so later runs of the other user draw their
+// own overlays on the same original. Images without a backup become the
+// original of the other user. Returns false if the other user has the same
+// image already, or the first user has none.
+func SyncGridImage(from User, to User, game *Game, asset *AssetType) (bool, error) {
+	currentPath, current := loadCurrentGridImage(from, game, asset)
+	if current == nil {
+		return false, nil
+	}
+	if _, existing := loadCurrentGridImage(to, game, asset); bytes.Equal(existing, current) {
+		return false, nil
+	}
+	if *dryRun {
+		return true, nil
+	}
+	backupPath := findBackup(from, game.Id, asset)
+	var original []byte
+	if backupPath != "" {
+		var err error
+		if original, err = ioutil.ReadFile(backupPath); err != nil {
+			return false, err
+		}
+	}
+	// The original keeps where it came from, so restoring works the same
+	// for both users.
+	source := getBackupSource(from, game.Id, asset)
+	return true, installSyncedImage(to, game, asset, current, filepath.Ext(currentPath), original, filepath.Ext(backupPath), source)
+}
+
+// Installs an image copied from another user or machine, with its original
+// as the backup, coming from source. Without an original the image itself
+// becomes the original.
+func installSyncedImage(to User, game *Game, asset *AssetType, current []byte, currentExt string, original []byte, originalExt string, source string) error {
+	if original == nil {
+		_, err := setGridImageBytes(to, game, asset, current, nil)
+		return err
+	}
+	if err := SaveImageVersion(to, game, asset); err != nil {
+		return err
+	}
+	gridDir := getGridDir(to)
+	base := game.Id + asset.Suffix
+	for _, ext := range gridImageExts {
+		if err := removeFile(game.Output, filepath.Join(gridDir, base+ext)); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(gridDir, 0777); err != nil {
+		return err
+	}
+
+	game.ImagePath = filepath.Join(gridDir, base+originalExt)
+	game.ImageBytes = original
+	game.ImageSource = source
+	if err := BackupGame(to, game, asset); err != nil {
+		return err
+	}
+	if err := RecordBackupSource(to, game, asset); err != nil {
+		return err
+	}
+
+	game.ImagePath = filepath.Join(gridDir, base+currentExt)
+	game.ImageBytes = current
+	if err := writeFile(game.Output, game.ImagePath, current); err != nil {
+		return err
+	}
+	return RecordInstalledImage(to, game, asset)
+}
+
+// Returns the flag set of the sync command.
+func newSyncFlags() *flag.FlagSet {
+	flags := newCommandFlags("sync")
+	addAssetFlags(flags)
+	assetsFlag := flags.Lookup("assets")
+	assetsFlag.Value.Set("all")
+	assetsFlag.DefValue = "all"
+	addUserFlags(flags)
+	flags.Lookup("user").Usage = "Comma separated persona names or Steam ids of the users to copy the images to, instead of everyone else in this computer."
+	addGameFlags(flags)
+	addProtectedFlags(flags)
+	addVersionFlags(flags)
+	flags.StringVar(syncFrom, "from", "", "Persona name or Steam id of the user to copy the images from.")
+	flags.StringVar(syncFolder, "folder", "", "Keep the images of each user the same as in this folder, both ways, instead of copying between users. Use a folder shared with your other computers, like a network drive or a Dropbox folder.")
+	flags.StringVar(syncPrefer, "prefer", "", "Which side wins images changed both here and in the --folder since the last sync: local or remote. They are left alone by default.")
+	flags.BoolVar(dryRun, "dry-run", false, "Only list the images that would be copied.")
+	return flags
+}
+
+// Copies the grid images of one user to the other users of the computer,
+// for the games they both have, so a family sharing a PC sees the same
+// artwork. With --folder, syncs every user with a folder instead.
+func runSync(args []string) {
+	flags := newSyncFlags()
+	parseCommandFlags(flags, args)
+	switch *syncPrefer {
+	case "", "local", "remote":
+	default:
+		errorAndExit(errors.New(tr("Unknown --prefer '%v', expected local or remote.", *syncPrefer)))
+	}
+	assets, err := GetAssetTypes(*assetNames)
+	if err != nil {
+		errorAndExit(err)
+	}
+	if *syncFolder != "" {
+		runFolderSync(assets, flags.Args())
+		return
+	}
+	if *syncFrom == "" {
+		errorAndExit(errors.New(tr("Choose the user to copy the images from, like: steamgrid sync --from gabe, or a folder to sync with, like: steamgrid sync --folder /mnt/share/steamgrid")))
+	}
+
+	installationDir, users := loadUsers(flags.Args())
+	everyone, err := GetUsers(installationDir)
+	if err != nil {
+		errorAndExit(err)
+	}
+	sources, err := FilterUsers(everyone, *syncFrom)
+	if err != nil {
+		errorAndExit(err)
+	}
+	if len(sources) != 1 {
+		errorAndExit(errors.New(tr("More than one user matches --from '%v', use the Steam id instead.", *syncFrom)))
+	}
+	from := sources[0]
+	// Without the profile we still have the games found locally.
+	fromGames, _ := GetGames(from)
+	FilterGames(fromGames, *gameFilter)
+	RemoveProtectedGames(fromGames)
+	startJournal(strings.Join(os.Args[1:], " "))
+
+	for _, to := range users {
+		if to.SteamId32 == from.SteamId32 {
+			continue
+		}
+		toGames, _ := GetGames(to)
+		nCopied := 0
+		for _, game := range SortGames(fromGames, false) {
+			// Protected names may only match in the other library.
+			toGame, ok := toGames[game.Id]
+			if !ok || isProtected(toGame) {
+				continue
+			}
+			for _, asset := range assets {
+				copied, err := SyncGridImage(from, to, toGame, asset)
+				if err != nil {
+					errorAndExit(errors.New(tr("Failed to copy the %v of %v to %v: %v", asset.Name, game.Name, to.Name, err.Error())))
+				}
+				if copied {
+					if *dryRun {
+						fmt.Print(tr("Would copy the %v of %v to %v.\n", asset.Name, game.Name, to.Name))
+					}
+					nCopied++
+				}
+			}
+		}
+		if *dryRun {
+			fmt.Print(tr("%v images would be copied from %v to %v.\n", nCopied, from.Name, to.Name))
+		} else {
+			fmt.Print(tr("%v images copied from %v to %v.\n", nCopied, from.Name, to.Name))
+		}
+	}
+}
+
+// Folder shared with other computers, like a network drive or a Dropbox
+// folder, given with --folder, and which side wins when both changed an
+// image, given with --prefer: "local", "remote" or "" to ask for it.
+var (
+	syncFolder = new(string)
+	syncPrefer = new(string)
+)
+
+// Name of the file that lists the images in the sync folder of a user.
+const folderManifestName = "sync.json"
+
+// Image in a sync folder, with the backup of its original.
+type FolderImage struct {
+	File string
+	// File in the originals subfolder, or "" if there's no backup.
+	Original string
+	// Where the original came from, like in RecordBackupSource.
+	Source  string
+	Hash    string
+	Machine string
+	Updated time.Time
+}
+
+// Images in the sync folder of a user, by game id and asset type.
+type FolderManifest struct {
+	Images map[string]FolderImage
+}
+
+// What SyncFolder did.
+type FolderSyncResult struct {
+	Pushed, Pulled int
+	// Images changed on both sides, left as they are.
+	Conflicts []string
+}
+
+// Returns the hex SHA-256 of a file, read a piece at a time.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Returns the hex SHA-256 of an image, or "" if there's none.
+func hashImage(imageBytes []byte) string {
+	if imageBytes == nil {
+		return ""
+	}
+	sum := sha256.Sum256(imageBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// Loads the list of images in the sync folder of a user, empty if it's new.
+func loadFolderManifest(dir string) (*FolderManifest, error) {
+	manifest := &FolderManifest{Images: make(map[string]FolderImage)}
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(dir, folderManifestName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return nil, errors.New("Invalid " + filepath.Join(dir, folderManifestName) + ": " + err.Error())
+	}
+	if manifest.Images == nil {
+		manifest.Images = make(map[string]FolderImage)
+	}
+	return manifest, nil
+}
+
+// Keeps the grid images of a user the same as in a folder shared with
+// other computers, both ways. The hashes of the last sync, kept in the run
+// state, tell which side changed an image since: that side wins, and
+// images changed on both sides are conflicts, resolved by prefer or left
+// alone. Deleting an image on one side deletes it on the other.
+func SyncFolder(user User, folder string, assets []*AssetType, protected map[string]bool, prefer string) (FolderSyncResult, error) {
+	result := FolderSyncResult{Conflicts: make([]string, 0)}
+	dir := filepath.Join(folder, user.SteamId32)
+	manifest, err := loadFolderManifest(dir)
+	if err != nil {
+		return result, err
+	}
+	state := loadRunState(user)
+	synced := state.Synced[dir]
+	if synced == nil {
+		synced = make(map[string]string)
+		state.Synced[dir] = synced
+	}
+	machine, _ := os.Hostname()
+
+	// Every image on either side.
+	keys := make(map[string]*AssetType)
+	for key := range manifest.Images {
+		parts := strings.SplitN(key, " ", 2)
+		for _, asset := range assets {
+			if len(parts) == 2 && parts[1] == asset.Name {
+				keys[key] = asset
+			}
+		}
+	}
+	files, err := ioutil.ReadDir(getGridDir(user))
+	if err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+	for _, file := range files {
+		groups := gridFilePattern.FindStringSubmatch(file.Name())
+		if groups == nil || groups[3] != "" {
+			continue
+		}
+		asset := getAssetTypeBySuffix(groups[2])
+		for _, wanted := range assets {
+			if asset == wanted {
+				keys[groups[1]+" "+asset.Name] = asset
+			}
+		}
+	}
+
+	push := func(key string, game *Game, asset *AssetType, localPath string, local []byte) error {
+		if *dryRun {
+			return nil
+		}
+		if old, ok := manifest.Images[key]; ok {
+			for _, name := range []string{old.File, old.Original} {
+				if name != "" {
+					if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+						return err
+					}
+				}
+			}
+		}
+		if local == nil {
+			delete(manifest.Images, key)
+			return nil
+		}
+		entry := FolderImage{File: game.Id + asset.Suffix + filepath.Ext(localPath), Hash: hashImage(local), Machine: machine, Updated: time.Now()}
+		if backupPath := findBackup(user, game.Id, asset); backupPath != "" {
+			original, err := ioutil.ReadFile(backupPath)
+			if err != nil {
+				return err
+			}
+			entry.Original = filepath.Join("originals", game.Id+asset.Suffix+filepath.Ext(backupPath))
+			entry.Source = getBackupSource(user, game.Id, asset)
+			if err := os.MkdirAll(filepath.Join(dir, "originals"), 0777); err != nil {
+				return err
+			}
+			if err := writeFileAtomic(filepath.Join(dir, entry.Original), original, 0644); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, entry.File), local, 0644); err != nil {
+			return err
+		}
+		manifest.Images[key] = entry
+		return nil
+	}
+
+	pull := func(game *Game, asset *AssetType, remote FolderImage, inRemote bool) error {
+		if *dryRun {
+			return nil
+		}
+		if !inRemote {
+			if err := SaveImageVersion(user, game, asset); err != nil {
+				return err
+			}
+			for _, ext := range gridImageExts {
+				if err := removeFile(game.Output, filepath.Join(getGridDir(user), game.Id+asset.Suffix+ext)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		current, err := ioutil.ReadFile(filepath.Join(dir, remote.File))
+		if err != nil {
+			return err
+		}
+		var original []byte
+		if remote.Original != "" {
+			if original, err = ioutil.ReadFile(filepath.Join(dir, remote.Original)); err != nil {
+				return err
+			}
+		}
+		return installSyncedImage(user, game, asset, current, filepath.Ext(remote.File), original, filepath.Ext(remote.Original), remote.Source)
+	}
+
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+	for _, key := range sortedKeys {
+		asset := keys[key]
+		game := &Game{Id: strings.SplitN(key, " ", 2)[0]}
+		if protected[game.Id] {
+			continue
+		}
+		localPath, local := loadCurrentGridImage(user, game, asset)
+		localHash := hashImage(local)
+		remote, inRemote := manifest.Images[key]
+		if localHash == remote.Hash {
+			synced[key] = localHash
+			continue
+		}
+
+		base, hasBase := synced[key]
+		pushes := (hasBase && remote.Hash == base) || (!hasBase && !inRemote)
+		pulls := (hasBase && localHash == base) || (!hasBase && local == nil)
+		if !pushes && !pulls {
+			switch prefer {
+			case "local":
+				pushes = true
+			case "remote":
+				pulls = true
+			default:
+				result.Conflicts = append(result.Conflicts, tr("the %v of %v, changed here and on %v at %v", asset.Name, game.Id, remote.Machine, remote.Updated.Format("2006-01-02 15:04")))
+				continue
+			}
+		}
+		if pushes {
+			if err := push(key, game, asset, localPath, local); err != nil {
+				return result, err
+			}
+			synced[key] = localHash
+			result.Pushed++
+		} else {
+			if err := pull(game, asset, remote, inRemote); err != nil {
+				return result, err
+			}
+			synced[key] = remote.Hash
+			result.Pulled++
+		}
+	}
+
+	if *dryRun {
+		return result, nil
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return result, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, folderManifestName), manifestBytes, 0644); err != nil {
+		return result, err
+	}
+	return result, saveRunState(user, state)
+}
+
+// Syncs the grid images of every user with --folder.
+func runFolderSync(assets []*AssetType, steamArgs []string) {
+	_, users := loadUsers(steamArgs)
+	startJournal(strings.Join(os.Args[1:], " "))
+	nConflicts := 0
+	for _, user := range users {
+		// Without the profile, names in --protected only match local games.
+		games, _ := GetGames(user)
+		result, err := SyncFolder(user, *syncFolder, assets, getProtectedIds(games), *syncPrefer)
+		if err != nil {
+			errorAndExit(errors.New(tr("Failed to sync %v with %v: %v", user.Name, *syncFolder, err.Error())))
+		}
+		if *dryRun {
+			fmt.Print(tr("%v images would be copied to %v and %v from it for %v.\n", result.Pushed, *syncFolder, result.Pulled, user.Name))
+		} else {
+			fmt.Print(tr("%v images copied to %v and %v from it for %v.\n", result.Pushed, *syncFolder, result.Pulled, user.Name))
+		}
+		for _, conflict := range result.Conflicts {
+			fmt.Print(tr("Conflict: %v\n", conflict))
+		}
+		nConflicts += len(result.Conflicts)
+	}
+	if nConflicts > 0 {
+		fmt.Print(tr("%v images were changed on both sides and left alone. Sync again with --prefer local or --prefer remote to pick one.\n", nConflicts))
+	}
+}
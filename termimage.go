@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+// Width in terminal columns of the image previews.
+const terminalPreviewColumns = 50
+
+// Width in pixels of sixel previews, which are sized in pixels instead.
+const sixelPreviewWidth = 460
+
+// Returns the inline image protocol of the terminal: "kitty", "iterm"
+// (also spoken by WezTerm and others), "sixel", or "" if it can't show
+// images. Sixel support can't be detected from the environment, so it has to
+// be asked for with --image-protocol.
+func detectImageProtocol() string {
+	if *imageProtocol != "" {
+		if *imageProtocol == "none" {
+			return ""
+		}
+		return *imageProtocol
+	}
+	if os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(os.Getenv("TERM"), "kitty") {
+		return "kitty"
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm", "vscode":
+		return "iterm"
+	}
+	if os.Getenv("LC_TERMINAL") == "iTerm2" {
+		return "iterm"
+	}
+	return ""
+}
+
+// Draws an image inline in the terminal with the given protocol.
+func showTerminalImage(w io.Writer, img image.Image, protocol string) error {
+	if protocol == "sixel" {
+		if img.Bounds().Dx() > sixelPreviewWidth {
+			height := img.Bounds().Dy() * sixelPreviewWidth / img.Bounds().Dx()
+			img = resizeImage(img, sixelPreviewWidth, height)
+		}
+		_, err := io.WriteString(w, encodeSixel(img)+"\n")
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	switch protocol {
+	case "kitty":
+		// Sent in chunks of at most 4096 bytes, m=1 meaning more follow.
+		for i := 0; i < len(encoded); i += 4096 {
+			end := i + 4096
+			more := 1
+			if end >= len(encoded) {
+				end, more = len(encoded), 0
+			}
+			control := fmt.Sprintf("m=%v", more)
+			if i == 0 {
+				control = fmt.Sprintf("a=T,f=100,c=%v,m=%v", terminalPreviewColumns, more)
+			}
+			if _, err := fmt.Fprintf(w, "\x1b_G%v;%v\x1b\\", control, encoded[i:end]); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	case "iterm":
+		_, err := fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%v;width=%v;preserveAspectRatio=1:%v\a\n", buf.Len(), terminalPreviewColumns, encoded)
+		return err
+	}
+	return nil
+}
+
+// Encodes an image as sixels, with a palette of 6 levels per channel.
+func encodeSixel(img image.Image) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	indexes := make([]int, width*height)
+	used := make(map[int]bool)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			index := int(r*5/0xffff)*36 + int(g*5/0xffff)*6 + int(b*5/0xffff)
+			indexes[y*width+x] = index
+			used[index] = true
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("\x1bPq")
+	fmt.Fprintf(&out, "\"1;1;%v;%v", width, height)
+	for index := range used {
+		// Sixel colors are in percent.
+		fmt.Fprintf(&out, "#%v;2;%v;%v;%v", index, index/36*20, index/6%6*20, index%6*20)
+	}
+
+	for top := 0; top < height; top += 6 {
+		bandColors := make(map[int]bool)
+		for y := top; y < top+6 && y < height; y++ {
+			for x := 0; x < width; x++ {
+				bandColors[indexes[y*width+x]] = true
+			}
+		}
+		for index := range bandColors {
+			fmt.Fprintf(&out, "#%v", index)
+			// Run length encoded columns of 6 pixels, one bit per row.
+			last, run := byte(0), 0
+			flush := func() {
+				if run > 3 {
+					fmt.Fprintf(&out, "!%v%c", run, last)
+				} else {
+					out.WriteString(strings.Repeat(string(last), run))
+				}
+			}
+			for x := 0; x < width; x++ {
+				bits := byte(0)
+				for row := 0; row < 6 && top+row < height; row++ {
+					if indexes[(top+row)*width+x] == index {
+						bits |= 1 << uint(row)
+					}
+				}
+				char := 63 + bits
+				if char == last {
+					run++
+				} else {
+					flush()
+					last, run = char, 1
+				}
+			}
+			flush()
+			out.WriteString("$")
+		}
+		out.WriteString("-")
+	}
+	out.WriteString("\x1b\\")
+	return out.String()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Icon in the system tray with a menu to refresh the artwork or quit. It's
+// shown by a helper process, so no GUI toolkit is built in: yad on Linux,
+// PowerShell with Windows Forms on Windows and JavaScript for Automation on
+// macOS. The helper prints "refresh" when asked to, and exits on Quit.
+type TrayIcon struct {
+	cmd *exec.Cmd
+	// Receives each refresh asked from the menu, and is closed when the
+	// icon is gone, by its Quit item or otherwise.
+	actions chan string
+}
+
+// The icon of the current tray, removed before exiting on errors.
+var activeTrayIcon *TrayIcon
+
+// Returns the command of the helper showing the tray icon on this platform.
+func getTrayCommand() (*exec.Cmd, error) {
+	refresh, quit := tr("Refresh now"), tr("Quit")
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		if _, err := exec.LookPath("yad"); err != nil {
+			return nil, errors.New(tr("The tray icon needs yad, install it with your package manager."))
+		}
+		// Menu items are "label!command", separated by "|", and yad quits by
+		// itself with the "quit" command.
+		label := strings.NewReplacer("!", "", "|", "")
+		menu := label.Replace(refresh) + "!echo refresh|" + label.Replace(quit) + "!quit"
+		return exec.Command("yad", "--notification", "--image=steam", "--text=SteamGrid", "--command=menu", "--menu="+menu), nil
+	case "windows":
+		quote := func(text string) string {
+			return "'" + strings.Replace(text, "'", "''", -1) + "'"
+		}
+		script := `Add-Type -AssemblyName System.Windows.Forms, System.Drawing
+$icon = New-Object System.Windows.Forms.NotifyIcon
+$icon.Icon = [System.Drawing.SystemIcons]::Application
+$icon.Text = 'SteamGrid'
+$menu = New-Object System.Windows.Forms.ContextMenuStrip
+$menu.Items.Add(` + quote(refresh) + `).add_Click({ [Console]::Out.WriteLine('refresh'); [Console]::Out.Flush() })
+$menu.Items.Add(` + quote(quit) + `).add_Click({ $icon.Visible = $false; [System.Windows.Forms.Application]::Exit() })
+$icon.ContextMenuStrip = $menu
+$icon.Visible = $true
+[System.Windows.Forms.Application]::Run()`
+		return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Sta", "-Command", script), nil
+	case "darwin":
+		// JSON strings are valid JavaScript strings.
+		quote := func(text string) string {
+			quoted, _ := json.Marshal(text)
+			return string(quoted)
+		}
+		script := `ObjC.import('Cocoa');
+function say(line) {
+	$.NSFileHandle.fileHandleWithStandardOutput.writeData($(line + '\n').dataUsingEncoding($.NSUTF8StringEncoding));
+}
+ObjC.registerSubclass({
+	name: 'SteamGridTray',
+	methods: {
+		'refresh:': {types: ['void', ['id']], implementation: function (sender) { say('refresh'); }},
+		'quit:': {types: ['void', ['id']], implementation: function (sender) { $.NSApp.terminate(null); }}
+	}
+});
+var app = $.NSApplication.sharedApplication;
+app.setActivationPolicy($.NSApplicationActivationPolicyAccessory);
+var item = $.NSStatusBar.systemStatusBar.statusItemWithLength($.NSVariableStatusItemLength);
+item.button.title = 'SteamGrid';
+var target = $.SteamGridTray.alloc.init;
+var menu = $.NSMenu.alloc.init;
+[[` + quote(refresh) + `, 'refresh:'], [` + quote(quit) + `, 'quit:']].forEach(function (entry) {
+	var menuItem = $.NSMenuItem.alloc.initWithTitleActionKeyEquivalent(entry[0], entry[1], '');
+	menuItem.target = target;
+	menu.addItem(menuItem);
+});
+item.menu = menu;
+app.run;`
+		return exec.Command("osascript", "-l", "JavaScript", "-e", script), nil
+	}
+	return nil, errors.New(tr("The tray icon is not supported on %v.", runtime.GOOS))
+}
+
+// Shows the tray icon.
+func StartTrayIcon() (*TrayIcon, error) {
+	cmd, err := getTrayCommand()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	icon := &TrayIcon{cmd: cmd, actions: make(chan string)}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if action := strings.TrimSpace(scanner.Text()); action != "" {
+				icon.actions <- action
+			}
+		}
+		cmd.Wait()
+		close(icon.actions)
+	}()
+	activeTrayIcon = icon
+	return icon, nil
+}
+
+// Removes the icon, if it's still there.
+func (icon *TrayIcon) Close() {
+	if icon == nil || activeTrayIcon != icon {
+		return
+	}
+	activeTrayIcon = nil
+	icon.cmd.Process.Kill()
+}
+
+// Progress of the runs started from the tray, like in the console but also
+// stopped after the current game when the icon is gone.
+type trayProgress struct {
+	*consoleProgress
+	quit <-chan bool
+}
+
+// Returns false once asked to stop, from the console or the tray.
+func (progress *trayProgress) FinishGame() bool {
+	select {
+	case <-progress.quit:
+		return false
+	default:
+		return progress.consoleProgress.FinishGame()
+	}
+}
+
+// Returns the flag set of the tray command.
+func newTrayFlags() *flag.FlagSet {
+	flags := newCommandFlags("tray")
+	addDownloadFlags(flags)
+	addScheduleFlags(flags)
+	return flags
+}
+
+// Sits in the system tray and downloads the images on the --refresh
+// schedule, or when asked from the menu of the icon, with a notification
+// after each run. Quitting from the menu during a run lets the game being
+// processed finish first.
+func runTray(args []string) {
+	flags := newTrayFlags()
+	parseCommandFlags(flags, args)
+	// It runs unattended, and the notifications tell how the runs went.
+	*headless = true
+	*terminalUI = false
+	*notify = true
+	checkDownloadFlags()
+	checkForUpdates()
+
+	scheduled, err := startSchedule()
+	if err != nil {
+		errorAndExit(err)
+	}
+	icon, err := StartTrayIcon()
+	if err != nil {
+		errorAndExit(err)
+	}
+	defer icon.Close()
+
+	// Refreshes asked during a run make one more run after it, however many
+	// there were.
+	refresh := make(chan bool, 1)
+	quit := make(chan bool)
+	go func() {
+		for action := range icon.actions {
+			if action == "refresh" {
+				select {
+				case refresh <- true:
+				default:
+				}
+			}
+		}
+		close(quit)
+	}()
+
+	progress := &trayProgress{newConsoleProgress(), quit}
+	fmt.Println(tr("SteamGrid is in the system tray, pick 'Refresh now' in its menu to update the images."))
+	for {
+		select {
+		case <-progress.stopped:
+			return
+		case <-quit:
+			return
+		case <-scheduled:
+			fmt.Print(tr("\n%v: scheduled run.\n", time.Now().Format("2006-01-02 15:04:05")))
+		case <-refresh:
+			fmt.Print(tr("\n%v: refresh asked from the tray.\n", time.Now().Format("2006-01-02 15:04:05")))
+		}
+		runDownload(flags.Args(), progress)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Number of lines of the log pane, under the list of games.
+const tuiLogLines = 5
+
+// Full screen terminal UI for download runs, with a scrolling list of the
+// games and their state, the overall progress, and keys to pause and cancel
+// the run between games. Everything the run prints goes to the log pane.
+// All methods do nothing on a nil UI, so the run doesn't have to check.
+type TerminalUI struct {
+	mutex  sync.Mutex
+	paused *sync.Cond
+	// The real stdout, since os.Stdout is redirected to the log pane.
+	stdout     *os.File
+	pipeWriter *os.File
+	logDone    chan bool
+	sttyState  string
+	rows, cols int
+
+	user      string
+	total     int
+	done      int
+	games     []*tuiGame
+	gameIndex map[string]*tuiGame
+	log       []string
+	stats     RunStats
+	isPaused  bool
+	cancelled bool
+	lastDraw  time.Time
+}
+
+// Line of the games list.
+type tuiGame struct {
+	Name  string
+	State string
+}
+
+// The UI of the current run, stopped before exiting on errors so the
+// terminal is usable again.
+var activeTerminalUI *TerminalUI
+
+// Runs stty on the terminal and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Takes over the terminal. Fails on Windows and when nobody is watching.
+func StartTerminalUI() (*TerminalUI, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New(tr("The terminal UI is not supported on Windows yet."))
+	}
+	if isHeadless() {
+		return nil, errors.New(tr("The terminal UI needs an interactive terminal."))
+	}
+
+	ui := &TerminalUI{stdout: os.Stdout, rows: 24, cols: 80, gameIndex: make(map[string]*tuiGame), logDone: make(chan bool)}
+	ui.paused = sync.NewCond(&ui.mutex)
+	if size, err := stty("size"); err == nil {
+		if parts := strings.Fields(size); len(parts) == 2 {
+			rows, errRows := strconv.Atoi(parts[0])
+			cols, errCols := strconv.Atoi(parts[1])
+			if errRows == nil && errCols == nil && rows > tuiLogLines+6 && cols >= 40 {
+				ui.rows, ui.cols = rows, cols
+			}
+		}
+	}
+
+	// Single key presses, without echo.
+	state, err := stty("-g")
+	if err != nil {
+		return nil, errors.New(tr("Failed to configure the terminal: %v", err.Error()))
+	}
+	ui.sttyState = state
+	if _, err := stty("cbreak", "-echo"); err != nil {
+		return nil, errors.New(tr("Failed to configure the terminal: %v", err.Error()))
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		stty(ui.sttyState)
+		return nil, err
+	}
+	ui.pipeWriter = writer
+	os.Stdout = writer
+	go ui.readLog(reader)
+	go ui.readKeys()
+
+	// Ctrl+C cancels after the current game, the second one quits at once.
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		ui.Cancel()
+		<-interrupts
+		ui.Stop()
+		os.Exit(exitCancelled)
+	}()
+
+	fmt.Fprint(ui.stdout, "\x1b[?25l")
+	activeTerminalUI = ui
+	ui.draw(true)
+	return ui, nil
+}
+
+// Moves printed lines to the log pane.
+func (ui *TerminalUI) readLog(reader *os.File) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		ui.mutex.Lock()
+		ui.log = append(ui.log, scanner.Text())
+		if len(ui.log) > tuiLogLines {
+			ui.log = ui.log[len(ui.log)-tuiLogLines:]
+		}
+		ui.mutex.Unlock()
+		ui.draw(false)
+	}
+	reader.Close()
+	ui.logDone <- true
+}
+
+// Handles the key bindings: p pauses and resumes, q cancels.
+func (ui *TerminalUI) readKeys() {
+	key := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(key); err != nil || n == 0 {
+			return
+		}
+		switch key[0] {
+		case 'p', 'P', ' ':
+			ui.mutex.Lock()
+			ui.isPaused = !ui.isPaused
+			ui.paused.Broadcast()
+			ui.mutex.Unlock()
+			ui.draw(true)
+		case 'q', 'Q':
+			ui.Cancel()
+		}
+	}
+}
+
+// Starts the list of games of a new user.
+func (ui *TerminalUI) StartUser(name string, nGames int) {
+	if ui == nil {
+		return
+	}
+	ui.mutex.Lock()
+	ui.user, ui.total, ui.done = name, nGames, 0
+	ui.games = nil
+	ui.gameIndex = make(map[string]*tuiGame)
+	ui.mutex.Unlock()
+	ui.draw(true)
+}
+
+// Shows the state of an image, like "downloading" or "not found".
+func (ui *TerminalUI) SetState(game *Game, asset *AssetType, state string) {
+	if ui == nil {
+		return
+	}
+	key := game.Id + asset.Suffix
+	ui.mutex.Lock()
+	row, ok := ui.gameIndex[key]
+	if !ok {
+		name := game.Name
+		if name == "" {
+			name = tr("unknown game with id %v", game.Id)
+		}
+		if asset != bannerAsset {
+			name += " (" + asset.Name + ")"
+		}
+		row = &tuiGame{Name: name}
+		ui.gameIndex[key] = row
+		ui.games = append(ui.games, row)
+	}
+	row.State = state
+	ui.mutex.Unlock()
+	ui.draw(false)
+}
+
+// Keeps the overall progress for the header.
+func (ui *TerminalUI) SetStats(stats RunStats) {
+	if ui == nil {
+		return
+	}
+	ui.mutex.Lock()
+	ui.stats = stats
+	ui.mutex.Unlock()
+	ui.draw(false)
+}
+
+// Counts a game as done, waits while the run is paused, and returns false
+// if the run was cancelled and should stop.
+func (ui *TerminalUI) FinishGame() bool {
+	if ui == nil {
+		return true
+	}
+	ui.mutex.Lock()
+	ui.done++
+	ui.mutex.Unlock()
+	ui.draw(true)
+
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	for ui.isPaused && !ui.cancelled {
+		ui.paused.Wait()
+	}
+	return !ui.cancelled
+}
+
+// Asks the run to stop after the current game.
+func (ui *TerminalUI) Cancel() {
+	if ui == nil {
+		return
+	}
+	ui.mutex.Lock()
+	ui.cancelled = true
+	ui.paused.Broadcast()
+	ui.mutex.Unlock()
+	ui.draw(true)
+}
+
+// Gives the terminal back, so the report can be printed as usual.
+func (ui *TerminalUI) Stop() {
+	if ui == nil || activeTerminalUI != ui {
+		return
+	}
+	activeTerminalUI = nil
+	os.Stdout = ui.stdout
+	ui.pipeWriter.Close()
+	<-ui.logDone
+	ui.draw(true)
+	stty(ui.sttyState)
+	fmt.Fprint(ui.stdout, "\x1b[?25h\n")
+	// The key reader is still waiting for input and would eat the enter.
+	// The UI was started from a terminal anyway, which stays open.
+	*headless = true
+}
+
+// Cuts a line to the terminal width.
+func (ui *TerminalUI) fit(line string) string {
+	if utf8.RuneCountInString(line) <= ui.cols {
+		return line
+	}
+	return string([]rune(line)[:ui.cols-1]) + "…"
+}
+
+// ANSI color of each state.
+func tuiStateColor(state string) string {
+	switch {
+	case strings.HasPrefix(state, "done"):
+		return "\x1b[32m"
+	case strings.HasPrefix(state, "failed"):
+		return "\x1b[31m"
+	case state == "not found" || state == "skipped":
+		return "\x1b[33m"
+	}
+	return "\x1b[36m"
+}
+
+// Redraws the screen, at most every few milliseconds unless forced.
+func (ui *TerminalUI) draw(force bool) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	if !force && time.Since(ui.lastDraw) < 50*time.Millisecond {
+		return
+	}
+	ui.lastDraw = time.Now()
+
+	var screen strings.Builder
+	screen.WriteString("\x1b[H\x1b[2J")
+
+	percent := 0
+	if ui.total > 0 {
+		percent = 100 * ui.done / ui.total
+	}
+	barWidth := 30
+	filled := barWidth * percent / 100
+	status := ""
+	if ui.cancelled {
+		status = "  " + tr("CANCELLING")
+	} else if ui.isPaused {
+		status = "  " + tr("PAUSED")
+	}
+	screen.WriteString(ui.fit(fmt.Sprintf("SteamGrid - %v  [%v%v] %v/%v (%v%%)%v", ui.user, strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled), ui.done, ui.total, percent, status)) + "\n")
+	screen.WriteString(ui.fit(tr("Overall: %v", ui.stats)) + "\n")
+	screen.WriteString(ui.fit(tr("p: pause/resume   q: cancel after the current game")) + "\n\n")
+
+	listHeight := ui.rows - tuiLogLines - 6
+	first := len(ui.games) - listHeight
+	if first < 0 {
+		first = 0
+	}
+	for i := first; i < first+listHeight; i++ {
+		if i < len(ui.games) {
+			row := ui.games[i]
+			stateWidth := 20
+			nameWidth := ui.cols - stateWidth - 2
+			name := row.Name
+			if utf8.RuneCountInString(name) > nameWidth {
+				name = string([]rune(name)[:nameWidth-1]) + "…"
+			}
+			padding := strings.Repeat(" ", nameWidth-utf8.RuneCountInString(name))
+			screen.WriteString(name + padding + "  " + tuiStateColor(row.State) + ui.fit(row.State) + "\x1b[0m")
+		}
+		screen.WriteString("\n")
+	}
+
+	screen.WriteString(strings.Repeat("-", ui.cols) + "\n")
+	for i := 0; i < tuiLogLines; i++ {
+		if i < len(ui.log) {
+			screen.WriteString(ui.fit(ui.log[i]))
+		}
+		if i < tuiLogLines-1 {
+			screen.WriteString("\n")
+		}
+	}
+	fmt.Fprint(ui.stdout, screen.String())
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of this build, compared with the tag of the latest release. It can
+// be set when building, with -ldflags "-X main.version=1.4.0".
+var version = "1.3.0"
+
+// Where the releases are published.
+const (
+	latestReleaseUrl = "https://api.github.com/repos/boppreh/steamgrid/releases/latest"
+	releasesPageUrl  = "https://github.com/boppreh/steamgrid/releases/latest"
+)
+
+// Names of the release files with the SHA-256 of the others, in the format
+// of sha256sum. A file named after the zip plus ".sha256" works too.
+var releaseChecksumNames = []string{"checksums.txt", "SHA256SUMS", "sha256sums.txt"}
+
+// File in the repository listing the image sources and APIs that stopped
+// working, so older builds can tell their users.
+const endpointStatusUrl = "https://raw.githubusercontent.com/boppreh/steamgrid/master/endpoints.json"
+
+// How often the startup check asks GitHub, and how long it waits for it.
+const (
+	startupCheckInterval = time.Hour * 24
+	startupCheckTimeout  = time.Second * 3
+)
+
+var (
+	updateCheckOnly = new(bool)
+	updateForce     = new(bool)
+	noUpdateCheck   = new(bool)
+)
+
+// Release as listed by the GitHub API.
+type Release struct {
+	TagName string `json:"tag_name"`
+	HtmlUrl string `json:"html_url"`
+	Assets  []struct {
+		Name string `json:"name"`
+		Url  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// Returns the download URL of the release file with the given name, or ""
+// if there's none.
+func (release *Release) AssetUrl(name string) string {
+	for _, asset := range release.Assets {
+		if strings.EqualFold(asset.Name, name) {
+			return asset.Url
+		}
+	}
+	return ""
+}
+
+// Returns the name of the release zip for this system, like
+// "steamgrid-windows.zip", preferring one for this architecture too.
+func (release *Release) PlatformAssetName() (string, error) {
+	for _, name := range []string{"steamgrid-" + runtime.GOOS + "-" + runtime.GOARCH + ".zip", "steamgrid-" + runtime.GOOS + ".zip"} {
+		if release.AssetUrl(name) != "" {
+			return name, nil
+		}
+	}
+	return "", errors.New(tr("Release %v has no build for %v/%v, see %v", release.TagName, runtime.GOOS, runtime.GOARCH, releasesPageUrl))
+}
+
+// Loads the latest release from GitHub.
+func getLatestRelease() (*Release, error) {
+	release := &Release{}
+	found, err := getJson(latestReleaseUrl, release)
+	if err != nil {
+		return nil, err
+	}
+	if !found || release.TagName == "" {
+		return nil, errors.New(tr("No release found at %v", latestReleaseUrl))
+	}
+	return release, nil
+}
+
+// Compares two versions like "v1.3.0" and "1.10", number by number. Returns
+// a negative number if a is older, zero if they're the same and a positive
+// number if a is newer.
+func compareVersions(a string, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(strings.TrimPrefix(strings.TrimSpace(v), "v"), func(r rune) bool {
+			return r == '.' || r == '-'
+		})
+	}
+	aParts, bParts := split(a), split(b)
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aNumber, bNumber := 0, 0
+		if i < len(aParts) {
+			aNumber, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bNumber, _ = strconv.Atoi(bParts[i])
+		}
+		if aNumber != bNumber {
+			return aNumber - bNumber
+		}
+	}
+	return 0
+}
+
+// Downloads a release file into memory.
+func downloadReleaseFile(url string) ([]byte, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		logf(LogDebug, "Request failed: %v", err.Error())
+		return nil, err
+	}
+	defer response.Body.Close()
+	logEvent(LogDebug, "request", LogFields{"url": url, "status": response.StatusCode}, "GET %v: %v", url, response.Status)
+	if response.StatusCode >= 400 {
+		return nil, errors.New(tr("Failed to download %v: %v", url, response.Status))
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// Returns the SHA-256 published for the release file with the given name,
+// from the checksums file of the release.
+func getReleaseChecksum(release *Release, name string) (string, error) {
+	names := append([]string{name + ".sha256"}, releaseChecksumNames...)
+	for _, checksumName := range names {
+		url := release.AssetUrl(checksumName)
+		if url == "" {
+			continue
+		}
+		checksums, err := downloadReleaseFile(url)
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(string(checksums), "\n") {
+			fields := strings.Fields(line)
+			// A lone hash in the .sha256 file, or "HASH  NAME" lines, where
+			// binary files have a "*" before the name.
+			if len(fields) == 1 && checksumName == name+".sha256" {
+				return strings.ToLower(fields[0]), nil
+			}
+			if len(fields) == 2 && strings.EqualFold(strings.TrimPrefix(fields[1], "*"), name) {
+				return strings.ToLower(fields[0]), nil
+			}
+		}
+		return "", errors.New(tr("%v of release %v has no checksum for %v", checksumName, release.TagName, name))
+	}
+	return "", errors.New(tr("Release %v has no checksums, so it can't be verified. Download it from %v instead.", release.TagName, releasesPageUrl))
+}
+
+// Returns the program inside a release zip.
+func extractReleaseBinary(zipBytes []byte) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if err != nil {
+		return nil, err
+	}
+	name := "steamgrid"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	for _, file := range archive.File {
+		if filepath.Base(file.Name) != name || file.FileInfo().IsDir() {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	}
+	return nil, errors.New(tr("The release zip has no %v", name))
+}
+
+// Replaces the program at path with the new one. The new program is written
+// next to it first, so a failed write leaves the old one working. Windows
+// doesn't allow replacing a running program, but allows renaming it, so the
+// old one is moved aside and deleted by the next update.
+func replaceExecutable(path string, newBytes []byte) error {
+	newPath, oldPath := path+".new", path+".old"
+	if err := ioutil.WriteFile(newPath, newBytes, 0755); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		os.Remove(oldPath)
+		if err := os.Rename(path, oldPath); err != nil {
+			os.Remove(newPath)
+			return err
+		}
+		if err := os.Rename(newPath, path); err != nil {
+			os.Rename(oldPath, path)
+			return err
+		}
+		return nil
+	}
+	if err := os.Rename(newPath, path); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+	return nil
+}
+
+// Returns the flag set of the update command.
+func newUpdateFlags() *flag.FlagSet {
+	flags := newCommandFlags("update")
+	flags.BoolVar(updateCheckOnly, "check", false, "Only say if there's a newer release, without installing it.")
+	flags.BoolVar(updateForce, "force", false, "Install the latest release even if this one is as new.")
+	return flags
+}
+
+// Replaces this program with the latest release from GitHub, after checking
+// its SHA-256 against the one published with it. Only the program is
+// replaced, the overlays and other files next to it are left as they are.
+func runUpdate(args []string) {
+	flags := newUpdateFlags()
+	parseCommandFlags(flags, args)
+
+	path, err := getExecutablePath()
+	if err != nil {
+		errorAndExit(err)
+	}
+	// Left by the last update on Windows.
+	os.Remove(path + ".old")
+
+	release, err := getLatestRelease()
+	if err != nil {
+		errorAndExit(errors.New(tr("Failed to check for a new release: %v", err.Error())))
+	}
+	if compareVersions(release.TagName, version) <= 0 && !*updateForce {
+		fmt.Println(tr("You have the latest version, %v.", version))
+		return
+	}
+	fmt.Println(tr("Version %v is available, you have %v.", strings.TrimPrefix(release.TagName, "v"), version))
+	if *updateCheckOnly {
+		fmt.Println(tr("Run 'steamgrid update' to install it, or download it from %v", releasesPageUrl))
+		return
+	}
+
+	name, err := release.PlatformAssetName()
+	if err != nil {
+		errorAndExit(err)
+	}
+	expected, err := getReleaseChecksum(release, name)
+	if err != nil {
+		errorAndExit(err)
+	}
+	fmt.Print(tr("Downloading %v...", name))
+	zipBytes, err := downloadReleaseFile(release.AssetUrl(name))
+	if err != nil {
+		errorAndExit(err)
+	}
+	sum := sha256.Sum256(zipBytes)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		errorAndExit(errors.New(tr("The checksum of %v doesn't match the published one, so it was not installed. Expected %v, got %v.", name, expected, actual)))
+	}
+	fmt.Println(tr(" checksum verified."))
+
+	newBytes, err := extractReleaseBinary(zipBytes)
+	if err != nil {
+		errorAndExit(err)
+	}
+	if err := replaceExecutable(path, newBytes); err != nil {
+		errorAndExit(errors.New(tr("Failed to replace %v: %v. Run the update as a user that can write there, or download it from %v", path, err.Error(), releasesPageUrl)))
+	}
+	logEvent(LogInfo, "updated", LogFields{"from": version, "to": release.TagName, "path": path}, "Updated %v from %v to %v", path, version, release.TagName)
+	fmt.Println(tr("Updated to version %v.", strings.TrimPrefix(release.TagName, "v")))
+}
+
+// Endpoint that stopped working, as listed in endpoints.json. Every URL this
+// build uses that starts with Prefix is dead.
+type DeadEndpoint struct {
+	Prefix string `json:"prefix"`
+	// What happened and what to do, like "Google removed the old image
+	// search, update to 1.4.0 to search on SteamGridDB instead."
+	Message string `json:"message"`
+}
+
+// Result of the startup check, cached between runs.
+type StartupCheck struct {
+	LatestVersion string         `json:"latest_version"`
+	DeadEndpoints []DeadEndpoint `json:"dead"`
+}
+
+// Returns the URLs this build downloads from, to be compared with the dead
+// ones.
+func getKnownEndpoints() []string {
+	endpoints := []string{googleSearchFormat, profilePermalinkFormat, storeDetailsUrlFormat, reviewsUrlFormat, achievementsUrlFormat,
+		howLongToBeatSearchUrl, deckCompatibilityUrlFormat, protonDbUrlFormat, wishlistUrlFormat, capsuleUrlFormat}
+	for _, asset := range assetTypes {
+		endpoints = append(endpoints, asset.UrlFormats...)
+	}
+	return endpoints
+}
+
+// Asks GitHub for the latest release and the dead endpoints, giving up
+// quickly so a slow connection doesn't delay the run.
+func downloadStartupCheck() (*StartupCheck, error) {
+	client := &http.Client{Timeout: startupCheckTimeout}
+	check := &StartupCheck{DeadEndpoints: []DeadEndpoint{}}
+	for _, url := range []string{latestReleaseUrl, endpointStatusUrl} {
+		response, err := client.Get(url)
+		if err != nil {
+			logf(LogDebug, "Request failed: %v", err.Error())
+			return nil, err
+		}
+		logEvent(LogDebug, "request", LogFields{"url": url, "status": response.StatusCode}, "GET %v: %v", url, response.Status)
+		if response.StatusCode == 404 {
+			// No release or no dead endpoints yet.
+			response.Body.Close()
+			continue
+		} else if response.StatusCode >= 400 {
+			response.Body.Close()
+			return nil, errors.New(tr("Failed to get %v: %v", url, response.Status))
+		}
+		if url == latestReleaseUrl {
+			release := &Release{}
+			err = json.NewDecoder(response.Body).Decode(release)
+			check.LatestVersion = release.TagName
+		} else {
+			err = json.NewDecoder(response.Body).Decode(check)
+		}
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return check, nil
+}
+
+// Warns when there's a newer release, or when some image source or API this
+// build uses is known to be dead, which explains images that can't be
+// found anymore. GitHub is asked at most once a day, and nothing is said if
+// it can't be reached. Disabled with --no-update-check.
+func checkForUpdates() {
+	if *noUpdateCheck {
+		return
+	}
+	check := &StartupCheck{}
+	if !readCache("update-check", "latest", startupCheckInterval, check) {
+		var err error
+		check, err = downloadStartupCheck()
+		if err != nil {
+			logf(LogVerbose, "Failed to check for updates: %v", err.Error())
+			return
+		}
+		if err := writeCache("update-check", "latest", check); err != nil {
+			logf(LogDebug, "Failed to cache the update check: %v", err.Error())
+		}
+	}
+
+	if check.LatestVersion != "" && compareVersions(check.LatestVersion, version) > 0 {
+		fmt.Println(tr("Version %v is available, you have %v. Run 'steamgrid update' to install it.", strings.TrimPrefix(check.LatestVersion, "v"), version))
+	}
+	warned := make(map[string]bool)
+	for _, endpoint := range getKnownEndpoints() {
+		for _, dead := range check.DeadEndpoints {
+			if dead.Prefix == "" || warned[dead.Prefix] || !strings.HasPrefix(endpoint, dead.Prefix) {
+				continue
+			}
+			warned[dead.Prefix] = true
+			logEvent(LogWarning, "dead_endpoint", LogFields{"prefix": dead.Prefix, "message": dead.Message}, "%v no longer works: %v", dead.Prefix, dead.Message)
+			fmt.Println(tr("Warning: this version uses %v, which no longer works. %v", dead.Prefix, dead.Message))
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -34,6 +33,7 @@ func GetUsers(installationDir string) ([]User, error) {
 	}
 
 	users := make([]User, 0)
+	loginUsers := loadLoginUsers(installationDir)
 
 	for _, userDir := range files {
 		userId := userDir.Name()
@@ -58,24 +58,170 @@ func GetUsers(installationDir string) ([]User, error) {
 			return nil, err
 		}
 
-		// The Linux version of Steam ships with the "grid" dir without executable bit.
-		// This in turn denies permission to everything inside the folder. This line is
-		// here to ensure we have the correct permission.
-		fmt.Println("Setting permission...")
-		os.Chmod(gridDir, 0777)
-
-		pattern := regexp.MustCompile(`"PersonaName"\s*"(.+?)"`)
-		username := pattern.FindStringSubmatch(string(configBytes))[1]
+		if err := fixGridPermissions(gridDir); err != nil {
+			fmt.Print(tr("Failed to fix the permissions of %v: %v\n", gridDir, err.Error()))
+		}
 
 		steamId32, err := strconv.ParseInt(userId, 10, 64)
 		steamId64 := steamId32 + idConversionConstant
 		strSteamId64 := strconv.FormatInt(steamId64, 10)
+
+		// The persona name is in the local config, but some accounts don't
+		// have it there, so it falls back to the accounts that logged in on
+		// this computer, and then to the id.
+		username := ""
+		config, err := ParseVdf(configBytes)
+		if err != nil {
+			logf(LogWarning, "Failed to read %v: %v", configFile, err.Error())
+		} else {
+			username = config.Path("UserLocalConfigStore", "friends").Get("PersonaName")
+		}
+		if loginUser := loginUsers.Child(strSteamId64); username == "" && loginUser != nil {
+			username = loginUser.Get("PersonaName")
+			if username == "" {
+				username = loginUser.Get("AccountName")
+			}
+		}
+		if username == "" {
+			username = userId
+		}
 		users = append(users, User{username, userId, strSteamId64, userDir})
 	}
 
 	return users, nil
 }
 
+// How to fix the permissions of the grid folders, given with
+// --grid-permissions: "auto" to add only the ones we are missing, "off" to
+// never change them, or an octal mode like "755".
+var gridPermissions = new(string)
+
+// Returns the mode given with --grid-permissions, or 0 for auto and off.
+func parseGridPermissions() (os.FileMode, error) {
+	switch *gridPermissions {
+	case "", "auto", "off":
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(*gridPermissions, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New(tr("Unknown --grid-permissions '%v', expected auto, off or an octal mode like 755.", *gridPermissions))
+	}
+	return os.FileMode(mode), nil
+}
+
+// Makes sure we can list and write the grid folder. The Linux version of
+// Steam ships with the "grid" dir without executable bit, which denies
+// permission to everything inside it, so by default only the missing owner
+// permissions are added, leaving the others to the user.
+func fixGridPermissions(gridDir string) error {
+	info, err := os.Stat(gridDir)
+	if err != nil {
+		return err
+	}
+	current := info.Mode().Perm()
+	wanted := current | 0700
+	if *gridPermissions == "off" {
+		if wanted != current {
+			logf(LogWarning, "%v has permissions %v, leaving them as they are", gridDir, current)
+		}
+		return nil
+	}
+	if mode, err := parseGridPermissions(); err != nil {
+		return err
+	} else if mode != 0 {
+		wanted = mode
+	}
+	if wanted == current {
+		return nil
+	}
+	fmt.Print(tr("Changing the permissions of %v from %v to %v.\n", gridDir, current, wanted))
+	logf(LogInfo, "Changing the permissions of %v from %v to %v", gridDir, current, wanted)
+	return os.Chmod(gridDir, wanted)
+}
+
+// Loads the accounts that logged in on this computer, from
+// config/loginusers.vdf in the Steam installation, by SteamId64. Returns nil
+// if it can't be read.
+func loadLoginUsers(installationDir string) *VdfNode {
+	loginUsersFile := filepath.Join(installationDir, "config", "loginusers.vdf")
+	loginUsersBytes, err := ioutil.ReadFile(loginUsersFile)
+	if err != nil {
+		return nil
+	}
+	root, err := ParseVdf(loginUsersBytes)
+	if err != nil {
+		logf(LogWarning, "Failed to read %v: %v", loginUsersFile, err.Error())
+		return nil
+	}
+	return root.Child("users")
+}
+
+// Returns the users matching any of the comma separated names or ids in the
+// filter, which may be persona names (case insensitive), SteamId32s or
+// SteamId64s. An empty filter returns all users.
+func FilterUsers(users []User, filter string) ([]User, error) {
+	wanted := splitList(filter)
+	if len(wanted) == 0 {
+		return users, nil
+	}
+
+	filtered := make([]User, 0)
+	for _, user := range users {
+		for _, name := range wanted {
+			if strings.EqualFold(user.Name, name) || user.SteamId32 == name || user.SteamId64 == name {
+				filtered = append(filtered, user)
+				break
+			}
+		}
+	}
+	if len(filtered) == 0 {
+		names := make([]string, 0, len(users))
+		for _, user := range users {
+			names = append(names, user.Name+" ("+user.SteamId32+")")
+		}
+		return nil, errors.New("No Steam user matches '" + filter + "'. The users in this computer are: " + strings.Join(names, ", "))
+	}
+	return filtered, nil
+}
+
+// Asks which of the users to process, on computers shared by several Steam
+// accounts, so the others are left alone. The answer is a list of numbers
+// from the list, names or ids, and an empty answer picks everyone.
+func ChooseUsers(users []User) []User {
+	fmt.Print(tr("\n%v Steam users use this computer:\n", len(users)))
+	for i, user := range users {
+		fmt.Printf("  %v) %v (%v)\n", i+1, user.Name, user.SteamId32)
+	}
+	for {
+		fmt.Print(tr("Which ones should be processed? Type their numbers or names separated by commas, or press enter for all of them (--user skips this question): "))
+		answer, err := stdinReader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" || err != nil {
+			return users
+		}
+
+		chosen := make([]User, 0)
+		valid := true
+		for _, item := range splitList(answer) {
+			if n, err := strconv.Atoi(item); err == nil && n >= 1 && n <= len(users) {
+				chosen = append(chosen, users[n-1])
+				continue
+			}
+			matched, err := FilterUsers(users, item)
+			if err != nil {
+				fmt.Println(err.Error())
+				valid = false
+				break
+			}
+			chosen = append(chosen, matched...)
+		}
+		if valid {
+			fmt.Println()
+			return chosen
+		}
+	}
+}
+
 // URL to get the game list from the SteamId64.
 const profilePermalinkFormat = `http://steamcommunity.com/profiles/%v/games?tab=all`
 
@@ -87,12 +233,18 @@ const steamProfileErrorMessage = `The specified profile could not be found.`
 
 // Returns the HTML profile from a user from their SteamId32.
 func GetProfile(user User) (string, error) {
-	response, err := http.Get(fmt.Sprintf(profilePermalinkFormat, user.SteamId64))
+	response, err := httpGet(fmt.Sprintf(profilePermalinkFormat, user.SteamId64))
 	if err != nil {
+		logf(LogDebug, "Loading the profile of %v failed: %v", user.Name, err.Error())
 		return "", err
 	}
+	logf(LogDebug, "GET %v: %v", fmt.Sprintf(profilePermalinkFormat, user.SteamId64), response.Status)
 
-	if response.StatusCode >= 400 {
+	if response.StatusCode == http.StatusTooManyRequests {
+		closeResponse(response)
+		return "", errors.New("Steam is limiting the requests for profiles, try again in a few minutes.")
+	} else if response.StatusCode >= 400 {
+		closeResponse(response)
 		return "", errors.New("Profile not found. Make sure you have a public Steam profile.")
 	}
 
@@ -112,10 +264,11 @@ func GetProfile(user User) (string, error) {
 
 // Returns the Steam installation directory in Windows. Should work for
 // internationalized systems, 32 and 64 bits and users that moved their
-// ProgramFiles folder. If a folder is given by program parameter, uses that.
-func GetSteamInstallation() (path string, err error) {
-	if len(os.Args) == 2 {
-		argDir := os.Args[1]
+// ProgramFiles folder. If a folder is given in the command arguments, uses
+// that.
+func GetSteamInstallation(args []string) (path string, err error) {
+	if len(args) == 1 {
+		argDir := args[0]
 		_, err := os.Stat(argDir)
 		if err == nil {
 			return argDir, nil
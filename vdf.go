@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Node of a text VDF document, the KeyValues format Steam uses for its
+// config files. A node is either a string value or a block of children,
+// which are kept in file order so the document can be written back without
+// reshuffling the user's data.
+type VdfNode struct {
+	Key      string
+	Value    string
+	Children []*VdfNode
+	// True if the node is a { } block, false if it's a string value.
+	IsBlock bool
+}
+
+// Parses the contents of a text VDF file. The returned node is an unnamed
+// block containing the top level entries.
+func ParseVdf(data []byte) (*VdfNode, error) {
+	p := &vdfParser{data: bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))}
+	root := &VdfNode{IsBlock: true}
+	if err := p.parseChildren(root, false); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+type vdfParser struct {
+	data []byte
+	pos  int
+}
+
+// Skips whitespace, // comments and platform conditionals like [$WIN32],
+// which Steam's own files don't depend on.
+func (p *vdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			p.pos++
+		} else if c == '/' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '/' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		} else if c == '[' && p.pos+1 < len(p.data) && (p.data[p.pos+1] == '$' || p.data[p.pos+1] == '!') {
+			for p.pos < len(p.data) && p.data[p.pos] != ']' {
+				p.pos++
+			}
+			p.pos++
+		} else {
+			return
+		}
+	}
+}
+
+// Reads a quoted or bare token. Quoted tokens may contain escaped quotes and
+// backslashes.
+func (p *vdfParser) readToken() (string, error) {
+	if p.data[p.pos] != '"' {
+		start := p.pos
+		for p.pos < len(p.data) && !strings.ContainsRune(" \t\r\n{}\"", rune(p.data[p.pos])) {
+			p.pos++
+		}
+		return string(p.data[start:p.pos]), nil
+	}
+
+	p.pos++
+	var token bytes.Buffer
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		if c == '"' {
+			return token.String(), nil
+		}
+		if c == '\\' && p.pos < len(p.data) {
+			next := p.data[p.pos]
+			p.pos++
+			switch next {
+			case 'n':
+				token.WriteByte('\n')
+			case 't':
+				token.WriteByte('\t')
+			case '"', '\\':
+				token.WriteByte(next)
+			default:
+				token.WriteByte(c)
+				token.WriteByte(next)
+			}
+			continue
+		}
+		token.WriteByte(c)
+	}
+	return "", errors.New("Unterminated string in VDF file.")
+}
+
+func (p *vdfParser) parseChildren(parent *VdfNode, nested bool) error {
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			if nested {
+				return errors.New("Unexpected end of VDF file, missing '}'.")
+			}
+			return nil
+		}
+		if p.data[p.pos] == '}' {
+			if !nested {
+				return errors.New("Unexpected '}' in VDF file.")
+			}
+			p.pos++
+			return nil
+		}
+		if p.data[p.pos] == '{' {
+			return errors.New("Unexpected '{' in VDF file, block has no key.")
+		}
+
+		key, err := p.readToken()
+		if err != nil {
+			return err
+		}
+		node := &VdfNode{Key: key}
+		parent.Children = append(parent.Children, node)
+
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return errors.New("Unexpected end of VDF file after key " + key + ".")
+		}
+		if p.data[p.pos] == '{' {
+			p.pos++
+			node.IsBlock = true
+			if err := p.parseChildren(node, true); err != nil {
+				return err
+			}
+		} else {
+			node.Value, err = p.readToken()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Returns the first child with the given key, ignoring case because Steam
+// isn't consistent about it ("Apps" vs "apps"). Returns nil if not found.
+func (n *VdfNode) Child(key string) *VdfNode {
+	if n == nil {
+		return nil
+	}
+	for _, child := range n.Children {
+		if strings.EqualFold(child.Key, key) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Follows a sequence of keys down the tree. Returns nil if any is missing.
+func (n *VdfNode) Path(keys ...string) *VdfNode {
+	for _, key := range keys {
+		n = n.Child(key)
+	}
+	return n
+}
+
+// Returns the value of the child with the given key, or "" if missing.
+func (n *VdfNode) Get(key string) string {
+	child := n.Child(key)
+	if child == nil {
+		return ""
+	}
+	return child.Value
+}
+
+// Returns the child block with the given key, creating it if necessary.
+func (n *VdfNode) Block(key string) *VdfNode {
+	child := n.Child(key)
+	if child == nil {
+		child = &VdfNode{Key: key, IsBlock: true}
+		n.Children = append(n.Children, child)
+	}
+	return child
+}
+
+// Sets the string value of a child, creating it if necessary.
+func (n *VdfNode) Set(key, value string) {
+	child := n.Child(key)
+	if child == nil {
+		child = &VdfNode{Key: key}
+		n.Children = append(n.Children, child)
+	}
+	child.Value = value
+}
+
+// Encodes the node's children in the same layout Steam uses, so the diff
+// against the original file is as small as possible.
+func (n *VdfNode) Bytes() []byte {
+	var buf bytes.Buffer
+	writeVdfChildren(&buf, n, 0)
+	return buf.Bytes()
+}
+
+var vdfEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+
+func writeVdfChildren(buf *bytes.Buffer, n *VdfNode, depth int) {
+	indent := strings.Repeat("\t", depth)
+	for _, child := range n.Children {
+		buf.WriteString(indent + `"` + vdfEscaper.Replace(child.Key) + `"`)
+		if child.IsBlock {
+			buf.WriteString("\n" + indent + "{\n")
+			writeVdfChildren(buf, child, depth+1)
+			buf.WriteString(indent + "}\n")
+		} else {
+			buf.WriteString("\t\t\"" + vdfEscaper.Replace(child.Value) + "\"\n")
+		}
+	}
+}
+
+// Parses a binary VDF document, the format of appcache/appinfo.vdf, into the
+// same nodes as a text one, with numbers written in decimal. Newer files
+// give keys as indexes in a string table instead of inline, in which case
+// the table is passed as keys.
+func ParseBinaryVdf(data []byte, keys []string) (*VdfNode, error) {
+	p := &binaryVdfParser{data: data, keys: keys}
+	root := &VdfNode{IsBlock: true}
+	if err := p.parseChildren(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+type binaryVdfParser struct {
+	data []byte
+	pos  int
+	keys []string
+}
+
+// Reads the next n bytes as a little endian number.
+func (p *binaryVdfParser) readUint(n int) (uint64, error) {
+	if p.pos+n > len(p.data) {
+		return 0, errors.New("Unexpected end of binary VDF file.")
+	}
+	var value uint64
+	for i := n - 1; i >= 0; i-- {
+		value = value<<8 | uint64(p.data[p.pos+i])
+	}
+	p.pos += n
+	return value, nil
+}
+
+// Reads a null terminated string.
+func (p *binaryVdfParser) readString() (string, error) {
+	end := bytes.IndexByte(p.data[p.pos:], 0)
+	if end < 0 {
+		return "", errors.New("Unterminated string in binary VDF file.")
+	}
+	value := string(p.data[p.pos : p.pos+end])
+	p.pos += end + 1
+	return value, nil
+}
+
+func (p *binaryVdfParser) readKey() (string, error) {
+	if p.keys == nil {
+		return p.readString()
+	}
+	index, err := p.readUint(4)
+	if err != nil {
+		return "", err
+	}
+	if index >= uint64(len(p.keys)) {
+		return "", errors.New("Key out of the string table in binary VDF file.")
+	}
+	return p.keys[index], nil
+}
+
+// Reads the children of a block, up to its end mark.
+func (p *binaryVdfParser) parseChildren(parent *VdfNode) error {
+	for {
+		kind, err := p.readUint(1)
+		if err != nil {
+			return err
+		}
+		// End of the block, 0x0b in some older files.
+		if kind == 0x08 || kind == 0x0b {
+			return nil
+		}
+		key, err := p.readKey()
+		if err != nil {
+			return err
+		}
+		node := &VdfNode{Key: key}
+		var value uint64
+		switch kind {
+		case 0x00:
+			node.IsBlock = true
+			err = p.parseChildren(node)
+		case 0x01:
+			node.Value, err = p.readString()
+		case 0x02, 0x04, 0x06:
+			// Integer, pointer and color, all 32 bits.
+			value, err = p.readUint(4)
+			node.Value = strconv.FormatInt(int64(int32(value)), 10)
+		case 0x03:
+			value, err = p.readUint(4)
+			node.Value = strconv.FormatFloat(float64(math.Float32frombits(uint32(value))), 'g', -1, 32)
+		case 0x07:
+			value, err = p.readUint(8)
+			node.Value = strconv.FormatUint(value, 10)
+		case 0x0a:
+			value, err = p.readUint(8)
+			node.Value = strconv.FormatInt(int64(value), 10)
+		default:
+			return errors.New("Unknown value type " + strconv.Itoa(int(kind)) + " in binary VDF file.")
+		}
+		if err != nil {
+			return err
+		}
+		parent.Children = append(parent.Children, node)
+	}
+}
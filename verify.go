@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Grid image or backup that can't be shown, and why.
+type BrokenImage struct {
+	Path    string
+	GameId  string
+	Asset   *AssetType
+	Problem string
+}
+
+// Returns what's wrong with an image file, or "" if it decodes fine.
+func checkImageFile(path string) string {
+	imageBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err.Error()
+	}
+	if len(imageBytes) == 0 {
+		return "empty file"
+	}
+	// Error pages saved by failed downloads.
+	if contentType := http.DetectContentType(imageBytes); strings.HasPrefix(contentType, "text/") {
+		return "a web page or text, not an image"
+	}
+	// Decoding the whole image also catches truncated downloads.
+	if _, _, err := image.Decode(bytes.NewBuffer(imageBytes)); err != nil {
+		logf(LogVerbose, "Failed to decode %v: %v", path, err.Error())
+		return "corrupt image"
+	}
+	return ""
+}
+
+// Checks every grid image of a user, and the backups of their originals,
+// skipping the protected games. Returns the broken ones.
+func VerifyGrid(user User, protected map[string]bool) ([]BrokenImage, error) {
+	broken := make([]BrokenImage, 0)
+	gridDir := getGridDir(user)
+	files, err := ioutil.ReadDir(gridDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, file := range files {
+		groups := gridFilePattern.FindStringSubmatch(file.Name())
+		if groups == nil || groups[3] != "" || file.IsDir() || protected[groups[1]] {
+			continue
+		}
+		path := filepath.Join(gridDir, file.Name())
+		if problem := checkImageFile(path); problem != "" {
+			broken = append(broken, BrokenImage{path, groups[1], getAssetTypeBySuffix(groups[2]), problem})
+		}
+	}
+
+	backups, err := listBackups(user)
+	if err != nil {
+		return broken, err
+	}
+	for _, backup := range backups {
+		if backup.Path == "" || protected[backup.GameId] {
+			continue
+		}
+		if problem := checkImageFile(backup.Path); problem != "" {
+			broken = append(broken, BrokenImage{backup.Path, backup.GameId, backup.Asset, problem})
+		}
+	}
+	return broken, nil
+}
+
+// Returns the flag set of the verify command, which has the options of the
+// download that repairs the images.
+func newVerifyFlags() *flag.FlagSet {
+	flags := newCommandFlags("verify")
+	addDownloadFlags(flags)
+	assetsFlag := flags.Lookup("assets")
+	assetsFlag.Value.Set("all")
+	assetsFlag.DefValue = "all"
+	flags.Lookup("dry-run").Usage = "Only list the broken images, without repairing them."
+	return flags
+}
+
+// Decodes every grid image and backup, lists the broken ones, and deletes
+// them so a download run for their games restores the originals from the
+// backups, or downloads them again.
+func runVerify(args []string) {
+	flags := newVerifyFlags()
+	parseCommandFlags(flags, args)
+	checkDownloadFlags()
+
+	_, users := loadUsers(flags.Args())
+	gameIds := make(map[string]bool)
+	brokenAssets := make(map[string]bool)
+	userIds := make([]string, 0, len(users))
+	for _, user := range users {
+		userIds = append(userIds, user.SteamId32)
+		// Without the profile, names in --protected only match local games.
+		games, _ := GetGames(user)
+		broken, err := VerifyGrid(user, getProtectedIds(games))
+		if err != nil {
+			errorAndExit(err)
+		}
+		for _, file := range broken {
+			fmt.Print(tr("Broken %v: %v\n", file.Path, tr(file.Problem)))
+			if *dryRun {
+				continue
+			}
+			paths := []string{file.Path}
+			if filepath.Dir(file.Path) != getGridDir(user) {
+				// Without the original, the grid image would become the new
+				// one, overlays and all.
+				for _, ext := range gridImageExts {
+					paths = append(paths, filepath.Join(getGridDir(user), file.GameId+file.Asset.Suffix+ext))
+				}
+			}
+			for _, path := range paths {
+				if err := removeFile(nil, path); err != nil {
+					errorAndExit(err)
+				}
+			}
+			gameIds[file.GameId] = true
+			brokenAssets[file.Asset.Name] = true
+		}
+		fmt.Print(tr("%v broken images found for %v.\n", len(broken), user.Name))
+	}
+	if len(gameIds) == 0 {
+		return
+	}
+
+	// The download does only the broken images, for the users already
+	// chosen, whatever the state of the last runs says.
+	ids := make([]string, 0, len(gameIds))
+	for id := range gameIds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	names := make([]string, 0, len(brokenAssets))
+	for _, asset := range assetTypes {
+		if brokenAssets[asset.Name] {
+			names = append(names, asset.Name)
+		}
+	}
+	*gameFilter = strings.Join(ids, ",")
+	*assetNames = strings.Join(names, ",")
+	*userFilter = strings.Join(userIds, ",")
+	*fullRun = true
+	fmt.Print(tr("\nRepairing the images of %v games...\n", len(ids)))
+	startDownloadRun(flags.Args())
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of the names of the versions, which sort by date.
+const versionTimeLayout = "20060102-150405"
+
+var (
+	keepVersions      = new(int)
+	keepVersionsDays  = new(int)
+	rollbackVersion   = new(int)
+	rollbackAssetName = new(string)
+)
+
+// Registers the retention options of the image versions.
+func addVersionFlags(flags *flag.FlagSet) {
+	flags.IntVar(keepVersions, "keep-versions", 5, "How many replaced versions of each image to keep, for the rollback command. 0 keeps none.")
+	flags.IntVar(keepVersionsDays, "keep-versions-days", 0, "Also delete versions older than this many days. 0 keeps them regardless of age.")
+}
+
+// Image replaced by a run, kept so it can be rolled back.
+type ImageVersion struct {
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// Returns the folder with the versions of the images of a game and asset
+// type, next to its backup.
+func getVersionsDir(user User, game *Game, asset *AssetType) string {
+	return filepath.Join(getImageBackupDir(user, game.Id, asset), "versions")
+}
+
+// Returns the versions of the images of a game, newest first.
+func GetImageVersions(user User, game *Game, asset *AssetType) ([]ImageVersion, error) {
+	dir := getVersionsDir(user, game, asset)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	versions := make([]ImageVersion, 0, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		// Versions saved in the same second get a counter.
+		date, err := time.ParseInLocation(versionTimeLayout, strings.SplitN(name, "_", 2)[0], time.Local)
+		if err != nil || file.IsDir() {
+			continue
+		}
+		versions = append(versions, ImageVersion{filepath.Join(dir, file.Name()), date, file.Size()})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Path > versions[j].Path
+	})
+	return versions, nil
+}
+
+// Saves the current grid image of a game as a new version, before it's
+// replaced, unless it's the same as the newest version. Then deletes the
+// versions beyond --keep-versions and older than --keep-versions-days.
+// Does nothing with --no-backup.
+func SaveImageVersion(user User, game *Game, asset *AssetType) error {
+	if *dryRun || *noBackup {
+		return nil
+	}
+	base := filepath.Join(getGridDir(user), game.Id+asset.Suffix)
+	var current []byte
+	var ext string
+	for _, ext = range gridImageExts {
+		var err error
+		if current, err = ioutil.ReadFile(base + ext); err == nil {
+			break
+		}
+	}
+	if current == nil || *keepVersions <= 0 {
+		return nil
+	}
+
+	versions, err := GetImageVersions(user, game, asset)
+	if err != nil {
+		return err
+	}
+	newest := []byte(nil)
+	if len(versions) > 0 {
+		newest, _ = ioutil.ReadFile(versions[0].Path)
+	}
+	if !bytes.Equal(current, newest) {
+		dir := getVersionsDir(user, game, asset)
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		name := time.Now().Format(versionTimeLayout)
+		for i := 1; fileExists(filepath.Join(dir, name+ext)); i++ {
+			name = time.Now().Format(versionTimeLayout) + "_" + strconv.Itoa(i)
+		}
+		path := filepath.Join(dir, name+ext)
+		if err := writeFile(game.Output, path, current); err != nil {
+			return err
+		}
+		logf(LogVerbose, "Saved the %v of %v as version %v", asset.Name, game.Id, path)
+		if versions, err = GetImageVersions(user, game, asset); err != nil {
+			return err
+		}
+	}
+
+	for i, version := range versions {
+		tooOld := *keepVersionsDays > 0 && time.Since(version.Time) > time.Duration(*keepVersionsDays)*24*time.Hour
+		if i >= *keepVersions || tooOld {
+			if err := removeFile(game.Output, version.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if there's a file at path.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Returns the flag set of the rollback command.
+func newRollbackFlags() *flag.FlagSet {
+	flags := newCommandFlags("rollback")
+	addUserFlags(flags)
+	addProtectedFlags(flags)
+	addVersionFlags(flags)
+	flags.StringVar(rollbackAssetName, "asset", "banner", "Asset type of the image: banner, portrait, hero or logo.")
+	flags.IntVar(rollbackVersion, "version", 0, "Version to go back to, 1 being the newest, instead of asking.")
+	return flags
+}
+
+// Lists the versions of the image of a game and puts the chosen one back.
+// The image it replaces becomes a version too, so rolling back can be
+// undone the same way.
+func runRollback(args []string) {
+	flags := newRollbackFlags()
+	parseCommandFlags(flags, args)
+	if flags.NArg() < 1 {
+		errorAndExit(errors.New(tr("Expected the game, like: steamgrid rollback 620")))
+	}
+	assets, err := GetAssetTypes(*rollbackAssetName)
+	if err != nil {
+		errorAndExit(err)
+	}
+	if len(assets) != 1 {
+		errorAndExit(errors.New(tr("The image is for a single asset type, got: %v", *rollbackAssetName)))
+	}
+	asset := assets[0]
+
+	_, users := loadUsers(flags.Args()[1:])
+	startJournal(strings.Join(os.Args[1:], " "))
+	for _, user := range users {
+		games, _ := GetGames(user)
+		game, err := FindGame(games, flags.Arg(0))
+		if err != nil {
+			errorAndExit(err)
+		}
+		if game == nil {
+			continue
+		}
+		if err := checkNotProtected(game); err != nil {
+			errorAndExit(err)
+		}
+		versions, err := GetImageVersions(user, game, asset)
+		if err != nil {
+			errorAndExit(err)
+		}
+		if len(versions) == 0 {
+			fmt.Print(tr("There are no older versions of the %v of %v for %v.\n", asset.Name, game.Name, user.Name))
+			continue
+		}
+
+		chosen := *rollbackVersion
+		if chosen == 0 {
+			if isHeadless() {
+				errorAndExit(errors.New(tr("Choose the version with --version, from 1 (the newest) to %v.", len(versions))))
+			}
+			chosen = askVersion(user, game, asset, versions)
+		}
+		if chosen < 1 || chosen > len(versions) {
+			errorAndExit(errors.New(tr("There's no version %v, expected 1 to %v.", chosen, len(versions))))
+		}
+		version := versions[chosen-1]
+		versionBytes, err := ioutil.ReadFile(version.Path)
+		if err != nil {
+			errorAndExit(err)
+		}
+		if err := SaveImageVersion(user, game, asset); err != nil {
+			errorAndExit(err)
+		}
+		gridDir := getGridDir(user)
+		for _, ext := range gridImageExts {
+			if err := removeFile(game.Output, filepath.Join(gridDir, game.Id+asset.Suffix+ext)); err != nil {
+				errorAndExit(err)
+			}
+		}
+		if err := writeFile(game.Output, filepath.Join(gridDir, game.Id+asset.Suffix+filepath.Ext(version.Path)), versionBytes); err != nil {
+			errorAndExit(err)
+		}
+		game.ImageBytes = versionBytes
+		if err := RecordInstalledImage(user, game, asset); err != nil {
+			errorAndExit(err)
+		}
+		fmt.Print(tr("The %v of %v is back to the version of %v for %v.\n", asset.Name, game.Name, version.Time.Format("2006-01-02 15:04"), user.Name))
+	}
+}
+
+// Lists the versions and asks which one to go back to. Returns 0 if the
+// input was closed.
+func askVersion(user User, game *Game, asset *AssetType, versions []ImageVersion) int {
+	fmt.Print(tr("\nVersions of the %v of %v for %v:\n", asset.Name, game.Name, user.Name))
+	for i, version := range versions {
+		fmt.Printf("  %v) %v, %v\n", i+1, version.Time.Format("2006-01-02 15:04:05"), formatBytes(version.Size))
+	}
+	for {
+		fmt.Print(tr("Which one should be put back? "))
+		answer, err := stdinReader.ReadString('\n')
+		if err != nil {
+			return 0
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(answer)); err == nil && n >= 1 && n <= len(versions) {
+			return n
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How often the watch command checks the Steam files, given with --interval.
+var watchInterval = new(time.Duration)
+
+// Modification times of the Steam files that are watched, by path.
+type watchSnapshot map[string]time.Time
+
+// Returns the categories and shortcuts files of a user, which change when
+// games are recategorized or non-Steam games are added.
+func getUserWatchFiles(user User) []string {
+	return []string{
+		filepath.Join(user.Dir, "7", "remote", "sharedconfig.vdf"),
+		filepath.Join(user.Dir, "config", "shortcuts.vdf"),
+	}
+}
+
+// Stats the files of every user and the manifests in every library.
+// Missing files are left out, so creating or deleting one is a change too.
+func takeWatchSnapshot(installationDir string, users []User) watchSnapshot {
+	snapshot := make(watchSnapshot)
+	paths := make([]string, 0)
+	for _, user := range users {
+		paths = append(paths, getUserWatchFiles(user)...)
+	}
+	for _, dir := range getLibraryDirs(installationDir) {
+		manifests, _ := filepath.Glob(filepath.Join(dir, "appmanifest_*.acf"))
+		paths = append(paths, manifests...)
+	}
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			snapshot[path] = info.ModTime()
+		}
+	}
+	return snapshot
+}
+
+// Returns the paths that were added, removed or modified between snapshots.
+func diffWatchSnapshots(old watchSnapshot, new watchSnapshot) []string {
+	changed := make([]string, 0)
+	for path, modTime := range new {
+		if oldTime, ok := old[path]; !ok || !oldTime.Equal(modTime) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range old {
+		if _, ok := new[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
+
+// Returns the categories of every game of a user found in the local files,
+// joined in a string that is easy to compare.
+func getLocalTags(user User) map[string]string {
+	games := make(map[string]*Game)
+	addUnknownGames(user, games)
+	addNonSteamGames(user, games)
+	tags := make(map[string]string)
+	for id, game := range games {
+		sorted := append([]string{}, game.Tags...)
+		sort.Strings(sorted)
+		tags[id] = strings.Join(sorted, "\x00")
+	}
+	return tags
+}
+
+// Returns the flag set of the watch command.
+func newWatchFlags() *flag.FlagSet {
+	flags := newCommandFlags("watch")
+	addDownloadFlags(flags)
+	flags.DurationVar(watchInterval, "interval", 10*time.Second, "How often to check the Steam files for changes, like '30s' or '5m'.")
+	addScheduleFlags(flags)
+	return flags
+}
+
+// Applies the images and overlays of every game, and then watches the Steam
+// files for changes: when games are recategorized, installed or uninstalled,
+// their images are done again, so the overlays follow. With --refresh, every
+// game is also done again on a schedule.
+func runWatch(args []string) {
+	flags := newWatchFlags()
+	parseCommandFlags(flags, args)
+	// It runs unattended, nobody is there to press enter.
+	*headless = true
+	*terminalUI = false
+	checkDownloadFlags()
+	checkForUpdates()
+	if *watchInterval < time.Second {
+		*watchInterval = time.Second
+	}
+	scheduled, err := startSchedule()
+	if err != nil {
+		errorAndExit(err)
+	}
+
+	// The profile names are needed to match --games by name later.
+	installationDir, users := loadUsers(flags.Args())
+	userFilterGames := *gameFilter
+	names := make(map[string]string)
+	tags := make(map[string]map[string]string)
+	for _, user := range users {
+		games, _ := GetGames(user)
+		for id, game := range games {
+			names[id] = game.Name
+		}
+		tags[user.Dir] = getLocalTags(user)
+	}
+
+	// Stopping from the console or the service manager lets the game being
+	// processed finish, so no image is left half written.
+	progress := newConsoleProgress()
+
+	runDownload(flags.Args(), progress)
+
+	fmt.Print(tr("\nWatching for changes every %v. Press Ctrl+C to stop.\n", *watchInterval))
+	snapshot := takeWatchSnapshot(installationDir, users)
+	changed := make(map[string]bool)
+	for {
+		select {
+		case <-progress.stopped:
+			return
+		case <-scheduled:
+			fmt.Print(tr("\n%v: scheduled run.\n", time.Now().Format("2006-01-02 15:04:05")))
+			runDownload(flags.Args(), progress)
+			continue
+		case <-time.After(*watchInterval):
+		}
+		newSnapshot := takeWatchSnapshot(installationDir, users)
+		paths := diffWatchSnapshots(snapshot, newSnapshot)
+		snapshot = newSnapshot
+
+		for _, path := range paths {
+			if groups := manifestPattern.FindStringSubmatch(filepath.Base(path)); groups != nil {
+				changed[groups[1]] = true
+				continue
+			}
+			for _, user := range users {
+				if containsString(getUserWatchFiles(user), path) {
+					newTags := getLocalTags(user)
+					for id, gameTags := range newTags {
+						if oldTags, ok := tags[user.Dir][id]; !ok || oldTags != gameTags {
+							changed[id] = true
+						}
+					}
+					for id := range tags[user.Dir] {
+						if _, ok := newTags[id]; !ok {
+							changed[id] = true
+						}
+					}
+					tags[user.Dir] = newTags
+				}
+			}
+		}
+
+		// Steam writes its files a few times in a row, so wait until they
+		// settle before running.
+		if len(paths) > 0 || len(changed) == 0 {
+			continue
+		}
+
+		ids := make([]string, 0, len(changed))
+		for id := range changed {
+			game := &Game{Id: id, Name: names[id]}
+			if userFilterGames == "" || matchesAnyIdOrName(game, userFilterGames) {
+				ids = append(ids, id)
+			}
+		}
+		changed = make(map[string]bool)
+		if len(ids) == 0 {
+			continue
+		}
+		sort.Strings(ids)
+
+		fmt.Print(tr("\n%v: %v games changed, updating their images.\n", time.Now().Format("2006-01-02 15:04:05"), len(ids)))
+		*gameFilter = strings.Join(ids, ",")
+		runDownload(flags.Args(), progress)
+		*gameFilter = userFilterGames
+	}
+}
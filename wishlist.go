@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Public wishlist of a user, by SteamId64. Only works for public profiles,
+// like the game list.
+const wishlistUrlFormat = `https://api.steampowered.com/IWishlistService/GetWishlist/v1/?steamid=%v`
+
+// Returns the app ids in the user's wishlist.
+func GetWishlist(user User) ([]string, error) {
+	var wishlist struct {
+		Response struct {
+			Items []struct {
+				AppId int
+			}
+		}
+	}
+	found, err := getJson(fmt.Sprintf(wishlistUrlFormat, user.SteamId64), &wishlist)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(wishlist.Response.Items))
+	for _, item := range wishlist.Response.Items {
+		ids = append(ids, strconv.Itoa(item.AppId))
+	}
+	return ids, nil
+}
+
+// Path of a pre-fetched image in the cache.
+func getCachedImagePath(appId string) (string, error) {
+	cacheDir, err := getCacheDir("images")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, appId+".jpg"), nil
+}
+
+// Loads a pre-fetched image into the game, returning false if there's none.
+func loadCachedImage(game *Game) bool {
+	cachePath, err := getCachedImagePath(game.Id)
+	if err != nil {
+		return false
+	}
+	if !fileExists(cachePath) {
+		return false
+	}
+	game.ImageBytes, game.ImageFile = nil, cachePath
+	game.ImageSource = "cache"
+	game.ImageUrl = cachePath
+	return true
+}
+
+// Downloads the official images of every game in the user's wishlist into
+// the cache, so they are installed without network access as soon as the
+// games are bought. Games already cached are skipped. Search results are
+// never cached, since nobody would review them. Returns the number of new
+// images.
+func PrefetchWishlist(user User) (int, error) {
+	ids, err := GetWishlist(user)
+	if err != nil {
+		return 0, err
+	}
+
+	nFetched := 0
+	for _, id := range ids {
+		cachePath, err := getCachedImagePath(id)
+		if err != nil {
+			return nFetched, err
+		}
+		if _, err := os.Stat(cachePath); err == nil {
+			continue
+		}
+
+		game := &Game{Id: id}
+		candidates := NewImageCandidates(game, bannerAsset)
+		response, fromSearch, err := candidates.Next()
+		if err != nil {
+			return nFetched, err
+		}
+		if response == nil {
+			continue
+		}
+		imagePath, err := readDownload(candidates.Url, response)
+		if err != nil {
+			return nFetched, err
+		}
+		if fromSearch {
+			continue
+		}
+
+		file, err := os.Open(imagePath)
+		if err != nil {
+			return nFetched, err
+		}
+		err = writeReaderAtomic(cachePath, file, 0644)
+		file.Close()
+		if err != nil {
+			return nFetched, err
+		}
+		nFetched++
+	}
+	return nFetched, nil
+}